@@ -17,9 +17,12 @@ type authedctxkey int
 
 const (
 	KeyUID authedctxkey = iota
+	KeyRoles
 )
 
 type AuthProvider interface {
 	AuthenticateRequest(context.Context, *http.Request) (context.Context, error)
 	GetUIDFromRequest(*http.Request) (string, error)
+	// GetRolesFromRequest returns the roles of the authenticated player, used by role based route policies
+	GetRolesFromRequest(*http.Request) ([]string, error)
 }
@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ALG_HS256 = "HS256"
+	ALG_RS256 = "RS256"
+)
+
+const (
+	AUTHORIZATION_HEADER      = "Authorization"
+	BEARER_PREFIX             = "Bearer "
+	DEFAULT_TOKEN_QUERY_PARAM = "access_token"
+	DEFAULT_CLOCK_SKEW_S      = 30
+)
+
+var (
+	ErrTokenMissing     = fmt.Errorf("%w: no token in request", ErrUnauthorized)
+	ErrTokenMalformed   = fmt.Errorf("%w: malformed token", ErrUnauthorized)
+	ErrTokenAlgorithm   = fmt.Errorf("%w: unsupported token algorithm", ErrUnauthorized)
+	ErrTokenSignature   = fmt.Errorf("%w: invalid token signature", ErrUnauthorized)
+	ErrTokenExpired     = fmt.Errorf("%w: token has expired", ErrUnauthorized)
+	ErrTokenNotYetValid = fmt.Errorf("%w: token is not valid yet", ErrUnauthorized)
+	ErrTokenIssuer      = fmt.Errorf("%w: unexpected token issuer", ErrUnauthorized)
+	ErrTokenAudience    = fmt.Errorf("%w: unexpected token audience", ErrUnauthorized)
+	ErrTokenSubject     = fmt.Errorf("%w: token has no subject", ErrUnauthorized)
+	ErrJWTKeysMissing   = errors.New("jwt auth provider needs an HMAC secret or an RSA public key")
+	ErrJWTInvalidRSAKey = errors.New("pem block does not contain an RSA public key")
+	ErrJWTCannotIssue   = errors.New("jwt auth provider needs an HMAC secret or an RSA private key to issue tokens")
+)
+
+// Audience is the aud claim, which can either be a single string or a list of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) (err error) {
+	var single string
+	if err = json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return
+	}
+	var list []string
+	if err = json.Unmarshal(data, &list); err != nil {
+		return
+	}
+	*a = list
+	return
+}
+
+// Contains returns true if the audience includes aud
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered JWT claims checked by the JWT auth provider
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// JWTConfig configures the JWT auth provider
+//
+// Setting HMACSecret accepts HS256 tokens and setting RSAPublicKey accepts RS256 tokens.
+// Issuer and Audience are only checked if set. RSAPrivateKey is only needed to issue RS256 tokens
+type JWTConfig struct {
+	HMACSecret    []byte
+	RSAPublicKey  *rsa.PublicKey
+	RSAPrivateKey *rsa.PrivateKey
+	Issuer        string
+	Audience      string
+	// ClockSkew is the leeway given when checking exp and nbf, defaults to DEFAULT_CLOCK_SKEW_S
+	ClockSkew time.Duration
+	// QueryParam is checked for the token if there is no Authorization header, defaults to DEFAULT_TOKEN_QUERY_PARAM
+	QueryParam string
+}
+
+// JWTAuthProvider authenticates requests with a JWT bearer token
+//
+// The token is read from the Authorization header, or from a query parameter since browsers
+// can't set headers on websocket upgrades. The token's subject is used as the player's UID
+type JWTAuthProvider struct {
+	config JWTConfig
+	now    func() time.Time
+}
+
+func NewJWTAuthProvider(config JWTConfig) (p *JWTAuthProvider, err error) {
+	if config.RSAPrivateKey != nil && config.RSAPublicKey == nil {
+		config.RSAPublicKey = &config.RSAPrivateKey.PublicKey
+	}
+	if len(config.HMACSecret) == 0 && config.RSAPublicKey == nil {
+		err = ErrJWTKeysMissing
+		return
+	}
+	if config.ClockSkew == 0 {
+		config.ClockSkew = DEFAULT_CLOCK_SKEW_S * time.Second
+	}
+	if config.QueryParam == "" {
+		config.QueryParam = DEFAULT_TOKEN_QUERY_PARAM
+	}
+	p = &JWTAuthProvider{
+		config: config,
+		now:    time.Now,
+	}
+	return
+}
+
+// ParseRSAPublicKeyPEM parses a PEM encoded PKIX or PKCS1 RSA public key
+func ParseRSAPublicKeyPEM(data []byte) (key *rsa.PublicKey, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = ErrJWTInvalidRSAKey
+		return
+	}
+	if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return
+	}
+	var pub interface{}
+	if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return
+	}
+	var ok bool
+	if key, ok = pub.(*rsa.PublicKey); !ok {
+		err = ErrJWTInvalidRSAKey
+	}
+	return
+}
+
+func (p *JWTAuthProvider) AuthenticateRequest(ctx context.Context, r *http.Request) (context.Context, error) {
+	token := p.tokenFromRequest(r)
+	if token == "" {
+		return ctx, ErrTokenMissing
+	}
+	claims, err := p.ParseToken(token)
+	if err != nil {
+		return ctx, err
+	}
+	ctx = context.WithValue(ctx, KeyUID, claims.Subject)
+	ctx = context.WithValue(ctx, KeyRoles, claims.Roles)
+	return ctx, nil
+}
+
+// GetUIDFromRequest returns the UID stored by AuthenticateRequest on the request's context
+func (p *JWTAuthProvider) GetUIDFromRequest(r *http.Request) (uid string, err error) {
+	var ok bool
+	if uid, ok = r.Context().Value(KeyUID).(string); !ok || uid == "" {
+		err = ErrUnknownIdentity
+	}
+	return
+}
+
+// GetRolesFromRequest returns the roles claim stored by AuthenticateRequest on the request's context
+func (p *JWTAuthProvider) GetRolesFromRequest(r *http.Request) (roles []string, err error) {
+	if _, err = p.GetUIDFromRequest(r); err != nil {
+		return
+	}
+	roles, _ = r.Context().Value(KeyRoles).([]string)
+	return
+}
+
+func (p *JWTAuthProvider) tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get(AUTHORIZATION_HEADER); strings.HasPrefix(header, BEARER_PREFIX) {
+		return strings.TrimSpace(strings.TrimPrefix(header, BEARER_PREFIX))
+	}
+	return r.URL.Query().Get(p.config.QueryParam)
+}
+
+// ParseToken verifies the token's signature and checks its claims
+func (p *JWTAuthProvider) ParseToken(token string) (claims Claims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = ErrTokenMalformed
+		return
+	}
+
+	var header jwtHeader
+	if err = decodeSegment(parts[0], &header); err != nil {
+		return
+	}
+	var signature []byte
+	if signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		err = ErrTokenMalformed
+		return
+	}
+	if err = p.verifySignature(header.Algorithm, parts[0]+"."+parts[1], signature); err != nil {
+		return
+	}
+
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return
+	}
+	err = p.validateClaims(claims)
+	return
+}
+
+func (p *JWTAuthProvider) verifySignature(alg string, signed string, signature []byte) (err error) {
+	switch {
+	case alg == ALG_HS256 && len(p.config.HMACSecret) > 0:
+		mac := hmac.New(sha256.New, p.config.HMACSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			err = ErrTokenSignature
+		}
+	case alg == ALG_RS256 && p.config.RSAPublicKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(p.config.RSAPublicKey, crypto.SHA256, digest[:], signature) != nil {
+			err = ErrTokenSignature
+		}
+	default:
+		err = ErrTokenAlgorithm
+	}
+	return
+}
+
+func (p *JWTAuthProvider) validateClaims(claims Claims) (err error) {
+	now := p.now()
+	skew := int64(p.config.ClockSkew / time.Second)
+	switch {
+	case claims.Subject == "":
+		err = ErrTokenSubject
+	case claims.ExpiresAt == 0 || now.Unix() > claims.ExpiresAt+skew:
+		err = ErrTokenExpired
+	case claims.NotBefore != 0 && now.Unix() < claims.NotBefore-skew:
+		err = ErrTokenNotYetValid
+	case p.config.Issuer != "" && claims.Issuer != p.config.Issuer:
+		err = ErrTokenIssuer
+	case p.config.Audience != "" && !claims.Audience.Contains(p.config.Audience):
+		err = ErrTokenAudience
+	}
+	return
+}
+
+// IssueToken signs a token for the subject that expires after ttl
+//
+// Tokens are signed with RS256 if an RSA private key is configured, otherwise with HS256
+func (p *JWTAuthProvider) IssueToken(subject string, ttl time.Duration) (token string, err error) {
+	now := p.now()
+	claims := Claims{
+		Subject:   subject,
+		Issuer:    p.config.Issuer,
+		ExpiresAt: now.Add(ttl).Unix(),
+		IssuedAt:  now.Unix(),
+	}
+	if p.config.Audience != "" {
+		claims.Audience = Audience{p.config.Audience}
+	}
+
+	header := jwtHeader{Algorithm: ALG_HS256, Type: "JWT"}
+	if p.config.RSAPrivateKey != nil {
+		header.Algorithm = ALG_RS256
+	} else if len(p.config.HMACSecret) == 0 {
+		err = ErrJWTCannotIssue
+		return
+	}
+
+	var headerSegment, claimsSegment string
+	if headerSegment, err = encodeSegment(header); err != nil {
+		return
+	}
+	if claimsSegment, err = encodeSegment(claims); err != nil {
+		return
+	}
+	signed := headerSegment + "." + claimsSegment
+
+	var signature []byte
+	if header.Algorithm == ALG_RS256 {
+		digest := sha256.Sum256([]byte(signed))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, p.config.RSAPrivateKey, crypto.SHA256, digest[:]); err != nil {
+			return
+		}
+	} else {
+		mac := hmac.New(sha256.New, p.config.HMACSecret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	token = signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return
+}
+
+func encodeSegment(src interface{}) (segment string, err error) {
+	var data []byte
+	if data, err = json.Marshal(src); err != nil {
+		return
+	}
+	segment = base64.RawURLEncoding.EncodeToString(data)
+	return
+}
+
+func decodeSegment(segment string, dst interface{}) (err error) {
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(segment); err != nil {
+		err = ErrTokenMalformed
+		return
+	}
+	if err = json.Unmarshal(data, dst); err != nil {
+		err = ErrTokenMalformed
+	}
+	return
+}
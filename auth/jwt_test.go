@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func signTestToken(t *testing.T, alg string, key interface{}, claims interface{}) string {
+	header, err := json.Marshal(jwtHeader{Algorithm: alg, Type: "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch alg {
+	case ALG_HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case ALG_RS256:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTAuthProvider(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+
+	p, err := NewJWTAuthProvider(JWTConfig{
+		HMACSecret: secret,
+		Issuer:     "sgs",
+		Audience:   "players",
+		ClockSkew:  10 * time.Second,
+	})
+	require.NoError(t, err)
+	p.now = func() time.Time { return now }
+
+	validClaims := func() Claims {
+		return Claims{
+			Subject:   "p1_id",
+			Issuer:    "sgs",
+			Audience:  Audience{"players"},
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		}
+	}
+
+	t.Run("no keys configured", func(t *testing.T) {
+		_, err := NewJWTAuthProvider(JWTConfig{})
+		require.ErrorIs(t, err, ErrJWTKeysMissing)
+	})
+
+	t.Run("token from header", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/game/join", nil)
+		r.Header.Set(AUTHORIZATION_HEADER, BEARER_PREFIX+signTestToken(t, ALG_HS256, secret, validClaims()))
+
+		ctx, err := p.AuthenticateRequest(context.Background(), r)
+		require.NoError(t, err)
+		uid, err := p.GetUIDFromRequest(r.WithContext(ctx))
+		require.NoError(t, err)
+		require.Equal(t, "p1_id", uid)
+	})
+
+	t.Run("roles from claims", func(t *testing.T) {
+		claims := validClaims()
+		claims.Roles = []string{ROLE_ADMIN}
+		r := httptest.NewRequest(http.MethodGet, "/admin/games", nil)
+		r.Header.Set(AUTHORIZATION_HEADER, BEARER_PREFIX+signTestToken(t, ALG_HS256, secret, claims))
+
+		ctx, err := p.AuthenticateRequest(context.Background(), r)
+		require.NoError(t, err)
+		roles, err := p.GetRolesFromRequest(r.WithContext(ctx))
+		require.NoError(t, err)
+		require.Equal(t, []string{ROLE_ADMIN}, roles)
+
+		_, err = AdminPolicy.Authorize(context.Background(), r, p, "")
+		require.NoError(t, err)
+		_, err = RolePolicy("moderator").Authorize(context.Background(), r, p, "")
+		require.ErrorIs(t, err, ErrForbidden)
+	})
+
+	t.Run("token from query parameter", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/game/join?access_token="+signTestToken(t, ALG_HS256, secret, validClaims()), nil)
+
+		ctx, err := p.AuthenticateRequest(context.Background(), r)
+		require.NoError(t, err)
+		require.Equal(t, "p1_id", ctx.Value(KeyUID))
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/game/join", nil)
+		_, err := p.AuthenticateRequest(context.Background(), r)
+		require.ErrorIs(t, err, ErrTokenMissing)
+		require.ErrorIs(t, err, ErrUnauthorized)
+
+		_, err = p.GetUIDFromRequest(r)
+		require.ErrorIs(t, err, ErrUnknownIdentity)
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			token  string
+			claims func(c *Claims)
+			err    error
+		}{
+			{name: "malformed", token: "not.a.token", err: ErrTokenMalformed},
+			{name: "wrong secret", token: signTestToken(t, ALG_HS256, []byte("other"), validClaims()), err: ErrTokenSignature},
+			{name: "unsigned", token: signTestToken(t, "none", nil, validClaims()), err: ErrTokenAlgorithm},
+			{name: "expired", claims: func(c *Claims) { c.ExpiresAt = now.Add(-11 * time.Second).Unix() }, err: ErrTokenExpired},
+			{name: "no expiry", claims: func(c *Claims) { c.ExpiresAt = 0 }, err: ErrTokenExpired},
+			{name: "not valid yet", claims: func(c *Claims) { c.NotBefore = now.Add(11 * time.Second).Unix() }, err: ErrTokenNotYetValid},
+			{name: "wrong issuer", claims: func(c *Claims) { c.Issuer = "other" }, err: ErrTokenIssuer},
+			{name: "wrong audience", claims: func(c *Claims) { c.Audience = Audience{"admins"} }, err: ErrTokenAudience},
+			{name: "no subject", claims: func(c *Claims) { c.Subject = "" }, err: ErrTokenSubject},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				token := tt.token
+				if tt.claims != nil {
+					claims := validClaims()
+					tt.claims(&claims)
+					token = signTestToken(t, ALG_HS256, secret, claims)
+				}
+				_, err := p.ParseToken(token)
+				require.ErrorIs(t, err, tt.err)
+			})
+		}
+	})
+
+	t.Run("clock skew tolerance", func(t *testing.T) {
+		claims := validClaims()
+		claims.ExpiresAt = now.Add(-5 * time.Second).Unix()
+		claims.NotBefore = now.Add(5 * time.Second).Unix()
+		_, err := p.ParseToken(signTestToken(t, ALG_HS256, secret, claims))
+		require.NoError(t, err)
+	})
+
+	t.Run("audience as a string", func(t *testing.T) {
+		token := signTestToken(t, ALG_HS256, secret, map[string]interface{}{
+			"sub": "p1_id",
+			"iss": "sgs",
+			"aud": "players",
+			"exp": now.Add(time.Minute).Unix(),
+		})
+		claims, err := p.ParseToken(token)
+		require.NoError(t, err)
+		require.Equal(t, Audience{"players"}, claims.Audience)
+	})
+
+	t.Run("RS256", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		rsaProvider, err := NewJWTAuthProvider(JWTConfig{RSAPublicKey: &key.PublicKey})
+		require.NoError(t, err)
+
+		claims, err := rsaProvider.ParseToken(signTestToken(t, ALG_RS256, key, validClaims()))
+		require.NoError(t, err)
+		require.Equal(t, "p1_id", claims.Subject)
+
+		// HS256 tokens are rejected when only an RSA key is configured
+		_, err = rsaProvider.ParseToken(signTestToken(t, ALG_HS256, secret, validClaims()))
+		require.ErrorIs(t, err, ErrTokenAlgorithm)
+	})
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gunnermanx/simplegameserver/common"
+)
+
+const (
+	ROLE_ADMIN = "admin"
+)
+
+var (
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Access is who a route is open to
+type Access int
+
+const (
+	// ACCESS_PLAYER requires the request to be authenticated by the auth provider
+	ACCESS_PLAYER Access = iota
+	// ACCESS_PUBLIC serves the request without authenticating it
+	ACCESS_PUBLIC
+	// ACCESS_SERVER requires the shared server secret, for server to server requests
+	ACCESS_SERVER
+)
+
+// Policy decides which requests a route serves
+//
+// If Roles is set, the authenticated player must have at least one of them
+type Policy struct {
+	Access Access
+	Roles  []string
+}
+
+var (
+	PublicPolicy = Policy{Access: ACCESS_PUBLIC}
+	PlayerPolicy = Policy{Access: ACCESS_PLAYER}
+	ServerPolicy = Policy{Access: ACCESS_SERVER}
+	AdminPolicy  = RolePolicy(ROLE_ADMIN)
+)
+
+// RolePolicy returns a policy for authenticated players with any of the roles
+func RolePolicy(roles ...string) Policy {
+	return Policy{
+		Access: ACCESS_PLAYER,
+		Roles:  roles,
+	}
+}
+
+// Authorize checks the request against the policy and returns the context to serve it with
+//
+// Failed checks return an error wrapping ErrUnauthorized if the request couldn't be authenticated,
+// or ErrForbidden if the player doesn't have a required role
+func (policy Policy) Authorize(
+	ctx context.Context,
+	r *http.Request,
+	ap AuthProvider,
+	serverSecret string,
+) (context.Context, error) {
+	switch policy.Access {
+	case ACCESS_PUBLIC:
+		return ctx, nil
+	case ACCESS_SERVER:
+		if !common.VerifyServerSecret(r, serverSecret) {
+			return ctx, errors.New("invalid server secret")
+		}
+		return ctx, nil
+	}
+
+	ctx, err := ap.AuthenticateRequest(ctx, r)
+	if err != nil {
+		return ctx, err
+	}
+	if len(policy.Roles) == 0 {
+		return ctx, nil
+	}
+
+	roles, err := ap.GetRolesFromRequest(r.WithContext(ctx))
+	if err != nil {
+		return ctx, err
+	}
+	for _, required := range policy.Roles {
+		for _, role := range roles {
+			if role == required {
+				return ctx, nil
+			}
+		}
+	}
+	return ctx, ErrForbidden
+}
+
+// StatusCode returns the http status code for an error returned by Authorize
+func StatusCode(err error) int {
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// FailureReason returns a short label for an error returned by Authorize, used to count rejected requests
+func FailureReason(err error) string {
+	if errors.Is(err, ErrForbidden) {
+		return "forbidden"
+	}
+	return "unauthorized"
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/gunnermanx/simplegameserver/datastore"
+	"github.com/gunnermanx/simplegameserver/datastore/model"
+	pkg_errors "github.com/pkg/errors"
+)
+
+const (
+	GUEST_PATH   = "/auth/guest"
+	REFRESH_PATH = "/auth/refresh"
+)
+
+const (
+	DEFAULT_ACCESS_TOKEN_TTL_S  = 15 * 60
+	DEFAULT_REFRESH_TOKEN_TTL_S = 30 * 24 * 60 * 60
+)
+
+// Error codes returned alongside refresh errors so clients know when to start a new session
+const (
+	REFRESH_CODE_INVALID = "refresh_token_invalid"
+	REFRESH_CODE_EXPIRED = "refresh_token_expired"
+	REFRESH_CODE_REVOKED = "refresh_token_revoked"
+	REFRESH_CODE_REUSED  = "refresh_token_reused"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// TokenIssuer signs access tokens, it is implemented by JWTAuthProvider
+type TokenIssuer interface {
+	IssueToken(subject string, ttl time.Duration) (string, error)
+}
+
+// SessionResponse is returned when a session is created or refreshed
+type SessionResponse struct {
+	UserID       string `json:"userID"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// RefreshRequest is the request body of /auth/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// SessionIssuer creates guest accounts and issues access and refresh tokens for them
+//
+// Refresh tokens are single use. Refreshing returns a new refresh token in the same family,
+// and presenting a used refresh token revokes the whole family. Access tokens already issued
+// to the family stay valid until they expire, so AccessTokenTTL should be short
+type SessionIssuer struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	tokens    TokenIssuer
+	datastore datastore.Datastore
+	now       func() time.Time
+}
+
+func NewSessionIssuer(tokens TokenIssuer, ds datastore.Datastore) (si *SessionIssuer) {
+	si = &SessionIssuer{
+		AccessTokenTTL:  DEFAULT_ACCESS_TOKEN_TTL_S * time.Second,
+		RefreshTokenTTL: DEFAULT_REFRESH_TOKEN_TTL_S * time.Second,
+		tokens:          tokens,
+		datastore:       ds,
+		now:             time.Now,
+	}
+	return
+}
+
+// CreateGuest creates a new guest user and starts a session for it
+func (si *SessionIssuer) CreateGuest() (session SessionResponse, err error) {
+	user := model.User{
+		ID:        uuid.New().String(),
+		Guest:     true,
+		CreatedAt: si.now(),
+	}
+	if err = si.datastore.CreateUser(user); err != nil {
+		err = pkg_errors.Wrap(err, "failed creating guest user")
+		return
+	}
+	session, err = si.issueSession(user.ID, uuid.New().String())
+	return
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token
+func (si *SessionIssuer) Refresh(refreshToken string) (session SessionResponse, err error) {
+	if refreshToken == "" {
+		err = ErrRefreshTokenInvalid
+		return
+	}
+
+	var token model.RefreshToken
+	if token, err = si.datastore.UseRefreshToken(hashRefreshToken(refreshToken)); err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			err = ErrRefreshTokenInvalid
+		} else {
+			err = pkg_errors.Wrap(err, "failed using refresh token")
+		}
+		return
+	}
+
+	switch {
+	case token.Revoked:
+		err = ErrRefreshTokenRevoked
+		return
+	case token.Used:
+		// Either the client or an attacker is holding a stolen token, so neither can keep the session
+		if err = si.datastore.RevokeRefreshTokenFamily(token.FamilyID); err != nil {
+			err = pkg_errors.Wrap(err, "failed revoking refresh token family")
+			return
+		}
+		err = ErrRefreshTokenReused
+		return
+	case !si.now().Before(token.ExpiresAt):
+		err = ErrRefreshTokenExpired
+		return
+	}
+
+	session, err = si.issueSession(token.UserID, token.FamilyID)
+	return
+}
+
+// issueSession issues an access token and a refresh token in the given family
+func (si *SessionIssuer) issueSession(userID string, familyID string) (session SessionResponse, err error) {
+	session = SessionResponse{
+		UserID:    userID,
+		ExpiresIn: int(si.AccessTokenTTL / time.Second),
+	}
+	if session.AccessToken, err = si.tokens.IssueToken(userID, si.AccessTokenTTL); err != nil {
+		err = pkg_errors.Wrap(err, "failed issuing access token")
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return
+	}
+	session.RefreshToken = base64.RawURLEncoding.EncodeToString(secret)
+	if err = si.datastore.SaveRefreshToken(model.RefreshToken{
+		ID:        hashRefreshToken(session.RefreshToken),
+		UserID:    userID,
+		FamilyID:  familyID,
+		ExpiresAt: si.now().Add(si.RefreshTokenTTL),
+	}); err != nil {
+		err = pkg_errors.Wrap(err, "failed saving refresh token")
+	}
+	return
+}
+
+// hashRefreshToken returns the ID a refresh token is stored under
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// GuestHandler handles /auth/guest, it must be registered as an unauthenticated route
+func (si *SessionIssuer) GuestHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := si.CreateGuest()
+	if err != nil {
+		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	common.WriteJSONResponse(w, http.StatusCreated, session)
+}
+
+// RefreshHandler handles /auth/refresh, it must be registered as an unauthenticated route
+func (si *SessionIssuer) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var statusCode int
+	var req RefreshRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+
+	var session SessionResponse
+	if session, err = si.Refresh(req.RefreshToken); err != nil {
+		var code string
+		switch {
+		case errors.Is(err, ErrRefreshTokenInvalid):
+			code = REFRESH_CODE_INVALID
+		case errors.Is(err, ErrRefreshTokenExpired):
+			code = REFRESH_CODE_EXPIRED
+		case errors.Is(err, ErrRefreshTokenRevoked):
+			code = REFRESH_CODE_REVOKED
+		case errors.Is(err, ErrRefreshTokenReused):
+			code = REFRESH_CODE_REUSED
+		default:
+			common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		common.WriteResponse(w, http.StatusUnauthorized, common.ResponseData{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+	common.WriteJSONResponse(w, http.StatusOK, session)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/datastore"
+	"github.com/gunnermanx/simplegameserver/datastore/model"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryDatastore stores users and refresh tokens for the session tests
+type memoryDatastore struct {
+	mutex         sync.Mutex
+	users         map[string]model.User
+	refreshTokens map[string]model.RefreshToken
+}
+
+func newMemoryDatastore() *memoryDatastore {
+	return &memoryDatastore{
+		users:         make(map[string]model.User),
+		refreshTokens: make(map[string]model.RefreshToken),
+	}
+}
+
+func (ds *memoryDatastore) FindUser(playerID string) (model.User, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	user, exists := ds.users[playerID]
+	if !exists {
+		return user, datastore.ErrNotFound
+	}
+	return user, nil
+}
+
+func (ds *memoryDatastore) CreateUser(user model.User) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	ds.users[user.ID] = user
+	return nil
+}
+
+func (ds *memoryDatastore) FindMatchmakingData(playerID string) (model.MatchmakingData, error) {
+	return model.MatchmakingData{}, datastore.ErrNotFound
+}
+
+func (ds *memoryDatastore) SaveRefreshToken(token model.RefreshToken) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	ds.refreshTokens[token.ID] = token
+	return nil
+}
+
+func (ds *memoryDatastore) UseRefreshToken(tokenID string) (model.RefreshToken, error) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	token, exists := ds.refreshTokens[tokenID]
+	if !exists {
+		return token, datastore.ErrNotFound
+	}
+	used := token
+	used.Used = true
+	ds.refreshTokens[tokenID] = used
+	return token, nil
+}
+
+func (ds *memoryDatastore) RevokeRefreshTokenFamily(familyID string) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	for id, token := range ds.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			ds.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (ds *memoryDatastore) UpdateMatchmakingData(
+	playerIDs []string,
+	update func(data map[string]model.MatchmakingData) (map[string]model.MatchmakingData, error),
+) error {
+	return nil
+}
+
+func (ds *memoryDatastore) SaveGameResult(result model.GameResult) error {
+	return nil
+}
+
+func TestSessionIssuer(t *testing.T) {
+	now := time.Now()
+
+	newIssuer := func(t *testing.T) (*SessionIssuer, *JWTAuthProvider, *memoryDatastore) {
+		p, err := NewJWTAuthProvider(JWTConfig{HMACSecret: []byte("secret")})
+		require.NoError(t, err)
+		p.now = func() time.Time { return now }
+		ds := newMemoryDatastore()
+		si := NewSessionIssuer(p, ds)
+		si.now = func() time.Time { return now }
+		return si, p, ds
+	}
+
+	t.Run("guest session", func(t *testing.T) {
+		si, p, ds := newIssuer(t)
+
+		session, err := si.CreateGuest()
+		require.NoError(t, err)
+		require.True(t, ds.users[session.UserID].Guest)
+		require.Equal(t, DEFAULT_ACCESS_TOKEN_TTL_S, session.ExpiresIn)
+
+		claims, err := p.ParseToken(session.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, session.UserID, claims.Subject)
+
+		// Only the hash of the refresh token is stored
+		require.Len(t, ds.refreshTokens, 1)
+		require.NotContains(t, ds.refreshTokens, session.RefreshToken)
+	})
+
+	t.Run("refresh rotates tokens", func(t *testing.T) {
+		si, _, _ := newIssuer(t)
+
+		session, err := si.CreateGuest()
+		require.NoError(t, err)
+		refreshed, err := si.Refresh(session.RefreshToken)
+		require.NoError(t, err)
+		require.Equal(t, session.UserID, refreshed.UserID)
+		require.NotEqual(t, session.RefreshToken, refreshed.RefreshToken)
+
+		_, err = si.Refresh(refreshed.RefreshToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("reused refresh token revokes the family", func(t *testing.T) {
+		si, _, _ := newIssuer(t)
+
+		session, err := si.CreateGuest()
+		require.NoError(t, err)
+		refreshed, err := si.Refresh(session.RefreshToken)
+		require.NoError(t, err)
+
+		_, err = si.Refresh(session.RefreshToken)
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+		_, err = si.Refresh(refreshed.RefreshToken)
+		require.ErrorIs(t, err, ErrRefreshTokenRevoked)
+	})
+
+	t.Run("invalid and expired refresh tokens", func(t *testing.T) {
+		si, _, _ := newIssuer(t)
+
+		_, err := si.Refresh("")
+		require.ErrorIs(t, err, ErrRefreshTokenInvalid)
+		_, err = si.Refresh("unknown")
+		require.ErrorIs(t, err, ErrRefreshTokenInvalid)
+
+		session, err := si.CreateGuest()
+		require.NoError(t, err)
+		si.now = func() time.Time { return now.Add(si.RefreshTokenTTL) }
+		_, err = si.Refresh(session.RefreshToken)
+		require.ErrorIs(t, err, ErrRefreshTokenExpired)
+	})
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// SERVER_SECRET_HEADER carries the shared secret on server to server requests
+const (
+	SERVER_SECRET_HEADER = "X-SGS-Server-Secret"
+	ALLOCATE_GAME_PATH   = "/game/allocate"
+)
+
+// AllocateGameRequest is sent by the matchmaking server to a game server to create a game for a match
+//
+// Teams holds the player IDs on each side for game modes with teams, every player on a team must be in PlayerIDs
+type AllocateGameRequest struct {
+	MatchID               string     `json:"matchID"`
+	GameType              string     `json:"gameType"`
+	PlayerIDs             []string   `json:"playerIDs"`
+	Teams                 [][]string `json:"teams,omitempty"`
+	WaitForPlayersTimeout int        `json:"waitForPlayersTimeout"`
+}
+
+// AllocateGameResponse tells the matchmaking server where the players of a match should connect
+type AllocateGameResponse struct {
+	GameID  string `json:"gameID"`
+	Address string `json:"address"`
+}
+
+// VerifyServerSecret checks the shared secret on a server to server request
+// Requests are always rejected if no secret is configured
+func VerifyServerSecret(r *http.Request, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	provided := r.Header.Get(SERVER_SECRET_HEADER)
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1
+}
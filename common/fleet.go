@@ -0,0 +1,21 @@
+package common
+
+// HEARTBEAT_PATH is where game servers report their status to the matchmaking server
+const (
+	HEARTBEAT_PATH = "/fleet/heartbeat"
+)
+
+// GameServerHeartbeat is sent periodically by each game server to the matchmaking server
+//
+// URL is where the matchmaking server reaches the game server to allocate games,
+// Address is the public address players connect to. Draining servers don't accept new games
+type GameServerHeartbeat struct {
+	ServerID string `json:"serverID"`
+	URL      string `json:"url"`
+	Address  string `json:"address"`
+	Region   string `json:"region"`
+	Capacity int    `json:"capacity"`
+	Games    int    `json:"games"`
+	Players  int    `json:"players"`
+	Draining bool   `json:"draining"`
+}
@@ -0,0 +1,45 @@
+package common
+
+import "time"
+
+// GAME_RESULT_PATH is where game servers report the results of completed games to the matchmaking server
+const (
+	GAME_RESULT_PATH = "/fleet/game-result"
+)
+
+// Reasons a game ended, reported in its result
+const (
+	GAME_END_REASON_COMPLETED         = "completed"
+	GAME_END_REASON_TIMED_OUT_WAITING = "timed_out_waiting_for_players"
+	GAME_END_REASON_ENDED             = "ended"
+	GAME_END_REASON_ERROR             = "error"
+)
+
+// GameResult is the outcome of a game, built by the game server when the game ends
+//
+// Winners, Teams and Players are reported by the game, an empty Winners on a completed game is a draw.
+// Teams groups players that win or lose together and defaults to the teams the game was allocated with,
+// players that aren't in a team play on their own.
+// MatchID is only set for games allocated by the matchmaking server.
+// EndDetail explains how the game ended where the EndReason alone doesn't, such as the reason an operator ended it
+type GameResult struct {
+	GameID    string                  `json:"gameID"`
+	GameType  string                  `json:"gameType"`
+	MatchID   string                  `json:"matchID,omitempty"`
+	ServerID  string                  `json:"serverID,omitempty"`
+	EndReason string                  `json:"endReason"`
+	EndDetail string                  `json:"endDetail,omitempty"`
+	PlayerIDs []string                `json:"playerIDs"`
+	Winners   []string                `json:"winners,omitempty"`
+	Teams     [][]string              `json:"teams,omitempty"`
+	Players   map[string]PlayerResult `json:"players,omitempty"`
+	Ticks     uint64                  `json:"ticks"`
+	StartedAt time.Time               `json:"startedAt"`
+	EndedAt   time.Time               `json:"endedAt"`
+}
+
+// PlayerResult is a single player's score and game specific stats
+type PlayerResult struct {
+	Score float64            `json:"score"`
+	Stats map[string]float64 `json:"stats,omitempty"`
+}
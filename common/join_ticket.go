@@ -0,0 +1,209 @@
+package common
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JOIN_TICKET_PARAM is the query parameter carrying the join ticket, browsers can't set headers on websocket upgrades
+	JOIN_TICKET_PARAM         = "ticket"
+	DEFAULT_JOIN_TICKET_TTL_S = 60
+)
+
+// Error codes returned alongside join ticket errors so clients can tell them apart
+const (
+	JOIN_TICKET_CODE_MISSING  = "join_ticket_missing"
+	JOIN_TICKET_CODE_INVALID  = "join_ticket_invalid"
+	JOIN_TICKET_CODE_EXPIRED  = "join_ticket_expired"
+	JOIN_TICKET_CODE_MISMATCH = "join_ticket_mismatch"
+	JOIN_TICKET_CODE_REPLAYED = "join_ticket_replayed"
+)
+
+var (
+	ErrJoinTicketMissing  = errors.New("join ticket is required")
+	ErrJoinTicketInvalid  = errors.New("join ticket is malformed or has an invalid signature")
+	ErrJoinTicketExpired  = errors.New("join ticket has expired")
+	ErrJoinTicketMismatch = errors.New("join ticket was issued for a different player or game")
+	ErrJoinTicketReplayed = errors.New("join ticket has already been used")
+)
+
+// JoinTicketClaims are the fields bound by a join ticket's signature
+type JoinTicketClaims struct {
+	PlayerID  string `json:"p"`
+	GameID    string `json:"g"`
+	ExpiresAt int64  `json:"e"`
+	Nonce     string `json:"n"`
+}
+
+// SignJoinTicket mints a ticket allowing the player to join the game until ttl has passed
+//
+// The ticket is the base64 encoded claims and their HMAC-SHA256 signature, separated by a "."
+func SignJoinTicket(secret string, playerID string, gameID string, ttl time.Duration, now time.Time) (ticket string, err error) {
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	var payload []byte
+	if payload, err = json.Marshal(JoinTicketClaims{
+		PlayerID:  playerID,
+		GameID:    gameID,
+		ExpiresAt: now.Add(ttl).Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+	}); err != nil {
+		return
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	ticket = encoded + "." + base64.RawURLEncoding.EncodeToString(signJoinTicketPayload(secret, encoded))
+	return
+}
+
+func signJoinTicketPayload(secret string, encoded string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// ParseJoinTicket checks the ticket's signature, expiry and that it was issued for the player and game
+func ParseJoinTicket(secret string, ticket string, playerID string, gameID string, now time.Time) (claims JoinTicketClaims, err error) {
+	if ticket == "" {
+		err = ErrJoinTicketMissing
+		return
+	}
+	parts := strings.Split(ticket, ".")
+	if len(parts) != 2 {
+		err = ErrJoinTicketInvalid
+		return
+	}
+	var signature []byte
+	if signature, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		err = ErrJoinTicketInvalid
+		return
+	}
+	if !hmac.Equal(signature, signJoinTicketPayload(secret, parts[0])) {
+		err = ErrJoinTicketInvalid
+		return
+	}
+	var payload []byte
+	if payload, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		err = ErrJoinTicketInvalid
+		return
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Nonce == "" {
+		err = ErrJoinTicketInvalid
+		return
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		err = ErrJoinTicketExpired
+		return
+	}
+	if claims.PlayerID != playerID || claims.GameID != gameID {
+		err = ErrJoinTicketMismatch
+	}
+	return
+}
+
+// JoinTicketVerifier verifies join tickets and rejects tickets that were already used
+type JoinTicketVerifier struct {
+	Secret string
+
+	mutex sync.Mutex
+	// used maps the nonces of consumed tickets to their expiry, after which they no longer need tracking
+	used map[string]int64
+	// expiries holds the consumed nonces ordered by expiry, so expired nonces are pruned without scanning used
+	expiries usedNonces
+}
+
+// usedNonce is the nonce of a consumed ticket and when the ticket expires
+type usedNonce struct {
+	nonce     string
+	expiresAt int64
+}
+
+// usedNonces is a min-heap of consumed nonces by expiry, it implements heap.Interface
+type usedNonces []usedNonce
+
+func (n usedNonces) Len() int            { return len(n) }
+func (n usedNonces) Less(i, j int) bool  { return n[i].expiresAt < n[j].expiresAt }
+func (n usedNonces) Swap(i, j int)       { n[i], n[j] = n[j], n[i] }
+func (n *usedNonces) Push(x interface{}) { *n = append(*n, x.(usedNonce)) }
+func (n *usedNonces) Pop() interface{} {
+	old := *n
+	last := old[len(old)-1]
+	*n = old[:len(old)-1]
+	return last
+}
+
+func NewJoinTicketVerifier(secret string) (v *JoinTicketVerifier) {
+	v = &JoinTicketVerifier{
+		Secret: secret,
+		used:   make(map[string]int64),
+	}
+	return
+}
+
+// Verify parses the ticket and consumes it so it can't be used again
+//
+// If the player can't join after all, Release gives the ticket back so the player can retry with it
+func (v *JoinTicketVerifier) Verify(ticket string, playerID string, gameID string, now time.Time) (claims JoinTicketClaims, err error) {
+	if claims, err = ParseJoinTicket(v.Secret, ticket, playerID, gameID, now); err != nil {
+		return
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for len(v.expiries) > 0 && now.Unix() >= v.expiries[0].expiresAt {
+		delete(v.used, heap.Pop(&v.expiries).(usedNonce).nonce)
+	}
+	if _, used := v.used[claims.Nonce]; used {
+		err = ErrJoinTicketReplayed
+		return
+	}
+	v.used[claims.Nonce] = claims.ExpiresAt
+	heap.Push(&v.expiries, usedNonce{nonce: claims.Nonce, expiresAt: claims.ExpiresAt})
+	return
+}
+
+// Release makes a ticket consumed by Verify usable again, for when the player failed to join with it
+func (v *JoinTicketVerifier) Release(claims JoinTicketClaims) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.used, claims.Nonce)
+}
+
+// JoinTicketTTL returns the configured ticket lifetime, falling back to the default if unset
+func JoinTicketTTL(ttlS int) time.Duration {
+	if ttlS <= 0 {
+		ttlS = DEFAULT_JOIN_TICKET_TTL_S
+	}
+	return time.Duration(ttlS) * time.Second
+}
+
+// WriteJoinTicketErrorResponse writes a join ticket error with its error code
+func WriteJoinTicketErrorResponse(w http.ResponseWriter, err error) {
+	statusCode, code := http.StatusUnauthorized, JOIN_TICKET_CODE_INVALID
+	switch {
+	case errors.Is(err, ErrJoinTicketMissing):
+		code = JOIN_TICKET_CODE_MISSING
+	case errors.Is(err, ErrJoinTicketExpired):
+		code = JOIN_TICKET_CODE_EXPIRED
+	case errors.Is(err, ErrJoinTicketMismatch):
+		statusCode, code = http.StatusForbidden, JOIN_TICKET_CODE_MISMATCH
+	case errors.Is(err, ErrJoinTicketReplayed):
+		statusCode, code = http.StatusForbidden, JOIN_TICKET_CODE_REPLAYED
+	}
+	WriteResponse(w, statusCode, ResponseData{
+		"error": err.Error(),
+		"code":  code,
+	})
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinTicket(t *testing.T) {
+	secret := "secret"
+	now := time.Now()
+
+	ticket, err := SignJoinTicket(secret, "p1_id", "game1_id", time.Minute, now)
+	require.NoError(t, err)
+
+	t.Run("valid ticket", func(t *testing.T) {
+		claims, err := ParseJoinTicket(secret, ticket, "p1_id", "game1_id", now)
+		require.NoError(t, err)
+		require.Equal(t, "p1_id", claims.PlayerID)
+		require.Equal(t, "game1_id", claims.GameID)
+	})
+
+	t.Run("missing ticket", func(t *testing.T) {
+		_, err := ParseJoinTicket(secret, "", "p1_id", "game1_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketMissing)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		_, err := ParseJoinTicket("other_secret", ticket, "p1_id", "game1_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketInvalid)
+		_, err = ParseJoinTicket(secret, "not_a_ticket", "p1_id", "game1_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketInvalid)
+	})
+
+	t.Run("expired ticket", func(t *testing.T) {
+		_, err := ParseJoinTicket(secret, ticket, "p1_id", "game1_id", now.Add(time.Minute))
+		require.ErrorIs(t, err, ErrJoinTicketExpired)
+	})
+
+	t.Run("mismatched player or game", func(t *testing.T) {
+		_, err := ParseJoinTicket(secret, ticket, "p2_id", "game1_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketMismatch)
+		_, err = ParseJoinTicket(secret, ticket, "p1_id", "game2_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketMismatch)
+	})
+
+	t.Run("replayed ticket", func(t *testing.T) {
+		v := NewJoinTicketVerifier(secret)
+		_, err := v.Verify(ticket, "p1_id", "game1_id", now)
+		require.NoError(t, err)
+		_, err = v.Verify(ticket, "p1_id", "game1_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketReplayed)
+
+		// Nonces of expired tickets are no longer tracked
+		later := now.Add(time.Minute)
+		next, err := SignJoinTicket(secret, "p1_id", "game1_id", time.Minute, later)
+		require.NoError(t, err)
+		_, err = v.Verify(next, "p1_id", "game1_id", later)
+		require.NoError(t, err)
+		require.Len(t, v.used, 1)
+		require.Len(t, v.expiries, 1)
+	})
+
+	t.Run("released ticket", func(t *testing.T) {
+		v := NewJoinTicketVerifier(secret)
+		claims, err := v.Verify(ticket, "p1_id", "game1_id", now)
+		require.NoError(t, err)
+		v.Release(claims)
+		_, err = v.Verify(ticket, "p1_id", "game1_id", now)
+		require.NoError(t, err)
+		_, err = v.Verify(ticket, "p1_id", "game1_id", now)
+		require.ErrorIs(t, err, ErrJoinTicketReplayed)
+	})
+}
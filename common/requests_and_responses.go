@@ -49,8 +49,13 @@ func UnmarshalJSONRequestBody(w http.ResponseWriter, r *http.Request, dst interf
 }
 
 func WriteResponse(w http.ResponseWriter, statusCode int, response ResponseData) {
-	w.WriteHeader(statusCode)
+	WriteJSONResponse(w, statusCode, response)
+}
+
+// WriteJSONResponse writes any JSON encodable response, for responses that don't fit in ResponseData
+func WriteJSONResponse(w http.ResponseWriter, statusCode int, response interface{}) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 
 	var responseBytes []byte
 	var err error
@@ -7,9 +7,27 @@ import (
 )
 
 type GameServerConfig struct {
-	DebugMode      bool
-	Port           string
-	TickIntervalMS int
+	DebugMode             bool
+	Port                  string
+	TickIntervalMS        int
+	ReconnectGracePeriodS int
+	MaxSpectatorsPerGame  int
+	SpectatorDelayS       int
+	ReplayDir             string
+	PublicAddress         string
+	AllocationSecret      string
+	ServerID              string
+	InternalURL           string
+	Region                string
+	MaxGames              int
+	MatchmakerURL         string
+	HeartbeatIntervalS    int
+	JoinTicketSecret      string
+	JoinTicketTTLS        int
+	DrainTimeoutS         int
+	SaveGameResults       bool
+	PostGameHookAttempts  int
+	PostGameHookRetryMS   int
 }
 
 func LoadGameServerConfig() (sc *GameServerConfig, err error) {
@@ -23,17 +41,42 @@ func LoadGameServerConfig() (sc *GameServerConfig, err error) {
 	}
 
 	sc = &GameServerConfig{
-		Port:           viper.GetString("server.port"),
-		DebugMode:      viper.GetBool("server.debugMode"),
-		TickIntervalMS: viper.GetInt("server.tickIntervalMS"),
+		Port:                  viper.GetString("server.port"),
+		DebugMode:             viper.GetBool("server.debugMode"),
+		TickIntervalMS:        viper.GetInt("server.tickIntervalMS"),
+		ReconnectGracePeriodS: viper.GetInt("server.reconnectGracePeriodS"),
+		MaxSpectatorsPerGame:  viper.GetInt("server.maxSpectatorsPerGame"),
+		SpectatorDelayS:       viper.GetInt("server.spectatorDelayS"),
+		ReplayDir:             viper.GetString("server.replayDir"),
+		PublicAddress:         viper.GetString("server.publicAddress"),
+		AllocationSecret:      viper.GetString("server.allocationSecret"),
+		ServerID:              viper.GetString("server.serverID"),
+		InternalURL:           viper.GetString("server.internalURL"),
+		Region:                viper.GetString("server.region"),
+		MaxGames:              viper.GetInt("server.maxGames"),
+		MatchmakerURL:         viper.GetString("server.matchmakerURL"),
+		HeartbeatIntervalS:    viper.GetInt("server.heartbeatIntervalS"),
+		JoinTicketSecret:      viper.GetString("server.joinTicketSecret"),
+		JoinTicketTTLS:        viper.GetInt("server.joinTicketTTLS"),
+		DrainTimeoutS:         viper.GetInt("server.drainTimeoutS"),
+		SaveGameResults:       viper.GetBool("server.saveGameResults"),
+		PostGameHookAttempts:  viper.GetInt("server.postGameHookAttempts"),
+		PostGameHookRetryMS:   viper.GetInt("server.postGameHookRetryMS"),
 	}
 
 	return
 }
 
 type MatchmakingServerConfig struct {
-	DebugMode bool
-	Port      string
+	DebugMode             bool
+	Port                  string
+	MatchmakingIntervalMS int
+	GameServerTTLS        int
+	GameType              string
+	AllocationSecret      string
+	JoinTicketSecret      string
+	JoinTicketTTLS        int
+	MaxPartySize          int
 }
 
 func LoadMatchmakingServerConfig() (sc *MatchmakingServerConfig, err error) {
@@ -47,8 +90,15 @@ func LoadMatchmakingServerConfig() (sc *MatchmakingServerConfig, err error) {
 	}
 
 	sc = &MatchmakingServerConfig{
-		Port:      viper.GetString("server.port"),
-		DebugMode: viper.GetBool("server.debugMode"),
+		Port:                  viper.GetString("server.port"),
+		DebugMode:             viper.GetBool("server.debugMode"),
+		MatchmakingIntervalMS: viper.GetInt("server.matchmakingIntervalMS"),
+		GameServerTTLS:        viper.GetInt("server.gameServerTTLS"),
+		GameType:              viper.GetString("server.gameType"),
+		AllocationSecret:      viper.GetString("server.allocationSecret"),
+		JoinTicketSecret:      viper.GetString("server.joinTicketSecret"),
+		JoinTicketTTLS:        viper.GetInt("server.joinTicketTTLS"),
+		MaxPartySize:          viper.GetInt("server.maxPartySize"),
 	}
 
 	return
@@ -1,12 +1,37 @@
 package datastore
 
 import (
+	"errors"
+
 	"github.com/gunnermanx/simplegameserver/datastore/model"
 )
 
 //go:generate mockgen -destination=../mocks/mock_datastore.go -package=mocks github.com/gunnermanx/simplegameserver/datastore Datastore
 
+var (
+	ErrNotFound = errors.New("record not found")
+)
+
 type Datastore interface {
 	FindUser(playerID string) (model.User, error)
+	CreateUser(user model.User) error
 	FindMatchmakingData(playerID string) (model.MatchmakingData, error)
+	// UpdateMatchmakingData loads the matchmaking data of the players, passes it to update and saves what update
+	// returns in a single transaction, so concurrent updates to the same players don't overwrite each other.
+	// Players without matchmaking data are passed to update with only their ID set.
+	// Nothing is saved if update returns an error or a nil map
+	UpdateMatchmakingData(
+		playerIDs []string,
+		update func(data map[string]model.MatchmakingData) (map[string]model.MatchmakingData, error),
+	) error
+
+	SaveRefreshToken(token model.RefreshToken) error
+	// UseRefreshToken atomically marks the token as used and returns it as it was before,
+	// so concurrent uses of the same token see it as used at most once
+	UseRefreshToken(tokenID string) (model.RefreshToken, error)
+	RevokeRefreshTokenFamily(familyID string) error
+
+	// SaveGameResult stores the result of a game, saving a result again for the same game ID
+	// should overwrite it since post-game hooks are retried
+	SaveGameResult(result model.GameResult) error
 }
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// GameResult is the stored outcome of a game, keyed by the game's ID
+type GameResult struct {
+	ID        string
+	GameType  string
+	MatchID   string
+	ServerID  string
+	EndReason string
+	EndDetail string
+	PlayerIDs []string
+	Winners   []string
+	Teams     [][]string
+	Players   map[string]PlayerResult
+	Ticks     uint64
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// PlayerResult is a player's score and stats in a stored game result
+type PlayerResult struct {
+	Score float64
+	Stats map[string]float64
+}
@@ -1,6 +1,19 @@
 package model
 
+import "time"
+
+// MatchmakingData is a player's rating and match history as used by the matchmaking server
+//
+// Deviation and Volatility are only used by Glicko-2 ratings
 type MatchmakingData struct {
-	ID     string
-	Rating int
+	ID          string
+	Rating      float64
+	Deviation   float64
+	Volatility  float64
+	GamesPlayed int
+	Wins        int
+	Losses      int
+	Draws       int
+	// LastPlayedAt is when the rating was last updated, zero if the player hasn't played
+	LastPlayedAt time.Time
 }
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// RefreshToken is a single use token that is exchanged for a new access token and refresh token
+//
+// Tokens issued by refreshing share the FamilyID of the token they replaced, so the whole
+// chain can be revoked if a used token is presented again
+type RefreshToken struct {
+	// ID is the hash of the token, the token itself is never stored
+	ID        string
+	UserID    string
+	FamilyID  string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
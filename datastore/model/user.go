@@ -1,5 +1,9 @@
 package model
 
+import "time"
+
 type User struct {
-	ID string
+	ID        string
+	Guest     bool
+	CreatedAt time.Time
 }
@@ -0,0 +1,191 @@
+package game
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ADMIN_GAMES_PATH       = "/admin/games"
+	ADMIN_GAME_PATH        = "/admin/game"
+	ADMIN_END_GAME_PATH    = "/admin/game/end"
+	ADMIN_KICK_PLAYER_PATH = "/admin/game/kick"
+	ADMIN_BROADCAST_PATH   = "/admin/game/broadcast"
+	ADMIN_PLAYERS_PATH     = "/admin/players"
+)
+
+type AdminEndGameRequest struct {
+	GameID string `json:"gameID"`
+	Reason string `json:"reason"`
+}
+
+type AdminKickPlayerRequest struct {
+	GameID   string `json:"gameID"`
+	PlayerID string `json:"playerID"`
+	Reason   string `json:"reason"`
+}
+
+type AdminBroadcastRequest struct {
+	GameID  string `json:"gameID"`
+	Message string `json:"message"`
+}
+
+type AdminGamesResponse struct {
+	Games []game.GameInfo `json:"games"`
+}
+
+type AdminPlayersResponse struct {
+	PlayerIDs []string `json:"playerIDs"`
+}
+
+// listGames returns the info of every game on the server, oldest first
+func (sgs *SimpleGameServer) listGames() (infos []game.GameInfo) {
+	sgs.gamesMutex.RLock()
+	games := make([]*game.Game, 0, len(sgs.games))
+	for _, g := range sgs.games {
+		games = append(games, g)
+	}
+	sgs.gamesMutex.RUnlock()
+
+	infos = make([]game.GameInfo, 0, len(games))
+	for _, g := range games {
+		infos = append(infos, g.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].CreatedAt != infos[j].CreatedAt {
+			return infos[i].CreatedAt < infos[j].CreatedAt
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return
+}
+
+func (sgs *SimpleGameServer) adminGamesHandler(w http.ResponseWriter, r *http.Request) {
+	common.WriteJSONResponse(w, http.StatusOK, AdminGamesResponse{
+		Games: sgs.listGames(),
+	})
+}
+
+func (sgs *SimpleGameServer) adminGameHandler(w http.ResponseWriter, r *http.Request) {
+	gameID := r.URL.Query().Get("id")
+	if gameID == "" {
+		common.WriteErrorResponse(w, http.StatusBadRequest, "missing or invalid id parameter")
+		return
+	}
+	g, err := sgs.getGame(gameID)
+	if err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	common.WriteJSONResponse(w, http.StatusOK, g.Info())
+}
+
+func (sgs *SimpleGameServer) adminPlayersHandler(w http.ResponseWriter, r *http.Request) {
+	response := AdminPlayersResponse{
+		PlayerIDs: []string{},
+	}
+	sgs.playersMutex.RLock()
+	for playerID := range sgs.players {
+		response.PlayerIDs = append(response.PlayerIDs, playerID)
+	}
+	sgs.playersMutex.RUnlock()
+	sort.Strings(response.PlayerIDs)
+	common.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+func (sgs *SimpleGameServer) adminEndGameHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var statusCode int
+	var req AdminEndGameRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+
+	var g *game.Game
+	if g, err = sgs.getGame(req.GameID); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err = g.End(req.Reason); err != nil {
+		writeAdminGameError(w, err)
+		return
+	}
+
+	sgs.logger.WithFields(logrus.Fields{
+		"gameID": req.GameID,
+		"reason": req.Reason,
+	}).Warn("admin ended game")
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+func (sgs *SimpleGameServer) adminKickPlayerHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var statusCode int
+	var req AdminKickPlayerRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+
+	var g *game.Game
+	if g, err = sgs.getGame(req.GameID); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err = g.Kick(req.PlayerID, req.Reason); err != nil {
+		writeAdminGameError(w, err)
+		return
+	}
+
+	sgs.logger.WithFields(logrus.Fields{
+		"gameID":   req.GameID,
+		"playerID": req.PlayerID,
+		"reason":   req.Reason,
+	}).Warn("admin kicked player")
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+func (sgs *SimpleGameServer) adminBroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var statusCode int
+	var req AdminBroadcastRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+	if req.Message == "" {
+		common.WriteErrorResponse(w, http.StatusBadRequest, "message field is missing or empty")
+		return
+	}
+
+	var g *game.Game
+	if g, err = sgs.getGame(req.GameID); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err = g.Notify(messages.NewSystemMessage(req.Message)); err != nil {
+		writeAdminGameError(w, err)
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+// writeAdminGameError writes the response for an error returned by a game control
+func writeAdminGameError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sgs_errors.ErrGamePlayerNotFound):
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, sgs_errors.ErrGameEnded):
+		common.WriteErrorResponse(w, http.StatusConflict, err.Error())
+	default:
+		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,92 @@
+package game
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_DRAIN_TIMEOUT_S = 300
+)
+
+func (sgs *SimpleGameServer) isDraining() bool {
+	return atomic.LoadInt32(&sgs.draining) == 1
+}
+
+func (sgs *SimpleGameServer) drainTimeout() time.Duration {
+	timeoutS := sgs.config.DrainTimeoutS
+	if timeoutS <= 0 {
+		timeoutS = DEFAULT_DRAIN_TIMEOUT_S
+	}
+	return time.Duration(timeoutS) * time.Second
+}
+
+// Drain stops the server from accepting new games and players and waits for running games to complete
+//
+// Players in running games are sent a SERVER_SHUTDOWN message with the deadline, and can still
+// reconnect to their seats while the server drains. Games that haven't started are ended immediately,
+// and games still running at the deadline are ended and their players disconnected
+func (sgs *SimpleGameServer) Drain(timeout time.Duration) {
+	// Flag under the games lock so no game is created once draining has started
+	sgs.gamesMutex.Lock()
+	if !atomic.CompareAndSwapInt32(&sgs.draining, 0, 1) {
+		sgs.gamesMutex.Unlock()
+		return
+	}
+	games := make([]*game.Game, 0, len(sgs.games))
+	for _, g := range sgs.games {
+		games = append(games, g)
+	}
+	sgs.gamesMutex.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	sgs.logger.WithFields(logrus.Fields{
+		"games":    len(games),
+		"deadline": deadline,
+	}).Info("draining game server")
+
+	// Let the matchmaking server know right away rather than on the next heartbeat
+	if sgs.config.MatchmakerURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), HEARTBEAT_TIMEOUT_S*time.Second)
+		if err := sgs.sendHeartbeat(ctx, newHeartbeatClient()); err != nil {
+			sgs.logger.WithField("error", err.Error()).Warn("failed reporting drain to matchmaking server")
+		}
+		cancel()
+	}
+
+	for _, g := range games {
+		if !g.Started() {
+			g.Shutdown()
+			continue
+		}
+		go g.Notify(messages.NewServerShutdownMessage(deadline))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sgs.gamesRunning.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		sgs.logger.Info("all games completed, finished draining")
+	case <-time.After(time.Until(deadline)):
+		sgs.gamesMutex.RLock()
+		remaining := make([]*game.Game, 0, len(sgs.games))
+		for _, g := range sgs.games {
+			remaining = append(remaining, g)
+		}
+		sgs.gamesMutex.RUnlock()
+
+		sgs.logger.WithField("games", len(remaining)).Warn("drain deadline reached, ending running games")
+		for _, g := range remaining {
+			g.Shutdown()
+		}
+	}
+}
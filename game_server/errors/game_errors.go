@@ -9,4 +9,20 @@ var (
 	ErrGameTimedOutWaitingForPlayers = errors.New("timed out waiting for players")
 	ErrGameFull                      = errors.New("game is full")
 	ErrGamePlayerAlreadyExists       = errors.New("player is already in the game")
+	ErrGameSpectatorsFull            = errors.New("game has reached the maximum number of spectators")
+	ErrGamePlayerNotAllowed          = errors.New("player is not part of the game's allocation")
+	ErrGameInvalidNumPlayers         = errors.New("number of players is not allowed for the game type")
+	ErrGameInvalidTeams              = errors.New("teams must only have allocated players, each on a single team")
+	ErrGameTypeNotFound              = errors.New("unknown game type")
+	ErrGameTypeAlreadyRegistered     = errors.New("game type is already registered")
+	ErrGameTypeInvalid               = errors.New("game type must have a name, init and tick, and valid player bounds")
+	ErrServerFull                    = errors.New("server has reached the maximum number of games")
+	ErrServerDraining                = errors.New("server is draining and not accepting new games or players")
+	ErrGameEnded                     = errors.New("game has ended")
+	ErrGamePlayerNotFound            = errors.New("player is not in the game")
+	ErrGamePlayerNotInvited          = errors.New("player was not invited to the game")
+	ErrJoinTicketsNotConfigured      = errors.New("join tickets are not configured")
+	ErrLockstepInputTooFarAhead      = errors.New("lockstep input is too far ahead of the current turn")
+	ErrLockstepDuplicateInput        = errors.New("player already sent input for the turn")
+	ErrLockstepInputLate             = errors.New("lockstep input is for a turn that was already broadcast")
 )
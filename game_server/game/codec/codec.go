@@ -0,0 +1,82 @@
+package game_codec
+
+import (
+	"encoding/json"
+	"errors"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"nhooyr.io/websocket"
+)
+
+// Codecs are negotiated with clients using the websocket subprotocol
+const (
+	JSON_SUBPROTOCOL    = "sgs.json"
+	MSGPACK_SUBPROTOCOL = "sgs.msgpack"
+	RAW_SUBPROTOCOL     = "sgs.raw"
+)
+
+var (
+	ErrCodecUnsupportedData = errors.New("codec does not support the message data type")
+	ErrCodecMalformed       = errors.New("malformed message")
+)
+
+// Codec encodes and decodes game messages sent over a player's websocket connection
+type Codec interface {
+	// Subprotocol is the websocket subprotocol clients use to request the codec
+	Subprotocol() string
+	// MessageType is the websocket message type used for encoded messages
+	MessageType() websocket.MessageType
+	Encode(messages.GameMessage) ([]byte, error)
+	Decode([]byte) (messages.GameMessage, error)
+}
+
+// Defaults returns the codecs a server supports when none are configured, JSON is preferred
+// RawCodec isn't included, games that do their own serialization opt in to it through their game type's codecs
+func Defaults() []Codec {
+	return []Codec{
+		JSONCodec{},
+		MsgpackCodec{},
+	}
+}
+
+// Subprotocols returns the websocket subprotocols for the codecs, in order of preference
+func Subprotocols(codecs []Codec) (subprotocols []string) {
+	for _, c := range codecs {
+		subprotocols = append(subprotocols, c.Subprotocol())
+	}
+	return
+}
+
+// Negotiate returns the codec for the subprotocol selected during the websocket handshake
+// Clients that don't request a subprotocol get the first codec
+func Negotiate(subprotocol string, codecs []Codec) Codec {
+	for _, c := range codecs {
+		if c.Subprotocol() == subprotocol {
+			return c
+		}
+	}
+	if len(codecs) == 0 {
+		return JSONCodec{}
+	}
+	return codecs[0]
+}
+
+// JSONCodec sends game messages as JSON text messages
+type JSONCodec struct{}
+
+func (JSONCodec) Subprotocol() string {
+	return JSON_SUBPROTOCOL
+}
+
+func (JSONCodec) MessageType() websocket.MessageType {
+	return websocket.MessageText
+}
+
+func (JSONCodec) Encode(msg messages.GameMessage) ([]byte, error) {
+	return json.Marshal(&msg)
+}
+
+func (JSONCodec) Decode(b []byte) (msg messages.GameMessage, err error) {
+	err = json.Unmarshal(b, &msg)
+	return
+}
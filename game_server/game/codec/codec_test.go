@@ -0,0 +1,196 @@
+package game_codec
+
+import (
+	"testing"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/stretchr/testify/require"
+)
+
+type benchPlayer struct {
+	ID string  `json:"id"`
+	X  float64 `json:"x"`
+	Y  float64 `json:"y"`
+	HP int     `json:"hp"`
+}
+
+type benchState struct {
+	Players []benchPlayer `json:"players"`
+	Events  []string      `json:"events,omitempty"`
+}
+
+// benchMessage is a typical per-tick state update for a small action game
+func benchMessage() messages.GameMessage {
+	state := benchState{}
+	for i := 0; i < 8; i++ {
+		state.Players = append(state.Players, benchPlayer{
+			ID: "player-id-0000",
+			X:  float64(i) * 10.5,
+			Y:  float64(i) * -3.25,
+			HP: 100 - i,
+		})
+	}
+	return messages.GameMessage{
+		Code: 1000,
+		Tick: 123456,
+		Data: state,
+	}
+}
+
+func TestCodecs(t *testing.T) {
+
+	t.Run("negotiate", func(t *testing.T) {
+		codecs := Defaults()
+		require.Equal(t, []string{JSON_SUBPROTOCOL, MSGPACK_SUBPROTOCOL}, Subprotocols(codecs))
+		// Raw is opt in, clients asking for it get the preferred codec
+		require.Equal(t, JSONCodec{}, Negotiate(RAW_SUBPROTOCOL, codecs))
+		require.Equal(t, MsgpackCodec{}, Negotiate(MSGPACK_SUBPROTOCOL, codecs))
+		require.Equal(t, JSONCodec{}, Negotiate("", codecs))
+		require.Equal(t, RawCodec{}, Negotiate("", []Codec{RawCodec{}}))
+	})
+
+	t.Run("json round trip", func(t *testing.T) {
+		c := JSONCodec{}
+		b, err := c.Encode(messages.GameMessage{Code: 123, Tick: 5, Data: "foo"})
+		require.NoError(t, err)
+		msg, err := c.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, messages.GameMessage{Code: 123, Tick: 5, Data: "foo"}, msg)
+	})
+
+	t.Run("msgpack round trip", func(t *testing.T) {
+		c := MsgpackCodec{}
+
+		data := map[string]interface{}{
+			"nil":      nil,
+			"bool":     true,
+			"small":    int64(7),
+			"negative": int64(-1000),
+			"large":    int64(1 << 40),
+			"huge":     uint64(1 << 63),
+			"float":    1.5,
+			"string":   "foo",
+			"bytes":    []byte{1, 2, 3},
+			"array":    []interface{}{int64(1), "two"},
+			"nested":   map[string]interface{}{"a": int64(-5)},
+		}
+		b, err := c.Encode(messages.GameMessage{Code: 123, Tick: 70000, Data: data})
+		require.NoError(t, err)
+		msg, err := c.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, 123, msg.Code)
+		require.Equal(t, uint64(70000), msg.Tick)
+		require.Equal(t, data, msg.Data)
+	})
+
+	t.Run("frames round trip", func(t *testing.T) {
+		for _, c := range append(Defaults(), RawCodec{}) {
+			b, err := c.Encode(messages.GameMessage{Code: 123, Frame: 42, Data: "foo"})
+			require.NoError(t, err)
+			msg, err := c.Decode(b)
+			require.NoError(t, err)
+			require.Equal(t, uint64(42), msg.Frame, c.Subprotocol())
+		}
+
+		// Messages without a frame keep the three element msgpack array
+		b, err := MsgpackCodec{}.Encode(messages.GameMessage{Code: 123, Data: "foo"})
+		require.NoError(t, err)
+		require.Equal(t, byte(0x93), b[0])
+	})
+
+	t.Run("msgpack encodes structs like json", func(t *testing.T) {
+		c := MsgpackCodec{}
+
+		b, err := c.Encode(messages.NewStateDeltaMessage(3, benchPlayer{ID: "p1", X: 1, HP: 2}))
+		require.NoError(t, err)
+		msg, err := c.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"sinceTick": int64(3),
+			"delta": map[string]interface{}{
+				"id": "p1",
+				"x":  float64(1),
+				"y":  float64(0),
+				"hp": int64(2),
+			},
+		}, msg.Data)
+	})
+
+	t.Run("msgpack malformed", func(t *testing.T) {
+		c := MsgpackCodec{}
+
+		b, err := c.Encode(benchMessage())
+		require.NoError(t, err)
+		_, err = c.Decode(b[:len(b)-1])
+		require.ErrorIs(t, err, ErrCodecMalformed)
+		_, err = c.Decode([]byte{0x91, 0x01})
+		require.ErrorIs(t, err, ErrCodecMalformed)
+	})
+
+	t.Run("raw round trip", func(t *testing.T) {
+		c := RawCodec{}
+
+		b, err := c.Encode(messages.GameMessage{Code: 300, Tick: 9, Frame: 4, Data: []byte("protobuf")})
+		require.NoError(t, err)
+		msg, err := c.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, messages.GameMessage{Code: 300, Tick: 9, Frame: 4, Data: []byte("protobuf")}, msg)
+
+		// Server messages are sent as JSON inside the raw frame
+		b, err = c.Encode(messages.NewPlayerKickedMessage("cheating"))
+		require.NoError(t, err)
+		msg, err = c.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, messages.PLAYER_KICKED, msg.Code)
+		require.JSONEq(t, `{"reason":"cheating"}`, string(msg.Data.([]byte)))
+
+		_, err = c.Encode(messages.GameMessage{Code: 300, Data: make(chan int)})
+		require.ErrorIs(t, err, ErrCodecUnsupportedData)
+	})
+}
+
+func benchmarkEncode(b *testing.B, c Codec, msg messages.GameMessage) {
+	b.ReportAllocs()
+	var size int
+	for i := 0; i < b.N; i++ {
+		encoded, err := c.Encode(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(encoded)
+	}
+	b.ReportMetric(float64(size), "bytes/msg")
+}
+
+func benchmarkDecode(b *testing.B, c Codec, msg messages.GameMessage) {
+	encoded, err := c.Encode(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(encoded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Decode(encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// rawMessage is benchMessage with the data pre-serialized, as a game doing its own protobuf would send it
+func rawMessage(b *testing.B) messages.GameMessage {
+	msg := benchMessage()
+	data, err := MsgpackCodec{}.Encode(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg.Data = data
+	return msg
+}
+
+func BenchmarkEncodeJSON(b *testing.B)    { benchmarkEncode(b, JSONCodec{}, benchMessage()) }
+func BenchmarkEncodeMsgpack(b *testing.B) { benchmarkEncode(b, MsgpackCodec{}, benchMessage()) }
+func BenchmarkEncodeRaw(b *testing.B)     { benchmarkEncode(b, RawCodec{}, rawMessage(b)) }
+func BenchmarkDecodeJSON(b *testing.B)    { benchmarkDecode(b, JSONCodec{}, benchMessage()) }
+func BenchmarkDecodeMsgpack(b *testing.B) { benchmarkDecode(b, MsgpackCodec{}, benchMessage()) }
+func BenchmarkDecodeRaw(b *testing.B)     { benchmarkDecode(b, RawCodec{}, rawMessage(b)) }
@@ -0,0 +1,544 @@
+package game_codec
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"nhooyr.io/websocket"
+)
+
+// MsgpackCodec sends game messages in the MessagePack binary format
+//
+// A message is encoded as the array [code, tick, data], or [code, tick, data, frame] if it has a frame.
+// Data is encoded like encoding/json would:
+// structs become maps keyed by their json tags. Decoded data uses generic types: map[string]interface{},
+// []interface{}, int64, uint64 (only when too large for int64), float64, string, []byte, bool and nil
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Subprotocol() string {
+	return MSGPACK_SUBPROTOCOL
+}
+
+func (MsgpackCodec) MessageType() websocket.MessageType {
+	return websocket.MessageBinary
+}
+
+func (MsgpackCodec) Encode(msg messages.GameMessage) (b []byte, err error) {
+	b = make([]byte, 0, 64)
+	if msg.Frame != 0 {
+		b = append(b, 0x94)
+	} else {
+		b = append(b, 0x93)
+	}
+	b = appendInt(b, int64(msg.Code))
+	b = appendUint(b, msg.Tick)
+	if b, err = appendValue(b, msg.Data); err != nil || msg.Frame == 0 {
+		return
+	}
+	b = appendUint(b, msg.Frame)
+	return
+}
+
+func (MsgpackCodec) Decode(b []byte) (msg messages.GameMessage, err error) {
+	d := decoder{b: b}
+	var n int
+	if n, err = d.arrayLen(); err != nil {
+		return
+	}
+	if n != 3 && n != 4 {
+		err = ErrCodecMalformed
+		return
+	}
+	var code, tick, frame interface{}
+	if code, err = d.value(); err != nil {
+		return
+	}
+	if tick, err = d.value(); err != nil {
+		return
+	}
+	if msg.Data, err = d.value(); err != nil {
+		return
+	}
+	if n == 4 {
+		if frame, err = d.value(); err != nil {
+			return
+		}
+	}
+	var ok bool
+	var c int64
+	if c, ok = code.(int64); !ok {
+		err = ErrCodecMalformed
+		return
+	}
+	msg.Code = int(c)
+	if msg.Tick, err = decodeUint(tick); err != nil || frame == nil {
+		return
+	}
+	msg.Frame, err = decodeUint(frame)
+	return
+}
+
+// decodeUint converts a decoded tick or frame number to a uint64
+func decodeUint(v interface{}) (u uint64, err error) {
+	switch t := v.(type) {
+	case int64:
+		if t < 0 {
+			err = ErrCodecMalformed
+			return
+		}
+		u = uint64(t)
+	case uint64:
+		u = t
+	default:
+		err = ErrCodecMalformed
+	}
+	return
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return append(b, 0xd1, byte(i>>8), byte(i))
+	case i >= math.MinInt32:
+		return append(b, 0xd2, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	default:
+		b = append(b, 0xd3)
+		return appendUint64(b, uint64(i))
+	}
+}
+
+func appendUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return append(b, 0xcd, byte(u>>8), byte(u))
+	case u <= math.MaxUint32:
+		return append(b, 0xce, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	default:
+		b = append(b, 0xcf)
+		return appendUint64(b, u)
+	}
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], u)
+	return append(b, buf[:]...)
+}
+
+// appendFloat uses the 32 bit format when it is lossless, both decode to float64
+func appendFloat(b []byte, f float64) []byte {
+	if f32 := float32(f); float64(f32) == f {
+		u := math.Float32bits(f32)
+		return append(b, 0xca, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	}
+	b = append(b, 0xcb)
+	return appendUint64(b, math.Float64bits(f))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendHeader(b, len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, bs []byte) []byte {
+	b = appendHeader(b, len(bs), 0, -1, 0xc4, 0xc5, 0xc6)
+	return append(b, bs...)
+}
+
+func appendArrayHeader(b []byte, n int) []byte {
+	return appendHeader(b, n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func appendMapHeader(b []byte, n int) []byte {
+	return appendHeader(b, n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+// appendHeader writes a length prefix, using the fix format when n <= fixMax
+// A zero code8 means the type has no 8 bit length format
+func appendHeader(b []byte, n int, fix byte, fixMax int, code8, code16, code32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		return append(b, code8, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, code16, byte(n>>8), byte(n))
+	default:
+		return append(b, code32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// appendValue encodes v, handling the common generic types without reflection
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if t {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case string:
+		return appendString(b, t), nil
+	case []byte:
+		return appendBytes(b, t), nil
+	case int:
+		return appendInt(b, int64(t)), nil
+	case int64:
+		return appendInt(b, t), nil
+	case uint64:
+		return appendUint(b, t), nil
+	case float64:
+		return appendFloat(b, t), nil
+	case []interface{}:
+		var err error
+		b = appendArrayHeader(b, len(t))
+		for _, e := range t {
+			if b, err = appendValue(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		var err error
+		b = appendMapHeader(b, len(t))
+		for k, e := range t {
+			b = appendString(b, k)
+			if b, err = appendValue(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+	return appendReflect(b, reflect.ValueOf(v))
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func appendReflect(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	if v.Type().Implements(textMarshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		var text []byte
+		if text, err = v.Interface().(encoding.TextMarshaler).MarshalText(); err != nil {
+			return b, err
+		}
+		return appendString(b, string(text)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return appendReflect(b, v.Elem())
+	case reflect.Bool:
+		return appendValue(b, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(b, v.Float()), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBytes(b, v.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		b = appendArrayHeader(b, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendReflect(b, v.Index(i)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		b = appendMapHeader(b, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			b = appendString(b, mapKey(iter.Key()))
+			if b, err = appendReflect(b, iter.Value()); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := cachedStructFields(v.Type())
+		n := 0
+		for _, f := range fields {
+			if !f.omitEmpty || !v.FieldByIndex(f.index).IsZero() {
+				n++
+			}
+		}
+		b = appendMapHeader(b, n)
+		for _, f := range fields {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			b = appendString(b, f.name)
+			if b, err = appendReflect(b, fv); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+	return b, fmt.Errorf("%w: %s", ErrCodecUnsupportedData, v.Type().String())
+}
+
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// fieldCache holds the encoded fields of struct types, keyed by reflect.Type
+var fieldCache sync.Map
+
+func cachedStructFields(t reflect.Type) []structField {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, structFields(t, nil))
+	return fields.([]structField)
+}
+
+// structFields returns the fields of a struct the way encoding/json names them
+func structFields(t reflect.Type, parent []int) (fields []structField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		index := append(append([]int{}, parent...), i)
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, structFields(f.Type, index)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     index,
+			omitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+	return
+}
+
+// decoder reads MessagePack values from a buffer
+type decoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *decoder) next(n int) (b []byte, err error) {
+	if n < 0 || d.pos+n > len(d.b) {
+		err = ErrCodecMalformed
+		return
+	}
+	b = d.b[d.pos : d.pos+n]
+	d.pos += n
+	return
+}
+
+func (d *decoder) uint(n int) (u uint64, err error) {
+	var b []byte
+	if b, err = d.next(n); err != nil {
+		return
+	}
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return
+}
+
+func (d *decoder) arrayLen() (n int, err error) {
+	var b []byte
+	if b, err = d.next(1); err != nil {
+		return
+	}
+	var u uint64
+	switch c := b[0]; {
+	case c&0xf0 == 0x90:
+		n = int(c & 0x0f)
+	case c == 0xdc:
+		u, err = d.uint(2)
+		n = int(u)
+	case c == 0xdd:
+		u, err = d.uint(4)
+		n = int(u)
+	default:
+		err = ErrCodecMalformed
+	}
+	return
+}
+
+func (d *decoder) value() (v interface{}, err error) {
+	var b []byte
+	if b, err = d.next(1); err != nil {
+		return
+	}
+	c := b[0]
+	var u uint64
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return d.mapValue(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		if u, err = d.uint(1 << (c - 0xcc)); err != nil {
+			return
+		}
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0:
+		u, err = d.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err = d.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err = d.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err = d.uint(8)
+		return int64(u), err
+	case 0xca:
+		u, err = d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err = d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xd9, 0xda, 0xdb:
+		if u, err = d.uint(1 << (c - 0xd9)); err != nil {
+			return
+		}
+		return d.str(int(u))
+	case 0xc4, 0xc5, 0xc6:
+		if u, err = d.uint(1 << (c - 0xc4)); err != nil {
+			return
+		}
+		if b, err = d.next(int(u)); err != nil {
+			return
+		}
+		bs := make([]byte, len(b))
+		copy(bs, b)
+		return bs, nil
+	case 0xdc, 0xdd:
+		if u, err = d.uint(2 << (c - 0xdc)); err != nil {
+			return
+		}
+		return d.array(int(u))
+	case 0xde, 0xdf:
+		if u, err = d.uint(2 << (c - 0xde)); err != nil {
+			return
+		}
+		return d.mapValue(int(u))
+	}
+	return nil, ErrCodecMalformed
+}
+
+func (d *decoder) str(n int) (v interface{}, err error) {
+	var b []byte
+	if b, err = d.next(n); err != nil {
+		return
+	}
+	return string(b), nil
+}
+
+func (d *decoder) array(n int) (v interface{}, err error) {
+	// Every element takes at least one byte, so a larger length is malformed
+	if n > len(d.b)-d.pos {
+		return nil, ErrCodecMalformed
+	}
+	a := make([]interface{}, n)
+	for i := range a {
+		if a[i], err = d.value(); err != nil {
+			return
+		}
+	}
+	return a, nil
+}
+
+func (d *decoder) mapValue(n int) (v interface{}, err error) {
+	if n > len(d.b)-d.pos {
+		return nil, ErrCodecMalformed
+	}
+	m := make(map[string]interface{}, n)
+	var key, value interface{}
+	for i := 0; i < n; i++ {
+		if key, err = d.value(); err != nil {
+			return
+		}
+		if value, err = d.value(); err != nil {
+			return
+		}
+		if s, ok := key.(string); ok {
+			m[s] = value
+		} else {
+			m[fmt.Sprint(key)] = value
+		}
+	}
+	return m, nil
+}
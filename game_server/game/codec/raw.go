@@ -0,0 +1,74 @@
+package game_codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"nhooyr.io/websocket"
+)
+
+// RawCodec passes message data through as bytes, for games that do their own serialization (e.g. protobuf)
+//
+// A message is the code, tick and frame as uvarints followed by the data. Decoded messages have []byte data.
+// Encoded messages with data that isn't []byte, such as the server's own messages, carry the data JSON encoded
+type RawCodec struct{}
+
+func (RawCodec) Subprotocol() string {
+	return RAW_SUBPROTOCOL
+}
+
+func (RawCodec) MessageType() websocket.MessageType {
+	return websocket.MessageBinary
+}
+
+func (RawCodec) Encode(msg messages.GameMessage) (b []byte, err error) {
+	var data []byte
+	switch d := msg.Data.(type) {
+	case []byte:
+		data = d
+	case nil:
+	default:
+		if data, err = json.Marshal(d); err != nil {
+			err = fmt.Errorf("%w: %s", ErrCodecUnsupportedData, err.Error())
+			return
+		}
+	}
+	if msg.Code < 0 {
+		err = ErrCodecUnsupportedData
+		return
+	}
+	b = make([]byte, 3*binary.MaxVarintLen64+len(data))
+	n := binary.PutUvarint(b, uint64(msg.Code))
+	n += binary.PutUvarint(b[n:], msg.Tick)
+	n += binary.PutUvarint(b[n:], msg.Frame)
+	n += copy(b[n:], data)
+	b = b[:n]
+	return
+}
+
+func (RawCodec) Decode(b []byte) (msg messages.GameMessage, err error) {
+	code, n := binary.Uvarint(b)
+	if n <= 0 {
+		err = ErrCodecMalformed
+		return
+	}
+	b = b[n:]
+	tick, n := binary.Uvarint(b)
+	if n <= 0 {
+		err = ErrCodecMalformed
+		return
+	}
+	b = b[n:]
+	frame, n := binary.Uvarint(b)
+	if n <= 0 {
+		err = ErrCodecMalformed
+		return
+	}
+	msg.Code = int(code)
+	msg.Tick = tick
+	msg.Frame = frame
+	msg.Data = b[n:]
+	return
+}
@@ -0,0 +1,152 @@
+package game_instance
+
+import (
+	"sort"
+	"time"
+
+	errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GAME_STATE_WAITING  = "waiting"
+	GAME_STATE_RUNNING  = "running"
+	GAME_STATE_FINISHED = "finished"
+)
+
+// GameInfo describes a game for operators
+type GameInfo struct {
+	ID                    string    `json:"id"`
+	GameType              string    `json:"gameType"`
+	State                 string    `json:"state"`
+	NumPlayers            int       `json:"numPlayers"`
+	PlayerIDs             []string  `json:"playerIDs"`
+	DisconnectedPlayerIDs []string  `json:"disconnectedPlayerIDs,omitempty"`
+	Spectators            int       `json:"spectators"`
+	Tick                  uint64    `json:"tick"`
+	TickStats             TickStats `json:"tickStats"`
+	CreatedAt             int64     `json:"createdAt"`
+	AgeS                  int64     `json:"ageS"`
+}
+
+// State returns whether the game is waiting for players, running or finished
+func (g *Game) State() string {
+	switch {
+	case g.Context.Err() != nil:
+		return GAME_STATE_FINISHED
+	case g.Started():
+		return GAME_STATE_RUNNING
+	default:
+		return GAME_STATE_WAITING
+	}
+}
+
+// Info returns a description of the game at the time it is called
+func (g *Game) Info() (info GameInfo) {
+	info = GameInfo{
+		ID:         g.ID,
+		GameType:   g.GameType,
+		State:      g.State(),
+		NumPlayers: g.NumPlayers,
+		PlayerIDs:  []string{},
+		Spectators: g.NumSpectators(),
+		Tick:       g.CurrentTick(),
+		TickStats:  g.TickStats(),
+		CreatedAt:  g.CreatedAt.Unix(),
+		AgeS:       int64(time.Since(g.CreatedAt).Seconds()),
+	}
+
+	g.PlayersMutex.RLock()
+	for playerID := range g.Players {
+		info.PlayerIDs = append(info.PlayerIDs, playerID)
+	}
+	for playerID := range g.disconnected {
+		info.DisconnectedPlayerIDs = append(info.DisconnectedPlayerIDs, playerID)
+	}
+	g.PlayersMutex.RUnlock()
+
+	sort.Strings(info.PlayerIDs)
+	sort.Strings(info.DisconnectedPlayerIDs)
+	return
+}
+
+// do runs fn on the game loop and waits for it to complete
+//
+// Controls are also run while the game is waiting for players. ErrGameEnded is returned
+// if the game ends before fn is run
+func (g *Game) do(fn func()) (err error) {
+	done := make(chan struct{})
+	select {
+	case g.controls <- func() {
+		defer close(done)
+		fn()
+	}:
+	case <-g.Context.Done():
+		err = errors.ErrGameEnded
+		return
+	}
+	<-done
+	return
+}
+
+// Notify sends a message from the server to every player and spectator in the game
+func (g *Game) Notify(msg messages.GameMessage) (err error) {
+	return g.do(func() {
+		g.sendMessagesToPlayers(g.broadcast(msg))
+	})
+}
+
+// End notifies players that the game was ended with the reason and shuts the game down
+// The game's result is reported with END_REASON_ENDED and the reason as its EndDetail
+func (g *Game) End(reason string) (err error) {
+	return g.do(func() {
+		g.endDetail = reason
+		g.sendMessagesToPlayers(g.broadcast(messages.NewGameEndedMessage(reason)))
+		g.Logger.WithField("reason", reason).Info("game ended by operator")
+		g.Shutdown()
+	})
+}
+
+// Kick removes a player from the game, telling them the reason before closing their connection
+//
+// The game sees the player leave with a PLAYER_LEFT message
+func (g *Game) Kick(playerID string, reason string) (err error) {
+	if doErr := g.do(func() {
+		g.PlayersMutex.Lock()
+		p, exists := g.Players[playerID]
+		if !exists {
+			g.PlayersMutex.Unlock()
+			err = errors.ErrGamePlayerNotFound
+			return
+		}
+		if dp, disconnected := g.disconnected[playerID]; disconnected {
+			dp.timer.Stop()
+			delete(g.disconnected, playerID)
+		}
+		delete(g.Players, playerID)
+		g.PlayersMutex.Unlock()
+
+		msg := messages.NewPlayerKickedMessage(reason)
+		msg.Tick = g.CurrentTick()
+		g.recordSent(msg.Tick, map[string][]messages.GameMessage{playerID: {msg}})
+		if writeErr := p.Write(msg); writeErr != nil {
+			g.Logger.WithFields(logrus.Fields{
+				"playerID": playerID,
+				"error":    writeErr.Error(),
+			}).Warn("failed telling player they were kicked")
+		}
+		p.CloseConnection()
+
+		// The game loop is running this, so the message is queued for the next collection
+		go g.pushGameMessage(messages.NewPlayerLeftMessage(playerID))
+
+		g.Logger.WithFields(logrus.Fields{
+			"playerID": playerID,
+			"reason":   reason,
+		}).Info("player kicked from game")
+	}); doErr != nil {
+		err = doErr
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package game_instance
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// Decoder converts the generic data of a message from a player into the type the game expects
+//
+// Codecs decode data into generic types: map[string]interface{}, []interface{} and so on for JSON and
+// msgpack, and []byte for the raw codec. Decoders are registered per message code and run before the
+// message reaches the game, messages that fail to decode are dropped
+type Decoder func(data interface{}) (interface{}, error)
+
+// JSONDecoder returns a Decoder that converts data into a value of the same type as prototype,
+// using the prototype's json tags. []byte data, such as from the raw codec, is parsed as JSON
+//
+// For example JSONDecoder(MoveInput{}) decodes data into a MoveInput, and JSONDecoder(&MoveInput{}) into a *MoveInput
+func JSONDecoder(prototype interface{}) Decoder {
+	t := reflect.TypeOf(prototype)
+	return func(data interface{}) (decoded interface{}, err error) {
+		var b []byte
+		switch d := data.(type) {
+		case []byte:
+			b = d
+		default:
+			if b, err = json.Marshal(data); err != nil {
+				err = errors.Wrap(err, "failed encoding message data")
+				return
+			}
+		}
+		v := reflect.New(t)
+		if err = json.Unmarshal(b, v.Interface()); err != nil {
+			err = errors.Wrapf(err, "failed decoding message data as %s", t.String())
+			return
+		}
+		decoded = v.Elem().Interface()
+		return
+	}
+}
+
+// decode converts a message's data with the decoder registered for its code, if there is one
+func (g *Game) decode(code int, data interface{}) (interface{}, error) {
+	decoder, exists := g.Decoders[code]
+	if !exists {
+		return data, nil
+	}
+	return decoder(data)
+}
@@ -0,0 +1,76 @@
+package game_instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	mocks "github.com/gunnermanx/simplegameserver/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type moveInput struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func TestDecoders(t *testing.T) {
+	t.Run("json decoder converts generic data", func(t *testing.T) {
+		decoded, err := JSONDecoder(moveInput{})(map[string]interface{}{"x": 1.0, "y": 2.0})
+		require.NoError(t, err)
+		require.Equal(t, moveInput{X: 1, Y: 2}, decoded)
+	})
+
+	t.Run("json decoder with a pointer prototype returns pointers", func(t *testing.T) {
+		decoded, err := JSONDecoder(&moveInput{})(map[string]interface{}{"x": 1.0})
+		require.NoError(t, err)
+		require.Equal(t, &moveInput{X: 1}, decoded)
+	})
+
+	t.Run("json decoder parses bytes", func(t *testing.T) {
+		decoded, err := JSONDecoder(moveInput{})([]byte(`{"x":3,"y":4}`))
+		require.NoError(t, err)
+		require.Equal(t, moveInput{X: 3, Y: 4}, decoded)
+	})
+
+	t.Run("json decoder fails on mismatched data", func(t *testing.T) {
+		_, err := JSONDecoder(moveInput{})("not a move")
+		require.Error(t, err)
+	})
+
+	t.Run("player messages are decoded and failures dropped", func(t *testing.T) {
+		p1_id := "p1_id"
+		g := NewGame(logrus.New(), 1)
+		defer g.Cancel()
+		g.Decoders = map[int]Decoder{100: JSONDecoder(moveInput{})}
+
+		playerCtx, playerCancel := context.WithCancel(context.Background())
+		defer playerCancel()
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		mockPlayer.EXPECT().GetContext().Return(playerCtx).AnyTimes()
+		gomock.InOrder(
+			mockPlayer.EXPECT().Read().Return(messages.GameMessage{Code: 100, Data: "bad"}, nil),
+			mockPlayer.EXPECT().Read().Return(messages.GameMessage{Code: 100, Data: map[string]interface{}{"x": 5.0}}, nil),
+			mockPlayer.EXPECT().Read().Return(messages.GameMessage{Code: 101, Data: "untouched"}, nil),
+			mockPlayer.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+				<-playerCtx.Done()
+				return messages.GameMessage{}, playerCtx.Err()
+			}).AnyTimes(),
+		)
+
+		go g.listenToPlayer(mockPlayer)
+
+		input := <-g.playerInputs
+		require.Equal(t, p1_id, input.PlayerID)
+		require.Equal(t, moveInput{X: 5}, input.Data)
+		require.False(t, input.ReceivedAt.IsZero())
+		input = <-g.playerInputs
+		require.Equal(t, "untouched", input.Data)
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gunnermanx/simplegameserver/common"
 	errors "github.com/gunnermanx/simplegameserver/game_server/errors"
 	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
 	player "github.com/gunnermanx/simplegameserver/game_server/game/player"
@@ -16,6 +18,8 @@ import (
 
 // GameInit is called when a game instance on the server is created
 // This should be implemented by a concrete game server and added to the server using WithGameInit
+//
+// For games the matchmaking server allocated with teams, g.Teams holds the players on each side
 type GameInit func(
 	ctx context.Context,
 	g *Game,
@@ -24,12 +28,19 @@ type GameInit func(
 
 // GameTick is called once every server tick and defines the behavior of the game server
 // This should be implemented by a concrete game server and added to the server using WithGameTick
+//
+// Game.Step returns the tick number and the simulation time the tick advances the game by,
+// and in holds the events and player input received since the last tick
 type GameTick func(
 	ctx context.Context,
 	g *Game,
-	msgs []messages.GameMessage,
+	in Inputs,
 ) (bool, map[string][]messages.GameMessage, error)
 
+const (
+	MAX_BUFFERED_MESSAGES_PER_PLAYER = 256
+)
+
 // Game models a game running on the game server
 type Game struct {
 	Logger  *logrus.Entry
@@ -37,26 +48,136 @@ type Game struct {
 	Cancel  context.CancelFunc
 
 	ID         string
+	GameType   string
 	NumPlayers int
+	CreatedAt  time.Time
+	// MatchID is the matchmaking server's match the game was allocated for, if any
+	MatchID string
+	// AllowedPlayerIDs restricts who can join the game, anyone can join if it is nil
+	AllowedPlayerIDs map[string]bool
+	// invited are the players who can request their own join ticket to the game, see Invite
+	invited map[string]bool
+	// endDetail is the reason an operator gave for ending the game, only accessed on the game loop
+	endDetail string
+	// Teams holds the player IDs on each side when the matchmaking server formed the match with teams
+	Teams [][]string
+
+	// ReconnectGracePeriod is how long a dropped player's seat is reserved before they are removed
+	ReconnectGracePeriod time.Duration
+	// MaxSpectators is the maximum number of spectators that can watch the game, 0 means no limit
+	MaxSpectators int
+	// SpectatorDelay is how long messages are held before they are sent to spectators
+	SpectatorDelay time.Duration
+	// Recorder, if set, records the game so it can be replayed later
+	Recorder Recorder
+	// Metrics, if set, records tick timings for the game
+	Metrics *Metrics
+
+	// LoopMode decides how ticks are scheduled, see LOOP_MODE_TICKER and LOOP_MODE_FIXED_TIMESTEP
+	LoopMode LoopMode
+	// MaxCatchUpTicks caps how many missed ticks LOOP_MODE_FIXED_TIMESTEP runs back to back after a slow tick,
+	// any further missed ticks are skipped. Catching up is disabled if 0
+	MaxCatchUpTicks int
+	// Lockstep configures LOOP_MODE_LOCKSTEP
+	Lockstep LockstepConfig
 
 	Players      map[string]player.GamePlayer
 	PlayersMutex sync.RWMutex
+	// GameMessages carries events about the game to the game loop, such as players joining and leaving
 	GameMessages chan messages.GameMessage
+	// playerInputs carries the messages players send to the game loop
+	playerInputs chan Input
+	// Decoders convert the data of player messages by message code, see Decoder
+	Decoders map[int]Decoder
+
+	// disconnected is guarded by PlayersMutex
+	disconnected map[string]*disconnectedPlayer
+
+	// playerIDs and startedAt are set when the game starts
+	playerIDs      []string
+	startedAt      time.Time
+	tick           uint64
+	step           Step
+	turn           messages.LockstepTurn
+	tickStats      tickStats
+	started        int32
+	resyncRequests chan resyncRequest
+	controls       chan func()
+	// lockstepMessages carries player inputs and checksums to the game loop in LOOP_MODE_LOCKSTEP
+	lockstepMessages chan lockstepMessage
+
+	spectators      map[string]*spectator
+	spectatorsMutex sync.RWMutex
+	spectatorStream spectatorStream
 
 	Data interface{}
 }
 
-type GameCompletedCallback func(error, ...interface{})
+// disconnectedPlayer holds the reserved seat of a player whose connection dropped
+type disconnectedPlayer struct {
+	player     player.GamePlayer
+	timer      *time.Timer
+	buffered   []messages.GameMessage
+	overflowed bool
+}
+
+// GameCompletedCallback is called with the result of the game and the error it ended with, if any
+type GameCompletedCallback func(result common.GameResult, err error)
+
+// Setup is what GameInit can read from the game besides its players, it is recorded so a replay runs with the same setup
+type Setup struct {
+	GameType string     `json:"gameType,omitempty"`
+	MatchID  string     `json:"matchID,omitempty"`
+	Teams    [][]string `json:"teams,omitempty"`
+}
+
+// Setup returns the game's setup
+func (g *Game) Setup() Setup {
+	return Setup{
+		GameType: g.GameType,
+		MatchID:  g.MatchID,
+		Teams:    g.Teams,
+	}
+}
+
+// ApplySetup sets the game's type, match and teams, it should be called before Init
+func (g *Game) ApplySetup(setup Setup) {
+	g.GameType = setup.GameType
+	g.MatchID = setup.MatchID
+	g.Teams = setup.Teams
+}
+
+// Recorder records the inputs and outputs of GameInit and GameTick, along with every message sent to players
+// See the replay package for a file based implementation and a replay runner
+type Recorder interface {
+	RecordInit(at time.Time, setup Setup, playerIDs []string, out map[string][]messages.GameMessage) error
+	// RecordTick is called with the step and lockstep turn GameTick saw, turn is empty unless the game is in LOOP_MODE_LOCKSTEP
+	RecordTick(tick uint64, at time.Time, step Step, turn messages.LockstepTurn, in Inputs, out map[string][]messages.GameMessage) error
+	// RecordSent is called with the messages sent to each player, or held for them while they reconnect, and to SPECTATORS.
+	// Besides the output of GameInit and GameTick this includes snapshots and deltas, input acks, lockstep turns,
+	// player joined and left messages and messages from operators
+	RecordSent(tick uint64, at time.Time, sent map[string][]messages.GameMessage) error
+}
 
 func NewGame(
 	logger *logrus.Logger,
 	maxPlayers int,
 ) (game *Game) {
 	game = &Game{
-		ID:           uuid.New().String(),
-		Players:      make(map[string]player.GamePlayer),
-		GameMessages: make(chan messages.GameMessage),
-		NumPlayers:   maxPlayers,
+		ID:               uuid.New().String(),
+		Players:          make(map[string]player.GamePlayer),
+		GameMessages:     make(chan messages.GameMessage),
+		playerInputs:     make(chan Input),
+		NumPlayers:       maxPlayers,
+		CreatedAt:        time.Now(),
+		disconnected:     make(map[string]*disconnectedPlayer),
+		resyncRequests:   make(chan resyncRequest),
+		controls:         make(chan func()),
+		lockstepMessages: make(chan lockstepMessage),
+		spectators:       make(map[string]*spectator),
+		spectatorStream: spectatorStream{
+			notify: make(chan struct{}, 1),
+		},
 	}
 	game.Logger = logger.WithFields(logrus.Fields{
 		"gameID": game.ID,
@@ -82,73 +203,218 @@ func (g *Game) Run(
 
 	var err error
 
-	// Create GameCompletedArgs
-	var results []interface{}
-
 	defer func() {
 		if callback != nil {
-			callback(err, results)
+			callback(g.buildResult(err), err)
 		}
 		g.Logger.Info("game completed")
 	}()
 
+	// Relay the broadcast stream to spectators until the game ends
+	go g.relayToSpectators()
+
 	// Wait for players before starting gameloop
 	var playerIDs []string
 	if playerIDs, err = g.waitForPlayers(waitForPlayersTimeout); err != nil {
 		return
 	}
 
+	g.playerIDs = playerIDs
+	g.startedAt = time.Now()
+
 	// Initialize the game instance
 	var out map[string][]messages.GameMessage
-	if out, err = gameInit(g.Context, g, playerIDs); err != nil {
+	if out, err = g.Init(gameInit, playerIDs); err != nil {
 		g.Logger.Errorf("error in gameinit: %s", err.Error())
 		g.Cancel()
 		return
 	}
-	if err = g.sendMessagesToPlayers(out); err != nil {
-		// TODO maybe
-		g.Cancel()
+	g.sendMessagesToPlayers(out)
+	g.sendSnapshotToPlayers()
+	atomic.StoreInt32(&g.started, 1)
+
+	interval := time.Duration(tickIntervalMS) * time.Millisecond
+	if g.LoopMode == LOOP_MODE_LOCKSTEP {
+		err = g.lockstepLoop(gameTick, interval)
 		return
 	}
+	err = g.loop(gameTick, interval)
+}
 
-	// simple game loop:
-	ticker := time.NewTicker(time.Duration(tickIntervalMS) * time.Millisecond)
-	msgs := []messages.GameMessage{}
-	var complete bool
-	for !complete {
-		select {
-		case <-ticker.C:
-
-			// Run the gameTick
+// loop runs ticks as they are due until the game completes or ends
+// and collects events and the players' messages to pass to the next tick
+func (g *Game) loop(gameTick GameTick, interval time.Duration) (err error) {
+	clock := newTickClock(g.LoopMode, interval, g.MaxCatchUpTicks, time.Now())
+	defer clock.stop()
 
-			var out map[string][]messages.GameMessage
-			if complete, out, err = gameTick(g.Context, g, msgs); err != nil {
-				g.Logger.Errorf("error in gametick: %s", err.Error())
-				g.Cancel()
-				return
+	inputs := newInputBuffer()
+	for {
+		select {
+		case now := <-clock.C():
+			steps, skipped := clock.due(now, g.CurrentTick())
+			g.recordSkipped(skipped)
+			for _, step := range steps {
+				var complete bool
+				if complete, err = g.runStep(gameTick, step, inputs, interval); err != nil || complete {
+					return
+				}
 			}
+			clock.rearm(time.Now())
 
-			// Send messages back to players
-			if err = g.sendMessagesToPlayers(out); err != nil {
-				// TODO maybe
-				g.Cancel()
-				return
-			}
+		case req := <-g.resyncRequests:
+			g.handleResync(req)
 
-			msgs = nil
+		case fn := <-g.controls:
+			fn()
+
+		case <-g.Context.Done():
+			err = g.Context.Err()
+			return
 
 		case msg := <-g.GameMessages:
-			//g.Logger.Infof("colleting msg from channel: %v", msg)
-			msgs = append(msgs, msg)
-			// TODO parse them
+			inputs.addEvent(msg)
+
+		case in := <-g.playerInputs:
+			g.bufferPlayerInput(inputs, in)
 		}
 	}
 }
 
-func (g *Game) AddPlayer(p player.GamePlayer) {
+// runStep runs a single tick with the buffered inputs and sends its messages to the players
+func (g *Game) runStep(
+	gameTick GameTick,
+	step Step,
+	inputs *inputBuffer,
+	interval time.Duration,
+) (complete bool, err error) {
+	g.step = step
+	start := time.Now()
+
+	// Run the gameTick
+	var out map[string][]messages.GameMessage
+	if complete, out, err = g.Tick(gameTick, inputs.drain()); err != nil {
+		g.Logger.Errorf("error in gametick: %s", err.Error())
+		return
+	}
+	g.recordTick(step, time.Since(start), interval)
+
+	// Send messages back to players
+	g.sendMessagesToPlayers(out)
+	g.sendMessagesToPlayers(inputs.acks())
+	g.sendDeltaToPlayers()
+	return
+}
+
+// bufferPlayerInput holds a player's message for the next tick
+func (g *Game) bufferPlayerInput(inputs *inputBuffer, input Input) {
+	if !inputs.addPlayerInput(input) {
+		g.Logger.WithFields(logrus.Fields{
+			"playerID": input.PlayerID,
+			"frame":    input.Frame,
+		}).Debug("dropped input for a frame that was already received or is too far ahead")
+	}
+}
+
+// Init calls gameInit for the game and records the result if the game has a Recorder
+func (g *Game) Init(
+	gameInit GameInit,
+	playerIDs []string,
+) (out map[string][]messages.GameMessage, err error) {
+	at := time.Now()
+	if out, err = gameInit(g.Context, g, playerIDs); err != nil {
+		return
+	}
+	if g.Recorder != nil {
+		if recordErr := g.Recorder.RecordInit(at, g.Setup(), playerIDs, out); recordErr != nil {
+			g.Logger.WithField("error", recordErr.Error()).Warn("failed recording game init")
+		}
+	}
+	return
+}
+
+// Tick advances the game by one tick, calling gameTick with the inputs received since the last tick,
+// and records the tick if the game has a Recorder
+func (g *Game) Tick(
+	gameTick GameTick,
+	in Inputs,
+) (complete bool, out map[string][]messages.GameMessage, err error) {
+	tick := atomic.AddUint64(&g.tick, 1)
+	at := time.Now()
+	if complete, out, err = gameTick(g.Context, g, in); err != nil {
+		return
+	}
+	if g.Recorder != nil {
+		if recordErr := g.Recorder.RecordTick(tick, at, g.step, g.turn, in, out); recordErr != nil {
+			g.Logger.WithFields(logrus.Fields{
+				"tick":  tick,
+				"error": recordErr.Error(),
+			}).Warn("failed recording game tick")
+		}
+	}
+	return
+}
+
+// recordSent records the messages sent to players if the game has a Recorder
+func (g *Game) recordSent(tick uint64, sent map[string][]messages.GameMessage) {
+	if g.Recorder == nil || len(sent) == 0 {
+		return
+	}
+	if err := g.Recorder.RecordSent(tick, time.Now(), sent); err != nil {
+		g.Logger.WithFields(logrus.Fields{
+			"tick":  tick,
+			"error": err.Error(),
+		}).Warn("failed recording sent messages")
+	}
+}
+
+// AddPlayer adds a player to the game
+//
+// If a player with the same ID dropped and is still within the reconnect grace period,
+// the new connection takes over the reserved seat and any buffered messages are replayed
+func (g *Game) AddPlayer(p player.GamePlayer) (err error) {
+	if g.AllowedPlayerIDs != nil && !g.AllowedPlayerIDs[p.GetID()] {
+		err = errors.ErrGamePlayerNotAllowed
+		return
+	}
+
 	g.PlayersMutex.Lock()
 	if _, exists := g.Players[p.GetID()]; exists {
-		//TODO this is a reconnection? do we need to do more? send reconnection message?
+		dp, superseded, reconnectErr := g.reconnectPlayer(p)
+		g.PlayersMutex.Unlock()
+		if reconnectErr != nil {
+			err = reconnectErr
+			return
+		}
+
+		// The dropped connection may still be open if only writing to it failed
+		go superseded.CloseConnection()
+
+		needsResync, replayErr := g.replayBufferedMessages(dp)
+		if replayErr != nil {
+			err = replayErr
+			return
+		}
+
+		// The game loop sees the reconnect before any input from the new connection,
+		// which starts its frames again from 1
+		g.pushGameMessage(messages.NewPlayerReconnectedMessage(p.GetID()))
+
+		go g.listenToPlayer(p)
+
+		// Buffered messages were dropped, so the player needs the full state
+		if needsResync {
+			g.requestResync(p.GetID(), 0)
+		}
+
+		g.Logger.WithField(
+			"playerID", p.GetID(),
+		).Info("player reconnected to game")
+		return
+	}
+	if len(g.Players) >= g.NumPlayers {
+		g.PlayersMutex.Unlock()
+		err = errors.ErrGameFull
+		return
 	}
 	g.Players[p.GetID()] = p
 	g.PlayersMutex.Unlock()
@@ -158,14 +424,241 @@ func (g *Game) AddPlayer(p player.GamePlayer) {
 
 	g.GameMessages <- messages.NewPlayerJoinedMessage(p.GetID())
 
+	// Players joining a running game need the full state
+	g.requestResync(p.GetID(), 0)
+
 	g.Logger.WithField(
 		"playerID", p.GetID(),
 	).Info("player added to game")
+	return
+}
+
+// HoldsSeat returns true if the player has a seat in the game, including a seat reserved after a disconnect
+func (g *Game) HoldsSeat(playerID string) bool {
+	g.PlayersMutex.RLock()
+	defer g.PlayersMutex.RUnlock()
+	_, exists := g.Players[playerID]
+	return exists
+}
+
+// Invite lets the players request their own join tickets to the game
+func (g *Game) Invite(playerIDs ...string) {
+	g.PlayersMutex.Lock()
+	defer g.PlayersMutex.Unlock()
+	if g.invited == nil {
+		g.invited = make(map[string]bool)
+	}
+	for _, playerID := range playerIDs {
+		g.invited[playerID] = true
+	}
+}
+
+// Invited returns true if the player was invited to the game
+func (g *Game) Invited(playerID string) bool {
+	g.PlayersMutex.RLock()
+	defer g.PlayersMutex.RUnlock()
+	return g.invited[playerID]
+}
+
+// reconnectPlayer gives a disconnected player's reserved seat to p and returns the connection it replaced
+// PlayersMutex must be held by the caller
+//
+// The seat stays marked as disconnected until replayBufferedMessages has flushed the buffer,
+// so messages sent in the meantime queue up behind the buffered ones
+func (g *Game) reconnectPlayer(p player.GamePlayer) (dp *disconnectedPlayer, superseded player.GamePlayer, err error) {
+	var exists bool
+	if dp, exists = g.disconnected[p.GetID()]; !exists || dp.player == p {
+		err = errors.ErrGamePlayerAlreadyExists
+		return
+	}
+	dp.timer.Stop()
+	superseded = dp.player
+	dp.player = p
+	g.Players[p.GetID()] = p
+	return
+}
+
+// replayBufferedMessages writes a reconnected player's buffered messages without holding PlayersMutex,
+// then clears their disconnected state once the buffer is empty
+// needsResync is true if buffered messages were dropped and the player needs the full state
+//
+// If a write fails the player is disconnected again and their grace period restarts
+func (g *Game) replayBufferedMessages(dp *disconnectedPlayer) (needsResync bool, err error) {
+	p := dp.player
+	for {
+		g.PlayersMutex.Lock()
+		// The player may have been kicked or removed while the buffer was being replayed
+		if current, exists := g.disconnected[p.GetID()]; !exists || current != dp {
+			g.PlayersMutex.Unlock()
+			return
+		}
+		msgs := dp.buffered
+		dp.buffered = nil
+		needsResync = needsResync || dp.overflowed
+		dp.overflowed = false
+		if len(msgs) == 0 {
+			delete(g.disconnected, p.GetID())
+			g.PlayersMutex.Unlock()
+			return
+		}
+		g.PlayersMutex.Unlock()
+
+		for i, msg := range msgs {
+			if err = p.Write(msg); err != nil {
+				g.Logger.WithFields(logrus.Fields{
+					"playerID": p.GetID(),
+					"error":    err.Error(),
+				}).Error("failed replaying buffered message to player")
+
+				g.PlayersMutex.Lock()
+				if current, exists := g.disconnected[p.GetID()]; exists && current == dp {
+					dp.buffered = append(msgs[i:], dp.buffered...)
+					dp.overflowed = dp.overflowed || needsResync
+					dp.timer = time.AfterFunc(g.ReconnectGracePeriod, func() {
+						g.expireDisconnectedPlayer(dp)
+					})
+				}
+				g.PlayersMutex.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// disconnectPlayer reserves the seat of a player whose connection dropped and notifies the game loop
+//
+// The player is removed from the game if they do not reconnect within the grace period
+func (g *Game) disconnectPlayer(p player.GamePlayer) {
+	if msg, ok := g.markDisconnected(p); ok {
+		g.pushGameMessage(msg)
+	}
+}
+
+// markDisconnected updates the game state for a dropped player
+// and returns the message that should be sent to the game loop
+func (g *Game) markDisconnected(p player.GamePlayer) (msg messages.GameMessage, ok bool) {
+	if g.Context.Err() != nil {
+		return
+	}
+
+	g.PlayersMutex.Lock()
+	defer g.PlayersMutex.Unlock()
+
+	// Only the current connection for the player can drop their seat
+	if current, exists := g.Players[p.GetID()]; !exists || current != p {
+		return
+	}
+	if _, exists := g.disconnected[p.GetID()]; exists {
+		return
+	}
+	if g.ReconnectGracePeriod <= 0 {
+		delete(g.Players, p.GetID())
+		g.Logger.WithField(
+			"playerID", p.GetID(),
+		).Info("player dropped from game")
+		return messages.NewPlayerLeftMessage(p.GetID()), true
+	}
+
+	dp := &disconnectedPlayer{
+		player: p,
+	}
+	dp.timer = time.AfterFunc(g.ReconnectGracePeriod, func() {
+		g.expireDisconnectedPlayer(dp)
+	})
+	g.disconnected[p.GetID()] = dp
+
+	g.Logger.WithFields(logrus.Fields{
+		"playerID":    p.GetID(),
+		"gracePeriod": g.ReconnectGracePeriod.String(),
+	}).Info("player disconnected from game")
+	return messages.NewPlayerDisconnectedMessage(p.GetID()), true
+}
+
+// expireDisconnectedPlayer removes a disconnected player once their grace period runs out
+func (g *Game) expireDisconnectedPlayer(dp *disconnectedPlayer) {
+	g.PlayersMutex.Lock()
+	// dp.player changes hands on reconnect, so it is only read under the lock
+	p := dp.player
+	playerID := p.GetID()
+	if current, exists := g.disconnected[playerID]; !exists || current != dp {
+		g.PlayersMutex.Unlock()
+		return
+	}
+	delete(g.disconnected, playerID)
+	delete(g.Players, playerID)
+	g.PlayersMutex.Unlock()
+
+	p.CloseConnection()
+
+	g.pushGameMessage(messages.NewPlayerLeftMessage(playerID))
+
+	g.Logger.WithField(
+		"playerID", playerID,
+	).Info("player did not reconnect in time, removed from game")
+}
+
+// bufferMessages holds messages for a disconnected player until they reconnect
+// Returns false if the player is not disconnected
+func (g *Game) bufferMessages(playerID string, msgs []messages.GameMessage) bool {
+	g.PlayersMutex.Lock()
+	defer g.PlayersMutex.Unlock()
+
+	dp, exists := g.disconnected[playerID]
+	if !exists {
+		return false
+	}
+	dp.buffered = append(dp.buffered, msgs...)
+	if overflow := len(dp.buffered) - MAX_BUFFERED_MESSAGES_PER_PLAYER; overflow > 0 {
+		g.Logger.WithFields(logrus.Fields{
+			"playerID": playerID,
+			"dropped":  overflow,
+		}).Warn("buffer full for disconnected player, dropping oldest messages")
+		dp.buffered = dp.buffered[overflow:]
+		dp.overflowed = true
+	}
+	return true
+}
+
+// Shutdown ends the game and closes the connections of its players
+//
+// Connections are closed concurrently and outside PlayersMutex, a slow close handshake
+// shouldn't hold up the other players or anything waiting on the lock
+func (g *Game) Shutdown() {
+	g.Cancel()
+
+	g.PlayersMutex.RLock()
+	players := make([]player.GamePlayer, 0, len(g.Players))
+	for _, p := range g.Players {
+		players = append(players, p)
+	}
+	g.PlayersMutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, p := range players {
+		wg.Add(1)
+		go func(p player.GamePlayer) {
+			defer wg.Done()
+			p.CloseConnection()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// pushGameMessage sends a message to the game loop without blocking once the game has ended
+func (g *Game) pushGameMessage(msg messages.GameMessage) {
+	select {
+	case g.GameMessages <- msg:
+	case <-g.Context.Done():
+	}
 }
 
 func (g *Game) RemovePlayer(p player.GamePlayer) {
 	g.PlayersMutex.Lock()
 	delete(g.Players, p.GetID())
+	if dp, exists := g.disconnected[p.GetID()]; exists {
+		dp.timer.Stop()
+		delete(g.disconnected, p.GetID())
+	}
 	g.PlayersMutex.Unlock()
 
 	p.CloseConnection()
@@ -201,7 +694,15 @@ loop:
 				}
 				break loop
 			}
+		case <-g.playerInputs:
+			// Input sent before the game starts is dropped
+		case fn := <-g.controls:
+			fn()
 		case <-ctx.Done():
+			// The game was shut down before it started rather than timing out
+			if err = g.Context.Err(); err != nil {
+				break loop
+			}
 			err = errors.ErrGameTimedOutWaitingForPlayers
 			g.Logger.WithField("error", err.Error()).Error("failed waiting for players")
 			break loop
@@ -245,34 +746,102 @@ readLoop:
 					"playerID": p.GetID(),
 					"error":    err.Error(),
 				}).Error("failed reading message from player")
+				g.disconnectPlayer(p)
+				break readLoop
+			}
+			receivedAt := time.Now()
+			// Resync requests are handled by the server rather than the game
+			if gamemsg.Code == messages.RESYNC_REQUEST {
+				g.requestResync(p.GetID(), messages.ParseResyncRequest(gamemsg))
+				continue
+			}
+			var decodeErr error
+			if gamemsg.Data, decodeErr = g.decode(gamemsg.Code, gamemsg.Data); decodeErr != nil {
+				g.Logger.WithFields(logrus.Fields{
+					"playerID": p.GetID(),
+					"code":     gamemsg.Code,
+					"error":    decodeErr.Error(),
+				}).Warn("dropped message from player that failed to decode")
+				continue
+			}
+			// Lockstep inputs and checksums are collected by the lockstep loop
+			if g.LoopMode == LOOP_MODE_LOCKSTEP &&
+				(gamemsg.Code == messages.LOCKSTEP_INPUT || gamemsg.Code == messages.LOCKSTEP_CHECKSUM) {
+				select {
+				case g.lockstepMessages <- lockstepMessage{playerID: p.GetID(), msg: gamemsg}:
+				case <-g.Context.Done():
+				}
+				continue
+			}
+			select {
+			case g.playerInputs <- Input{GameMessage: gamemsg, PlayerID: p.GetID(), ReceivedAt: receivedAt}:
+			case <-p.GetContext().Done():
+				break readLoop
+			case <-g.Context.Done():
 				break readLoop
 			}
-			g.GameMessages <- gamemsg
 		}
 	}
 }
 
-func (g *Game) sendMessagesToPlayers(out map[string][]messages.GameMessage) (err error) {
+// sendMessagesToPlayers sends each player their messages
+//
+// Messages for players who have left the game, for example after being kicked before the game saw
+// them leave, are dropped. A player whose connection fails is dropped without affecting the others
+func (g *Game) sendMessagesToPlayers(out map[string][]messages.GameMessage) {
 	var p player.GamePlayer
 	var exists bool
+	tick := g.CurrentTick()
+	sent := make(map[string][]messages.GameMessage, len(out))
+	defer g.recordSent(tick, sent)
 	for playerID, msgs := range out {
+		if playerID == SPECTATORS {
+			g.queueSpectatorMessages(msgs...)
+			sent[SPECTATORS] = msgs
+			continue
+		}
+
 		g.PlayersMutex.RLock()
 		p, exists = g.Players[playerID]
 		g.PlayersMutex.RUnlock()
 		if !exists {
-			err = fmt.Errorf("no player in game with ID: %s", playerID)
-			return
+			g.Logger.WithFields(logrus.Fields{
+				"playerID": playerID,
+				"messages": len(msgs),
+			}).Debug("dropped messages for player no longer in game")
+			continue
 		}
 
-		for _, msg := range msgs {
-			if err = p.Write(msg); err != nil {
+		// Stamp messages with the tick they were produced on
+		stamped := make([]messages.GameMessage, len(msgs))
+		for i, msg := range msgs {
+			msg.Tick = tick
+			stamped[i] = msg
+		}
+		msgs = stamped
+		sent[playerID] = msgs
+
+		// Hold messages for players that are within their reconnect grace period
+		if g.bufferMessages(playerID, msgs) {
+			continue
+		}
+
+		for i, msg := range msgs {
+			if writeErr := p.Write(msg); writeErr != nil {
 				g.Logger.WithFields(logrus.Fields{
 					"playerID": p.GetID(),
-					"error":    err.Error(),
+					"error":    writeErr.Error(),
 				}).Error("failed writing message to player")
-				return
+				// Only this player's connection failed, so they are dropped rather than ending the game.
+				// The remaining messages are kept in case they reconnect within the grace period.
+				// The game loop is the caller here, so it must not block on its own channel or the close handshake
+				if msg, ok := g.markDisconnected(p); ok {
+					go g.pushGameMessage(msg)
+				}
+				g.bufferMessages(playerID, msgs[i:])
+				go p.CloseConnectionWithError(writeErr)
+				break
 			}
 		}
 	}
-	return
 }
@@ -3,10 +3,12 @@ package game_instance
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/gunnermanx/simplegameserver/common"
 	errors "github.com/gunnermanx/simplegameserver/game_server/errors"
 	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
 	mocks "github.com/gunnermanx/simplegameserver/mocks"
@@ -15,6 +17,40 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// testSnapshotter is game data that can produce deltas since tick 5 only
+type testSnapshotter struct {
+	state string
+}
+
+func (s *testSnapshotter) Snapshot() interface{} {
+	return s.state
+}
+
+func (s *testSnapshotter) Delta(sinceTick uint64) (interface{}, bool) {
+	if sinceTick != 5 {
+		return nil, false
+	}
+	return "delta", true
+}
+
+// sentRecorder is a Recorder that keeps the messages sent to players
+type sentRecorder struct {
+	sent []map[string][]messages.GameMessage
+}
+
+func (r *sentRecorder) RecordInit(time.Time, Setup, []string, map[string][]messages.GameMessage) error {
+	return nil
+}
+
+func (r *sentRecorder) RecordTick(uint64, time.Time, Step, messages.LockstepTurn, Inputs, map[string][]messages.GameMessage) error {
+	return nil
+}
+
+func (r *sentRecorder) RecordSent(tick uint64, at time.Time, sent map[string][]messages.GameMessage) error {
+	r.sent = append(r.sent, sent)
+	return nil
+}
+
 func TestRunGame(t *testing.T) {
 	p1_id := "p1_id"
 	p2_id := "p2_id"
@@ -38,9 +74,11 @@ func TestRunGame(t *testing.T) {
 		mockPlayer1.EXPECT().GetID().Return(p1_id).AnyTimes()
 		mockPlayer2.EXPECT().GetID().Return(p2_id).AnyTimes()
 
-		player1Ctx, _ := context.WithCancel(context.Background())
+		player1Ctx, player1CtxCancel := context.WithCancel(context.Background())
+		defer player1CtxCancel()
 		mockPlayer1.EXPECT().GetContext().Return(player1Ctx).AnyTimes()
-		player2Ctx, _ := context.WithCancel(context.Background())
+		player2Ctx, player2CtxCancel := context.WithCancel(context.Background())
+		defer player2CtxCancel()
 		mockPlayer2.EXPECT().GetContext().Return(player2Ctx).AnyTimes()
 
 		mockPlayer1.EXPECT().Read().Return(playerMsg, nil).AnyTimes()
@@ -56,7 +94,7 @@ func TestRunGame(t *testing.T) {
 			return
 		}
 		gameTick := func(
-			ctx context.Context, g *Game, msgs []messages.GameMessage,
+			ctx context.Context, g *Game, in Inputs,
 		) (complete bool, out map[string][]messages.GameMessage, err error) {
 			gameData := g.Data.(map[string]interface{})
 			counter := gameData["counter"].(int) + 1
@@ -91,9 +129,11 @@ func TestRunGame(t *testing.T) {
 		mockPlayer1.EXPECT().GetID().Return(p1_id).AnyTimes()
 		mockPlayer2.EXPECT().GetID().Return(p2_id).AnyTimes()
 
-		player1Ctx, _ := context.WithCancel(context.Background())
+		player1Ctx, player1CtxCancel := context.WithCancel(context.Background())
+		defer player1CtxCancel()
 		mockPlayer1.EXPECT().GetContext().Return(player1Ctx).AnyTimes()
-		player2Ctx, _ := context.WithCancel(context.Background())
+		player2Ctx, player2CtxCancel := context.WithCancel(context.Background())
+		defer player2CtxCancel()
 		mockPlayer2.EXPECT().GetContext().Return(player2Ctx).AnyTimes()
 
 		mockPlayer1.EXPECT().Read().Return(playerMsg, nil).AnyTimes()
@@ -106,7 +146,7 @@ func TestRunGame(t *testing.T) {
 			return
 		}
 		gameTick := func(
-			ctx context.Context, g *Game, msgs []messages.GameMessage,
+			ctx context.Context, g *Game, in Inputs,
 		) (complete bool, out map[string][]messages.GameMessage, err error) {
 			return
 		}
@@ -118,6 +158,59 @@ func TestRunGame(t *testing.T) {
 
 		<-g.Context.Done()
 	})
+
+	t.Run("notify and shut down a running game", func(t *testing.T) {
+		g = NewGame(logger, 1)
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+
+		playerCtx, playerCtxCancel := context.WithCancel(context.Background())
+		defer playerCtxCancel()
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		mockPlayer.EXPECT().GetContext().Return(playerCtx).AnyTimes()
+		mockPlayer.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+			<-playerCtx.Done()
+			return messages.GameMessage{}, playerCtx.Err()
+		}).AnyTimes()
+
+		shutdown := make(chan messages.GameMessage, 1)
+		mockPlayer.EXPECT().Write(gomock.Any()).DoAndReturn(func(msg messages.GameMessage) error {
+			if msg.Code == messages.SERVER_SHUTDOWN {
+				shutdown <- msg
+			}
+			return nil
+		}).AnyTimes()
+		mockPlayer.EXPECT().CloseConnection().Do(playerCtxCancel).Times(1)
+
+		gameInit := func(
+			ctx context.Context, g *Game, playerIDs []string,
+		) (out map[string][]messages.GameMessage, err error) {
+			return
+		}
+		gameTick := func(
+			ctx context.Context, g *Game, in Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			return
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			g.Run(gameInit, gameTick, 10, 5, nil)
+		}()
+		go g.AddPlayer(mockPlayer)
+		require.Eventually(t, g.Started, time.Second, 10*time.Millisecond)
+
+		deadline := time.Now().Add(time.Minute)
+		g.Notify(messages.NewServerShutdownMessage(deadline))
+		msg := <-shutdown
+		require.Equal(t, messages.ServerShutdown{Deadline: deadline.Unix()}, msg.Data)
+
+		g.Shutdown()
+		<-done
+	})
 }
 
 func TestGame(t *testing.T) {
@@ -166,6 +259,177 @@ func TestGame(t *testing.T) {
 
 	})
 
+	t.Run("add player to full game", func(t *testing.T) {
+		g = NewGame(logger, 1)
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		mockPlayer.EXPECT().GetID().Return(p2_id).AnyTimes()
+
+		g.Players[p1_id] = mocks.NewMockGamePlayer(mockCtrl)
+
+		err := g.AddPlayer(mockPlayer)
+		require.ErrorIs(t, err, errors.ErrGameFull)
+		require.NotContains(t, g.Players, p2_id)
+	})
+
+	t.Run("add player not in allocation", func(t *testing.T) {
+		g = NewGame(logger, 2)
+		g.AllowedPlayerIDs = map[string]bool{p1_id: true, p2_id: true}
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		mockPlayer.EXPECT().GetID().Return(p3_id).AnyTimes()
+
+		err := g.AddPlayer(mockPlayer)
+		require.ErrorIs(t, err, errors.ErrGamePlayerNotAllowed)
+		require.Empty(t, g.Players)
+	})
+
+	t.Run("player disconnects and reconnects", func(t *testing.T) {
+		g = NewGame(logger, 2)
+		g.ReconnectGracePeriod = 5 * time.Second
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		droppedPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		reconnectedPlayer := mocks.NewMockGamePlayer(mockCtrl)
+
+		droppedPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		droppedPlayer.EXPECT().GetContext().Return(context.Background()).AnyTimes()
+		droppedPlayer.EXPECT().Read().Return(messages.GameMessage{}, fmt.Errorf("some error")).Times(1)
+
+		// The reconnected player is cancelled early to avoid mocking Read calls
+		reconnectedCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		reconnectedPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		reconnectedPlayer.EXPECT().GetContext().Return(reconnectedCtx).AnyTimes()
+
+		g.Players[p1_id] = droppedPlayer
+
+		// The failed read drops the player and reserves their seat
+		go g.listenToPlayer(droppedPlayer)
+		msg := <-g.GameMessages
+		require.Equal(t, messages.PLAYER_DISCONNECTED, msg.Code)
+		require.Equal(t, p1_id, msg.Data)
+
+		// Messages sent while disconnected are buffered instead of written
+		msg1 := messages.GameMessage{
+			Code: 123,
+			Data: 1,
+		}
+		g.sendMessagesToPlayers(map[string][]messages.GameMessage{
+			p1_id: {msg1},
+		})
+
+		// Reconnecting replays the buffered messages on the new connection without holding the players lock,
+		// messages sent during the replay are written after the buffered ones
+		msg2 := messages.GameMessage{
+			Code: 123,
+			Data: 2,
+		}
+		gomock.InOrder(
+			reconnectedPlayer.EXPECT().Write(msg1).DoAndReturn(func(messages.GameMessage) error {
+				g.sendMessagesToPlayers(map[string][]messages.GameMessage{
+					p1_id: {msg2},
+				})
+				return nil
+			}).Times(1),
+			reconnectedPlayer.EXPECT().Write(msg2).Return(nil).Times(1),
+		)
+
+		// The superseded connection is closed
+		closed := make(chan struct{})
+		droppedPlayer.EXPECT().CloseConnection().Do(func() {
+			close(closed)
+		}).Times(1)
+
+		go func() {
+			msg := <-g.GameMessages
+			require.Equal(t, messages.PLAYER_RECONNECTED, msg.Code)
+			require.Equal(t, p1_id, msg.Data)
+		}()
+		require.NoError(t, g.AddPlayer(reconnectedPlayer))
+		require.Equal(t, reconnectedPlayer, g.Players[p1_id])
+		require.NotContains(t, g.disconnected, p1_id)
+		<-closed
+	})
+
+	t.Run("shutdown closes connections concurrently outside the players lock", func(t *testing.T) {
+		g = NewGame(logger, 2)
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+
+		// Each close waits for the other to start and takes the players lock,
+		// which only finishes if the closes run concurrently and the lock is free
+		var started sync.WaitGroup
+		started.Add(2)
+		for _, id := range []string{p1_id, p2_id} {
+			mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+			mockPlayer.EXPECT().CloseConnection().Do(func() {
+				started.Done()
+				started.Wait()
+				g.PlayersMutex.Lock()
+				g.PlayersMutex.Unlock()
+			}).Times(1)
+			g.Players[id] = mockPlayer
+		}
+
+		shutdown := make(chan struct{})
+		go func() {
+			defer close(shutdown)
+			g.Shutdown()
+		}()
+		select {
+		case <-shutdown:
+		case <-time.After(time.Second):
+			t.Fatal("shutdown did not finish")
+		}
+	})
+
+	t.Run("player already in game", func(t *testing.T) {
+		g = NewGame(logger, 2)
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+
+		g.Players[p1_id] = mocks.NewMockGamePlayer(mockCtrl)
+
+		err := g.AddPlayer(mockPlayer)
+		require.ErrorIs(t, err, errors.ErrGamePlayerAlreadyExists)
+	})
+
+	t.Run("disconnected player does not reconnect in time", func(t *testing.T) {
+		g = NewGame(logger, 2)
+		g.ReconnectGracePeriod = 100 * time.Millisecond
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		mockPlayer.EXPECT().GetContext().Return(context.Background()).AnyTimes()
+		mockPlayer.EXPECT().Read().Return(messages.GameMessage{}, fmt.Errorf("some error")).Times(1)
+		mockPlayer.EXPECT().CloseConnection().Times(1)
+
+		g.Players[p1_id] = mockPlayer
+
+		go g.listenToPlayer(mockPlayer)
+		msg := <-g.GameMessages
+		require.Equal(t, messages.PLAYER_DISCONNECTED, msg.Code)
+
+		// Once the grace period runs out the player is removed
+		msg = <-g.GameMessages
+		require.Equal(t, messages.PLAYER_LEFT, msg.Code)
+		require.Equal(t, p1_id, msg.Data)
+		require.NotContains(t, g.Players, p1_id)
+	})
+
 	t.Run("wait for players", func(t *testing.T) {
 		g = NewGame(logger, 4)
 
@@ -216,9 +480,10 @@ func TestGame(t *testing.T) {
 
 			// Keep reading messages to unblock player.Read calls
 			go func() {
-				for msg := range g.GameMessages {
-					require.Equal(t, msg.Code, playerMsg.Code)
-					require.Equal(t, msg.Data, playerMsg.Data)
+				for in := range g.playerInputs {
+					require.Equal(t, p1_id, in.PlayerID)
+					require.Equal(t, in.Code, playerMsg.Code)
+					require.Equal(t, in.Data, playerMsg.Data)
 				}
 			}()
 			// After 500ms, cancel the player context so the listen loop ends
@@ -227,7 +492,7 @@ func TestGame(t *testing.T) {
 				playerCtxCancel()
 			}()
 
-			// listenToPlayer gets blocked on player.Read and on pushing to game.GameMessages
+			// listenToPlayer gets blocked on player.Read and on pushing to the game's player inputs
 			g.listenToPlayer(mockPlayer)
 		})
 
@@ -244,9 +509,10 @@ func TestGame(t *testing.T) {
 
 			// Keep reading messages to unblock player.Read calls
 			go func() {
-				for msg := range g.GameMessages {
-					require.Equal(t, msg.Code, playerMsg.Code)
-					require.Equal(t, msg.Data, playerMsg.Data)
+				for in := range g.playerInputs {
+					require.Equal(t, p1_id, in.PlayerID)
+					require.Equal(t, in.Code, playerMsg.Code)
+					require.Equal(t, in.Data, playerMsg.Data)
 				}
 			}()
 			// After 500ms, cancel the game context so the listen loop ends
@@ -255,7 +521,7 @@ func TestGame(t *testing.T) {
 				g.Cancel()
 			}()
 
-			// listenToPlayer gets blocked on player.Read and on pushing to game.GameMessages
+			// listenToPlayer gets blocked on player.Read and on pushing to the game's player inputs
 			g.listenToPlayer(mockPlayer)
 		})
 
@@ -307,8 +573,31 @@ func TestGame(t *testing.T) {
 			mockPlayer2.EXPECT().Write(msg1).Return(nil).Times(1)
 			mockPlayer2.EXPECT().Write(msg2).Return(nil).Times(0)
 
-			err := g.sendMessagesToPlayers(msgsToSend)
-			require.NoError(t, err)
+			g.sendMessagesToPlayers(msgsToSend)
+		})
+
+		t.Run("sent messages are recorded", func(t *testing.T) {
+			g = NewGame(logger, 2)
+			recorder := &sentRecorder{}
+			g.Recorder = recorder
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			mockPlayer1 := mocks.NewMockGamePlayer(mockCtrl)
+			g.Players[p1_id] = mockPlayer1
+			mockPlayer1.EXPECT().Write(gomock.Any()).Return(nil).Times(2)
+
+			// Messages the game sends on its own, like admin notices, are recorded along with GameTick's output
+			notice := messages.GameMessage{Code: 123, Data: "notice"}
+			g.sendMessagesToPlayers(g.broadcast(notice))
+			g.sendMessagesToPlayers(map[string][]messages.GameMessage{
+				p1_id:   {{Code: 124}},
+				"p3_id": {{Code: 125}},
+			})
+			require.Equal(t, []map[string][]messages.GameMessage{
+				{p1_id: {notice}, SPECTATORS: {notice}},
+				{p1_id: {{Code: 124}}},
+			}, recorder.sent)
 		})
 
 		t.Run("no player in game with ID exists", func(t *testing.T) {
@@ -319,12 +608,10 @@ func TestGame(t *testing.T) {
 				Data: 1,
 			}
 
+			// Messages for players who already left are dropped
 			msgsToSend := make(map[string][]messages.GameMessage)
 			msgsToSend[p1_id] = append(msgsToSend[p1_id], msg1)
-			expectedErr := fmt.Errorf("no player in game with ID: %s", p1_id)
-
-			err := g.sendMessagesToPlayers(msgsToSend)
-			require.EqualError(t, err, expectedErr.Error())
+			g.sendMessagesToPlayers(msgsToSend)
 		})
 
 		t.Run("player.Write returns error", func(t *testing.T) {
@@ -350,10 +637,285 @@ func TestGame(t *testing.T) {
 			msgsToSend[p1_id] = append(msgsToSend[p1_id], msg1, msg2)
 			expectedErr := fmt.Errorf("some error")
 
+			// The player is dropped and the game carries on
+			closed := make(chan struct{})
 			mockPlayer.EXPECT().Write(msg1).Return(expectedErr).Times(1)
+			mockPlayer.EXPECT().CloseConnectionWithError(expectedErr).Do(func(error) { close(closed) }).Times(1)
+
+			g.sendMessagesToPlayers(msgsToSend)
+			<-closed
+			require.False(t, g.HoldsSeat(p1_id))
+		})
+	})
+
+	t.Run("resync player", func(t *testing.T) {
+		g = NewGame(logger, 2)
+		g.Data = &testSnapshotter{state: "state"}
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		g.Players[p1_id] = mockPlayer
+
+		snapshotMsg := messages.NewStateSnapshotMessage("state")
+		deltaMsg := messages.NewStateDeltaMessage(5, "delta")
+
+		// sinceTick 0 requests a full snapshot
+		mockPlayer.EXPECT().Write(snapshotMsg).Return(nil).Times(1)
+		g.handleResync(resyncRequest{playerID: p1_id, sinceTick: 0})
+
+		// A delta is sent when the game can produce one
+		mockPlayer.EXPECT().Write(deltaMsg).Return(nil).Times(1)
+		g.handleResync(resyncRequest{playerID: p1_id, sinceTick: 5})
+
+		// Otherwise the game falls back to a full snapshot
+		mockPlayer.EXPECT().Write(snapshotMsg).Return(nil).Times(1)
+		g.handleResync(resyncRequest{playerID: p1_id, sinceTick: 3})
+	})
+
+	t.Run("spectators", func(t *testing.T) {
+
+		// newSpectator mocks a spectator whose reads block until the game is cancelled
+		newSpectator := func(mockCtrl *gomock.Controller, id string) *mocks.MockGamePlayer {
+			gameCtx := g.Context
+			mockSpectator := mocks.NewMockGamePlayer(mockCtrl)
+			mockSpectator.EXPECT().GetID().Return(id).AnyTimes()
+			mockSpectator.EXPECT().GetContext().Return(context.Background()).AnyTimes()
+			mockSpectator.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+				<-gameCtx.Done()
+				return messages.GameMessage{}, fmt.Errorf("connection closed")
+			}).AnyTimes()
+			mockSpectator.EXPECT().CloseConnection().AnyTimes()
+			return mockSpectator
+		}
+
+		t.Run("spectator cap reached", func(t *testing.T) {
+			g = NewGame(logger, 2)
+			g.MaxSpectators = 1
+			defer g.Cancel()
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 
-			err := g.sendMessagesToPlayers(msgsToSend)
-			require.ErrorIs(t, err, expectedErr)
+			require.NoError(t, g.AddSpectator(newSpectator(mockCtrl, p2_id)))
+			err := g.AddSpectator(newSpectator(mockCtrl, p3_id))
+			require.ErrorIs(t, err, errors.ErrGameSpectatorsFull)
+			require.Equal(t, 1, g.NumSpectators())
 		})
+
+		t.Run("spectators are not limited by default", func(t *testing.T) {
+			g = NewGame(logger, 2)
+			defer g.Cancel()
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			require.NoError(t, g.AddSpectator(newSpectator(mockCtrl, p2_id)))
+			require.NoError(t, g.AddSpectator(newSpectator(mockCtrl, p3_id)))
+			require.Equal(t, 2, g.NumSpectators())
+		})
+
+		t.Run("spectator receives delayed stream", func(t *testing.T) {
+			g = NewGame(logger, 2)
+			g.MaxSpectators = 1
+			g.SpectatorDelay = 100 * time.Millisecond
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			mockSpectator := mocks.NewMockGamePlayer(mockCtrl)
+
+			// Spectator reads block until the test ends, anything they send is discarded
+			done := make(chan struct{})
+			mockSpectator.EXPECT().GetID().Return(p3_id).AnyTimes()
+			mockSpectator.EXPECT().GetContext().Return(context.Background()).AnyTimes()
+			mockSpectator.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+				<-done
+				return messages.GameMessage{}, fmt.Errorf("connection closed")
+			}).AnyTimes()
+			mockSpectator.EXPECT().CloseConnection().AnyTimes()
+
+			require.NoError(t, g.AddSpectator(mockSpectator))
+			require.Equal(t, 1, g.NumSpectators())
+
+			written := make(chan time.Time, 1)
+			msg := messages.GameMessage{
+				Code: 123,
+				Data: "foo",
+			}
+			mockSpectator.EXPECT().Write(msg).DoAndReturn(func(messages.GameMessage) error {
+				written <- time.Now()
+				return nil
+			}).Times(1)
+
+			go g.relayToSpectators()
+
+			queuedAt := time.Now()
+			g.sendMessagesToPlayers(map[string][]messages.GameMessage{
+				SPECTATORS: {msg},
+			})
+
+			writtenAt := <-written
+			require.GreaterOrEqual(t, writtenAt.Sub(queuedAt), g.SpectatorDelay)
+
+			g.Cancel()
+			close(done)
+		})
+
+		t.Run("reconnecting spectators are replaced outside the spectators lock", func(t *testing.T) {
+			g = NewGame(logger, 2)
+			defer g.Cancel()
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			gameCtx := g.Context
+			dropped := mocks.NewMockGamePlayer(mockCtrl)
+			dropped.EXPECT().GetID().Return(p3_id).AnyTimes()
+			dropped.EXPECT().GetContext().Return(context.Background()).AnyTimes()
+			dropped.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+				<-gameCtx.Done()
+				return messages.GameMessage{}, fmt.Errorf("connection closed")
+			}).AnyTimes()
+			// This would deadlock if the spectators lock was held while closing
+			dropped.EXPECT().CloseConnection().Do(func() {
+				require.Equal(t, 1, g.NumSpectators())
+			}).Times(1)
+
+			require.NoError(t, g.AddSpectator(dropped))
+			require.NoError(t, g.AddSpectator(newSpectator(mockCtrl, p3_id)))
+			require.Equal(t, 1, g.NumSpectators())
+		})
+
+		t.Run("delayed messages don't hold up the game ending", func(t *testing.T) {
+			g = NewGame(logger, 2)
+			g.SpectatorDelay = time.Minute
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			mockSpectator := newSpectator(mockCtrl, p3_id)
+			mockSpectator.EXPECT().Write(gomock.Any()).Return(nil).Times(1)
+			require.NoError(t, g.AddSpectator(mockSpectator))
+
+			relayed := make(chan struct{})
+			go func() {
+				g.relayToSpectators()
+				close(relayed)
+			}()
+			g.sendMessagesToPlayers(map[string][]messages.GameMessage{
+				SPECTATORS: {{Code: 123}},
+			})
+			g.Cancel()
+
+			// The remaining messages are released right away and the spectators disconnected
+			select {
+			case <-relayed:
+			case <-time.After(time.Second):
+				t.Fatal("spectator relay waited for the delay after the game ended")
+			}
+			require.Equal(t, 0, g.NumSpectators())
+		})
+	})
+}
+
+func TestGameControls(t *testing.T) {
+	p1_id := "p1_id"
+	logger := logrus.New()
+
+	g := NewGame(logger, 2)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+
+	playerCtx, playerCtxCancel := context.WithCancel(context.Background())
+	defer playerCtxCancel()
+	mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+	mockPlayer.EXPECT().GetContext().Return(playerCtx).AnyTimes()
+	mockPlayer.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+		<-playerCtx.Done()
+		return messages.GameMessage{}, playerCtx.Err()
+	}).AnyTimes()
+
+	done := make(chan struct{})
+	var result common.GameResult
+	go func() {
+		defer close(done)
+		g.Run(nil, nil, 10, 60, func(r common.GameResult, err error) {
+			result = r
+		})
+	}()
+	require.NoError(t, g.AddPlayer(mockPlayer))
+
+	info := g.Info()
+	require.Equal(t, GAME_STATE_WAITING, info.State)
+	require.Equal(t, []string{p1_id}, info.PlayerIDs)
+
+	t.Run("kick player", func(t *testing.T) {
+		require.ErrorIs(t, g.Kick("p2_id", "cheating"), errors.ErrGamePlayerNotFound)
+
+		mockPlayer.EXPECT().Write(messages.NewPlayerKickedMessage("cheating")).Return(nil).Times(1)
+		mockPlayer.EXPECT().CloseConnection().Do(playerCtxCancel).Times(1)
+		require.NoError(t, g.Kick(p1_id, "cheating"))
+		require.Empty(t, g.Info().PlayerIDs)
+	})
+
+	t.Run("ticks can still address a kicked player", func(t *testing.T) {
+		g := NewGame(logger, 1)
+
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		playerCtx, playerCtxCancel := context.WithCancel(context.Background())
+		defer playerCtxCancel()
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		mockPlayer.EXPECT().GetContext().Return(playerCtx).AnyTimes()
+		mockPlayer.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+			<-playerCtx.Done()
+			return messages.GameMessage{}, playerCtx.Err()
+		}).AnyTimes()
+		mockPlayer.EXPECT().Write(gomock.Any()).Return(nil).AnyTimes()
+		mockPlayer.EXPECT().CloseConnection().Do(playerCtxCancel).Times(1)
+
+		// The tick keeps replying to the player until it sees them leave
+		left := make(chan struct{})
+		gameInit := func(ctx context.Context, g *Game, playerIDs []string) (map[string][]messages.GameMessage, error) {
+			return nil, nil
+		}
+		gameTick := func(ctx context.Context, g *Game, in Inputs) (bool, map[string][]messages.GameMessage, error) {
+			for _, event := range in.Events {
+				if event.Code == messages.PLAYER_LEFT {
+					close(left)
+					return false, nil, nil
+				}
+			}
+			return false, map[string][]messages.GameMessage{p1_id: {{Code: 123}}}, nil
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			g.Run(gameInit, gameTick, 10, 60, nil)
+		}()
+		require.NoError(t, g.AddPlayer(mockPlayer))
+		require.Eventually(t, g.Started, time.Second, time.Millisecond)
+
+		require.NoError(t, g.Kick(p1_id, "cheating"))
+		select {
+		case <-left:
+		case <-done:
+			require.FailNow(t, "game ended after the kicked player was sent a message")
+		}
+		require.Equal(t, GAME_STATE_RUNNING, g.State())
+
+		g.Cancel()
+		<-done
+	})
+
+	t.Run("end game", func(t *testing.T) {
+		require.NoError(t, g.End("maintenance"))
+		<-done
+		require.Equal(t, GAME_STATE_FINISHED, g.State())
+		// The operator's reason is reported with the result
+		require.Equal(t, END_REASON_ENDED, result.EndReason)
+		require.Equal(t, "maintenance", result.EndDetail)
+		require.ErrorIs(t, g.End("maintenance"), errors.ErrGameEnded)
 	})
 }
@@ -0,0 +1,230 @@
+package game_instance
+
+import (
+	"sort"
+	"time"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+)
+
+const (
+	// MAX_BUFFERED_FRAMES_PER_PLAYER is how far past a player's acknowledged frame their input is buffered,
+	// input for later frames is dropped and has to be resent
+	MAX_BUFFERED_FRAMES_PER_PLAYER = 256
+)
+
+// Inputs are the messages received since the last tick
+type Inputs struct {
+	// Events are messages from the server about the game, such as players joining and leaving
+	Events []messages.GameMessage `json:"events,omitempty"`
+	// Players holds the messages each player sent, grouped by the client frame they were produced on, in frame order
+	//
+	// Messages sent without a frame are grouped under frame 0 ahead of any framed messages. Framed messages
+	// are only passed on once every earlier frame from the player has been received. Messages that arrive for
+	// a frame after it was passed on come with the next tick under the same frame
+	Players map[string][]FrameInputs `json:"players,omitempty"`
+}
+
+// FrameInputs are the messages a player produced on a single client frame
+type FrameInputs struct {
+	Frame    uint64  `json:"frame"`
+	Messages []Input `json:"messages"`
+}
+
+// Input is a message received from a player
+//
+// Data has been converted by the Decoder registered for the message's code, if there is one
+type Input struct {
+	messages.GameMessage
+	// PlayerID is the authenticated player that sent the message
+	PlayerID string `json:"playerID"`
+	// ReceivedAt is when the server read the message from the player's connection
+	ReceivedAt time.Time `json:"receivedAt"`
+	// Seq numbers the messages the game accepted from the player in the order they were received, starting from 1
+	Seq uint64 `json:"seq"`
+}
+
+// Empty returns true if there are no events or player messages
+func (in Inputs) Empty() bool {
+	return len(in.Events) == 0 && len(in.Players) == 0
+}
+
+// Messages returns every message in the inputs without their senders,
+// events first followed by each player's messages in frame order
+func (in Inputs) Messages() (msgs []messages.GameMessage) {
+	msgs = append(msgs, in.Events...)
+	playerIDs := make([]string, 0, len(in.Players))
+	for playerID := range in.Players {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	for _, playerID := range playerIDs {
+		for _, input := range in.Player(playerID) {
+			msgs = append(msgs, input.GameMessage)
+		}
+	}
+	return
+}
+
+// Player returns the player's messages in frame order
+func (in Inputs) Player(playerID string) (inputs []Input) {
+	for _, frame := range in.Players[playerID] {
+		inputs = append(inputs, frame.Messages...)
+	}
+	return
+}
+
+// frameBuffer holds a player's messages until the next tick
+//
+// Client frames start at 1. Framed messages are held until every earlier frame has been received,
+// so a tick only sees a player's frames in order and without gaps
+type frameBuffer struct {
+	unframed []Input
+	pending  map[uint64][]Input
+	// acked is the highest frame received with every earlier frame also received
+	acked uint64
+	// ready are the frames up to acked that haven't been passed to a tick yet
+	ready []FrameInputs
+	// sentAck is the last acked frame the player was told about
+	sentAck uint64
+	// seq is the sequence number of the last message accepted
+	seq uint64
+}
+
+func newFrameBuffer() *frameBuffer {
+	return &frameBuffer{
+		pending: make(map[uint64][]Input),
+	}
+}
+
+// add buffers a message, returns false if it was dropped
+//
+// A frame can have any number of messages. Messages for the latest acknowledged frame are still
+// accepted, since the client may not have moved past it yet, and are passed on with the next tick
+// if the frame's earlier messages already were. Messages for frames before it are treated as resends
+// and dropped, as are messages for frames too far ahead, which the client has to resend
+func (fb *frameBuffer) add(input Input) bool {
+	if input.Frame == 0 {
+		fb.seq++
+		input.Seq = fb.seq
+		fb.unframed = append(fb.unframed, input)
+		return true
+	}
+	if input.Frame < fb.acked || input.Frame > fb.acked+MAX_BUFFERED_FRAMES_PER_PLAYER {
+		return false
+	}
+	fb.seq++
+	input.Seq = fb.seq
+
+	if input.Frame == fb.acked {
+		if last := len(fb.ready) - 1; last >= 0 && fb.ready[last].Frame == input.Frame {
+			fb.ready[last].Messages = append(fb.ready[last].Messages, input)
+		} else {
+			fb.ready = append(fb.ready, FrameInputs{Frame: input.Frame, Messages: []Input{input}})
+		}
+		return true
+	}
+	fb.pending[input.Frame] = append(fb.pending[input.Frame], input)
+
+	// Move every frame that is now contiguous to ready
+	for {
+		next, exists := fb.pending[fb.acked+1]
+		if !exists {
+			break
+		}
+		fb.acked++
+		fb.ready = append(fb.ready, FrameInputs{Frame: fb.acked, Messages: next})
+		delete(fb.pending, fb.acked)
+	}
+	return true
+}
+
+// reset starts the player's frames again from 1 for a new connection
+//
+// Messages that were already received are still passed on with the next tick,
+// frames that were waiting on an earlier frame are dropped
+func (fb *frameBuffer) reset() {
+	fb.pending = make(map[uint64][]Input)
+	fb.acked = 0
+	fb.sentAck = 0
+}
+
+// drain returns the messages for the next tick
+func (fb *frameBuffer) drain() (frames []FrameInputs) {
+	if len(fb.unframed) > 0 {
+		frames = append(frames, FrameInputs{Messages: fb.unframed})
+		fb.unframed = nil
+	}
+	frames = append(frames, fb.ready...)
+	fb.ready = nil
+	return
+}
+
+// inputBuffer collects events and player messages on the game loop between ticks
+type inputBuffer struct {
+	events  []messages.GameMessage
+	players map[string]*frameBuffer
+}
+
+func newInputBuffer() *inputBuffer {
+	return &inputBuffer{
+		players: make(map[string]*frameBuffer),
+	}
+}
+
+func (ib *inputBuffer) addEvent(msg messages.GameMessage) {
+	ib.events = append(ib.events, msg)
+	playerID, ok := msg.Data.(string)
+	if !ok {
+		return
+	}
+	switch msg.Code {
+	// A player that left starts again from frame 1 if they rejoin
+	case messages.PLAYER_LEFT:
+		delete(ib.players, playerID)
+	// A reconnected player's client restarts its frames from 1 on the new connection
+	case messages.PLAYER_RECONNECTED:
+		if fb, exists := ib.players[playerID]; exists {
+			fb.reset()
+		}
+	}
+}
+
+func (ib *inputBuffer) addPlayerInput(input Input) bool {
+	fb, exists := ib.players[input.PlayerID]
+	if !exists {
+		fb = newFrameBuffer()
+		ib.players[input.PlayerID] = fb
+	}
+	return fb.add(input)
+}
+
+// drain returns the inputs for the next tick
+func (ib *inputBuffer) drain() (in Inputs) {
+	in.Events = ib.events
+	ib.events = nil
+	for playerID, fb := range ib.players {
+		if frames := fb.drain(); len(frames) > 0 {
+			if in.Players == nil {
+				in.Players = make(map[string][]FrameInputs)
+			}
+			in.Players[playerID] = frames
+		}
+	}
+	return
+}
+
+// acks returns an INPUT_ACK for every player whose acknowledged frame has advanced since they were last told
+func (ib *inputBuffer) acks() (out map[string][]messages.GameMessage) {
+	for playerID, fb := range ib.players {
+		if fb.acked == fb.sentAck {
+			continue
+		}
+		if out == nil {
+			out = make(map[string][]messages.GameMessage)
+		}
+		out[playerID] = []messages.GameMessage{messages.NewInputAckMessage(fb.acked)}
+		fb.sentAck = fb.acked
+	}
+	return
+}
@@ -0,0 +1,175 @@
+package game_instance
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	mocks "github.com/gunnermanx/simplegameserver/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputs(t *testing.T) {
+	p1_id := "p1_id"
+	p2_id := "p2_id"
+
+	input := func(playerID string, frame uint64, data interface{}) Input {
+		return Input{
+			GameMessage: messages.GameMessage{Code: 100, Frame: frame, Data: data},
+			PlayerID:    playerID,
+		}
+	}
+	// sequenced is the input as it is passed to a tick
+	sequenced := func(input Input, seq uint64) Input {
+		input.Seq = seq
+		return input
+	}
+
+	t.Run("frames are passed on in order without gaps", func(t *testing.T) {
+		ib := newInputBuffer()
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 3, "c")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 0, "unframed")))
+
+		in := ib.drain()
+		require.Equal(t, []FrameInputs{
+			{Frame: 0, Messages: []Input{sequenced(input(p1_id, 0, "unframed"), 3)}},
+			{Frame: 1, Messages: []Input{sequenced(input(p1_id, 1, "a"), 1)}},
+		}, in.Players[p1_id])
+		require.Equal(t, map[string][]messages.GameMessage{
+			p1_id: {messages.NewInputAckMessage(1)},
+		}, ib.acks())
+
+		// Frame 2 fills the gap and releases frame 3
+		require.True(t, ib.addPlayerInput(input(p1_id, 2, "b")))
+		in = ib.drain()
+		require.Equal(t, []FrameInputs{
+			{Frame: 2, Messages: []Input{sequenced(input(p1_id, 2, "b"), 4)}},
+			{Frame: 3, Messages: []Input{sequenced(input(p1_id, 3, "c"), 2)}},
+		}, in.Players[p1_id])
+		require.Equal(t, map[string][]messages.GameMessage{
+			p1_id: {messages.NewInputAckMessage(3)},
+		}, ib.acks())
+		require.Nil(t, ib.acks())
+	})
+
+	t.Run("frames accept multiple messages", func(t *testing.T) {
+		ib := newInputBuffer()
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "b")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 2, "c")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 2, "d")))
+
+		in := ib.drain()
+		require.Equal(t, []FrameInputs{
+			{Frame: 1, Messages: []Input{sequenced(input(p1_id, 1, "a"), 1), sequenced(input(p1_id, 1, "b"), 2)}},
+			{Frame: 2, Messages: []Input{sequenced(input(p1_id, 2, "c"), 3), sequenced(input(p1_id, 2, "d"), 4)}},
+		}, in.Players[p1_id])
+
+		// More messages for the latest frame come with the next tick
+		require.True(t, ib.addPlayerInput(input(p1_id, 2, "e")))
+		in = ib.drain()
+		require.Equal(t, []FrameInputs{
+			{Frame: 2, Messages: []Input{sequenced(input(p1_id, 2, "e"), 5)}},
+		}, in.Players[p1_id])
+	})
+
+	t.Run("earlier and far ahead frames are dropped", func(t *testing.T) {
+		ib := newInputBuffer()
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 2, "b")))
+		require.False(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		require.False(t, ib.addPlayerInput(input(p1_id, 3+MAX_BUFFERED_FRAMES_PER_PLAYER, "z")))
+	})
+
+	t.Run("players that reconnect start again from frame 1", func(t *testing.T) {
+		ib := newInputBuffer()
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 2, "b")))
+		require.True(t, ib.addPlayerInput(input(p1_id, 4, "d")))
+		ib.addEvent(messages.NewPlayerReconnectedMessage(p1_id))
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "x")))
+
+		// Received frames are still passed on, the frame waiting on frame 3 is dropped
+		in := ib.drain()
+		require.Equal(t, []FrameInputs{
+			{Frame: 1, Messages: []Input{sequenced(input(p1_id, 1, "a"), 1)}},
+			{Frame: 2, Messages: []Input{sequenced(input(p1_id, 2, "b"), 2)}},
+			{Frame: 1, Messages: []Input{sequenced(input(p1_id, 1, "x"), 4)}},
+		}, in.Players[p1_id])
+		require.Equal(t, map[string][]messages.GameMessage{
+			p1_id: {messages.NewInputAckMessage(1)},
+		}, ib.acks())
+	})
+
+	t.Run("players are grouped separately", func(t *testing.T) {
+		ib := newInputBuffer()
+		ib.addEvent(messages.NewPlayerJoinedMessage(p2_id))
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		require.True(t, ib.addPlayerInput(input(p2_id, 1, "b")))
+
+		in := ib.drain()
+		require.Equal(t, []messages.GameMessage{messages.NewPlayerJoinedMessage(p2_id)}, in.Events)
+		require.Len(t, in.Players, 2)
+		require.Equal(t, []messages.GameMessage{
+			messages.NewPlayerJoinedMessage(p2_id),
+			input(p1_id, 1, "a").GameMessage,
+			input(p2_id, 1, "b").GameMessage,
+		}, in.Messages())
+		require.Equal(t, []Input{sequenced(input(p2_id, 1, "b"), 1)}, in.Player(p2_id))
+		require.True(t, ib.drain().Empty())
+	})
+
+	t.Run("players that leave start again from frame 1", func(t *testing.T) {
+		ib := newInputBuffer()
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+		ib.addEvent(messages.NewPlayerLeftMessage(p1_id))
+		require.True(t, ib.addPlayerInput(input(p1_id, 1, "a")))
+	})
+
+	t.Run("game loop acknowledges frames", func(t *testing.T) {
+		g := NewGame(logrus.New(), 1)
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		var writesMutex sync.Mutex
+		var writes []messages.GameMessage
+		mockPlayer.EXPECT().Write(gomock.Any()).DoAndReturn(func(msg messages.GameMessage) error {
+			writesMutex.Lock()
+			defer writesMutex.Unlock()
+			writes = append(writes, msg)
+			return nil
+		}).AnyTimes()
+		g.Players[p1_id] = mockPlayer
+
+		var received []FrameInputs
+		gameTick := func(
+			ctx context.Context, g *Game, in Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			received = append(received, in.Players[p1_id]...)
+			complete = len(received) == 2
+			return
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- g.loop(gameTick, 10*time.Millisecond)
+		}()
+		g.playerInputs <- input(p1_id, 2, "b")
+		g.playerInputs <- input(p1_id, 1, "a")
+		require.NoError(t, <-done)
+
+		require.Equal(t, []uint64{1, 2}, []uint64{received[0].Frame, received[1].Frame})
+		writesMutex.Lock()
+		defer writesMutex.Unlock()
+		require.Len(t, writes, 1)
+		require.Equal(t, messages.INPUT_ACK, writes[0].Code)
+		require.Equal(t, uint64(2), writes[0].Data)
+	})
+}
@@ -0,0 +1,352 @@
+package game_instance
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_LOCKSTEP_INPUT_TIMEOUT_MS = 250
+	// MAX_LOCKSTEP_TURNS_AHEAD is how far past the next turn a player can send input
+	MAX_LOCKSTEP_TURNS_AHEAD = 64
+	// LOCKSTEP_CHECKSUM_WINDOW_TURNS is how long checksums for a turn are kept waiting for every player to report
+	LOCKSTEP_CHECKSUM_WINDOW_TURNS = 64
+)
+
+// LockstepConfig configures LOOP_MODE_LOCKSTEP
+//
+// In lockstep the server doesn't simulate the game, it collects every player's input for a turn and
+// broadcasts the combined inputs so each client can run the turn deterministically
+type LockstepConfig struct {
+	// InputDelay is the number of turns between a client sending input and the turn it is run on,
+	// the first InputDelay turns are broadcast without inputs
+	InputDelay int
+	// InputTimeout is how long past its scheduled time a turn waits for slow players before their input is left empty,
+	// defaults to DEFAULT_LOCKSTEP_INPUT_TIMEOUT_MS if 0
+	InputTimeout time.Duration
+}
+
+// lockstepMessage is an input or checksum received from a player, handled on the game loop
+type lockstepMessage struct {
+	playerID string
+	msg      messages.GameMessage
+}
+
+// lockstep holds the inputs and checksums received for turns that haven't been broadcast or compared yet
+type lockstep struct {
+	inputDelay   uint64
+	inputTimeout time.Duration
+	interval     time.Duration
+	start        time.Time
+
+	// turn is the next turn to broadcast
+	turn      uint64
+	inputs    map[uint64]map[string]interface{}
+	checksums map[uint64]map[string]string
+}
+
+func newLockstep(config LockstepConfig, interval time.Duration, start time.Time) (ls *lockstep) {
+	ls = &lockstep{
+		inputTimeout: config.InputTimeout,
+		interval:     interval,
+		start:        start,
+		turn:         1,
+		inputs:       make(map[uint64]map[string]interface{}),
+		checksums:    make(map[uint64]map[string]string),
+	}
+	if config.InputDelay > 0 {
+		ls.inputDelay = uint64(config.InputDelay)
+	}
+	if ls.inputTimeout <= 0 {
+		ls.inputTimeout = DEFAULT_LOCKSTEP_INPUT_TIMEOUT_MS * time.Millisecond
+	}
+	return
+}
+
+// scheduledAt returns when a turn is broadcast if every player's input is in
+func (ls *lockstep) scheduledAt(turn uint64) time.Time {
+	return ls.start.Add(time.Duration(turn) * ls.interval)
+}
+
+// addInput stores a player's input for a turn
+//
+// Only the first input from a player for a turn is kept. Input for a turn that was already broadcast is dropped,
+// the player was listed as missing from it and sends their input again for a later turn
+func (ls *lockstep) addInput(playerID string, turn uint64, input interface{}) (err error) {
+	if turn < ls.turn {
+		err = errors.ErrLockstepInputLate
+		return
+	}
+	if turn > ls.turn+MAX_LOCKSTEP_TURNS_AHEAD {
+		err = errors.ErrLockstepInputTooFarAhead
+		return
+	}
+	inputs, exists := ls.inputs[turn]
+	if !exists {
+		inputs = make(map[string]interface{})
+		ls.inputs[turn] = inputs
+	}
+	if _, exists = inputs[playerID]; exists {
+		err = errors.ErrLockstepDuplicateInput
+		return
+	}
+	inputs[playerID] = input
+	return
+}
+
+// ready returns true if the next turn can be broadcast, otherwise how long until it should be checked again
+//
+// A turn is ready once it is scheduled and every player's input is in, or once its input timeout has passed
+func (ls *lockstep) ready(now time.Time, playerIDs []string) (ready bool, wait time.Duration) {
+	scheduled := ls.scheduledAt(ls.turn)
+	if now.Before(scheduled) {
+		return false, scheduled.Sub(now)
+	}
+	if ls.turn <= ls.inputDelay {
+		return true, 0
+	}
+	inputs := ls.inputs[ls.turn]
+	complete := true
+	for _, playerID := range playerIDs {
+		if _, exists := inputs[playerID]; !exists {
+			complete = false
+			break
+		}
+	}
+	if complete {
+		return true, 0
+	}
+	deadline := scheduled.Add(ls.inputTimeout)
+	if !now.Before(deadline) {
+		return true, 0
+	}
+	return false, deadline.Sub(now)
+}
+
+// closeTurn returns the inputs for the next turn and moves on to the following turn
+// Players without input for the turn are given a nil input and listed as missing
+func (ls *lockstep) closeTurn(playerIDs []string) (turn messages.LockstepTurn) {
+	turn = messages.LockstepTurn{
+		Turn:   ls.turn,
+		Inputs: ls.inputs[ls.turn],
+	}
+	if turn.Inputs == nil {
+		turn.Inputs = make(map[string]interface{})
+	}
+	// Turns within the input delay are empty by design, no one is missing from them
+	if ls.turn > ls.inputDelay {
+		for _, playerID := range playerIDs {
+			if _, exists := turn.Inputs[playerID]; !exists {
+				turn.Inputs[playerID] = nil
+				turn.Missing = append(turn.Missing, playerID)
+			}
+		}
+	}
+	sort.Strings(turn.Missing)
+	delete(ls.inputs, ls.turn)
+	ls.turn++
+	return
+}
+
+// addChecksum stores a player's checksum for a turn
+// Once every player has reported the turn, the checksums are compared and a desync is returned if they differ
+func (ls *lockstep) addChecksum(playerID string, turn uint64, checksum string, playerIDs []string) (desync *messages.LockstepDesync) {
+	if turn == 0 || turn >= ls.turn || turn+LOCKSTEP_CHECKSUM_WINDOW_TURNS < ls.turn {
+		return
+	}
+	checksums, exists := ls.checksums[turn]
+	if !exists {
+		checksums = make(map[string]string)
+		ls.checksums[turn] = checksums
+	}
+	checksums[playerID] = checksum
+
+	for _, p := range playerIDs {
+		if _, exists := checksums[p]; !exists {
+			return
+		}
+	}
+	delete(ls.checksums, turn)
+	return compareChecksums(turn, checksums)
+}
+
+// pruneChecksums compares and drops the checksums of turns that are too old to wait for the remaining players
+func (ls *lockstep) pruneChecksums() (desyncs []messages.LockstepDesync) {
+	for turn, checksums := range ls.checksums {
+		if turn+LOCKSTEP_CHECKSUM_WINDOW_TURNS >= ls.turn {
+			continue
+		}
+		delete(ls.checksums, turn)
+		if desync := compareChecksums(turn, checksums); desync != nil {
+			desyncs = append(desyncs, *desync)
+		}
+	}
+	return
+}
+
+func compareChecksums(turn uint64, checksums map[string]string) *messages.LockstepDesync {
+	var first string
+	var set bool
+	for _, checksum := range checksums {
+		if !set {
+			first, set = checksum, true
+		} else if checksum != first {
+			return &messages.LockstepDesync{
+				Turn:      turn,
+				Checksums: checksums,
+			}
+		}
+	}
+	return nil
+}
+
+// activePlayerIDs returns the players whose input a lockstep turn waits for, disconnected players aren't waited for
+func (g *Game) activePlayerIDs() (playerIDs []string) {
+	g.PlayersMutex.RLock()
+	defer g.PlayersMutex.RUnlock()
+	for playerID := range g.Players {
+		if _, disconnected := g.disconnected[playerID]; !disconnected {
+			playerIDs = append(playerIDs, playerID)
+		}
+	}
+	return
+}
+
+// Turn returns the lockstep turn currently being run, GameTick can use it to follow the game in LOOP_MODE_LOCKSTEP
+func (g *Game) Turn() messages.LockstepTurn {
+	return g.turn
+}
+
+// lockstepLoop broadcasts turns as they are ready until the game completes or ends
+//
+// GameTick is called once for every turn after its inputs are collected, with the events and messages that aren't lockstep inputs
+func (g *Game) lockstepLoop(gameTick GameTick, interval time.Duration) (err error) {
+	ls := newLockstep(g.Lockstep, interval, time.Now())
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	g.sendMessagesToPlayers(g.broadcast(messages.NewLockstepStartMessage(int(ls.inputDelay), interval)))
+
+	inputs := newInputBuffer()
+	for {
+		// Broadcast every turn that is ready, then wait until the next one could be
+		for {
+			playerIDs := g.activePlayerIDs()
+			ready, wait := ls.ready(time.Now(), playerIDs)
+			if !ready {
+				resetTimer(timer, wait)
+				break
+			}
+			turn := ls.closeTurn(playerIDs)
+			if len(turn.Missing) > 0 {
+				g.Logger.WithFields(logrus.Fields{
+					"turn":    turn.Turn,
+					"missing": turn.Missing,
+				}).Debug("lockstep turn closed without every player's input")
+			}
+			var complete bool
+			if complete, err = g.runTurn(gameTick, turn, inputs, interval); err != nil || complete {
+				return
+			}
+			for _, desync := range ls.pruneChecksums() {
+				g.reportDesync(desync)
+			}
+		}
+
+		select {
+		case <-timer.C:
+
+		case lm := <-g.lockstepMessages:
+			g.handleLockstepMessage(ls, lm)
+
+		case req := <-g.resyncRequests:
+			g.handleResync(req)
+
+		case fn := <-g.controls:
+			fn()
+
+		case <-g.Context.Done():
+			err = g.Context.Err()
+			return
+
+		case msg := <-g.GameMessages:
+			inputs.addEvent(msg)
+
+		case in := <-g.playerInputs:
+			g.bufferPlayerInput(inputs, in)
+		}
+	}
+}
+
+// runTurn runs GameTick for a lockstep turn and broadcasts the turn's inputs
+func (g *Game) runTurn(
+	gameTick GameTick,
+	turn messages.LockstepTurn,
+	inputs *inputBuffer,
+	interval time.Duration,
+) (complete bool, err error) {
+	g.turn = turn
+	step := Step{
+		Tick:  turn.Turn,
+		Delta: interval,
+		Time:  time.Duration(turn.Turn) * interval,
+	}
+	g.step = step
+	start := time.Now()
+
+	var out map[string][]messages.GameMessage
+	if complete, out, err = g.Tick(gameTick, inputs.drain()); err != nil {
+		g.Logger.Errorf("error in gametick: %s", err.Error())
+		return
+	}
+	g.recordTick(step, time.Since(start), interval)
+
+	g.sendMessagesToPlayers(g.broadcast(messages.NewLockstepTurnMessage(turn)))
+	g.sendMessagesToPlayers(out)
+	g.sendMessagesToPlayers(inputs.acks())
+	return
+}
+
+// handleLockstepMessage stores an input or checksum from a player
+func (g *Game) handleLockstepMessage(ls *lockstep, lm lockstepMessage) {
+	switch lm.msg.Code {
+	case messages.LOCKSTEP_INPUT:
+		if err := ls.addInput(lm.playerID, lm.msg.Tick, lm.msg.Data); err != nil {
+			g.Logger.WithFields(logrus.Fields{
+				"playerID": lm.playerID,
+				"turn":     lm.msg.Tick,
+				"error":    err.Error(),
+			}).Debug("dropped lockstep input")
+		}
+	case messages.LOCKSTEP_CHECKSUM:
+		checksum := fmt.Sprint(lm.msg.Data)
+		if desync := ls.addChecksum(lm.playerID, lm.msg.Tick, checksum, g.activePlayerIDs()); desync != nil {
+			g.reportDesync(*desync)
+		}
+	}
+}
+
+// reportDesync tells every player that their simulations have diverged
+func (g *Game) reportDesync(desync messages.LockstepDesync) {
+	g.Logger.WithFields(logrus.Fields{
+		"turn":      desync.Turn,
+		"checksums": desync.Checksums,
+	}).Warn("lockstep desync detected")
+	g.sendMessagesToPlayers(g.broadcast(messages.NewLockstepDesyncMessage(desync)))
+}
+
+// resetTimer stops the timer, draining it if it already fired, and restarts it with the duration
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,169 @@
+package game_instance
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	mocks "github.com/gunnermanx/simplegameserver/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockstep(t *testing.T) {
+	p1_id := "p1_id"
+	p2_id := "p2_id"
+	playerIDs := []string{p1_id, p2_id}
+
+	start := time.Now()
+	interval := 10 * time.Millisecond
+	config := LockstepConfig{
+		InputDelay:   1,
+		InputTimeout: 50 * time.Millisecond,
+	}
+
+	t.Run("turns within the input delay don't wait for input", func(t *testing.T) {
+		ls := newLockstep(config, interval, start)
+
+		ready, wait := ls.ready(start, playerIDs)
+		require.False(t, ready)
+		require.Equal(t, interval, wait)
+
+		ready, _ = ls.ready(start.Add(interval), playerIDs)
+		require.True(t, ready)
+		turn := ls.closeTurn(playerIDs)
+		require.Equal(t, uint64(1), turn.Turn)
+		require.Empty(t, turn.Inputs)
+		require.Empty(t, turn.Missing)
+	})
+
+	t.Run("turns wait for every player's input", func(t *testing.T) {
+		ls := newLockstep(config, interval, start)
+		ls.closeTurn(playerIDs)
+
+		require.NoError(t, ls.addInput(p1_id, 2, "move"))
+		require.ErrorIs(t, ls.addInput(p1_id, 2, "attack"), errors.ErrLockstepDuplicateInput)
+
+		ready, wait := ls.ready(start.Add(2*interval), playerIDs)
+		require.False(t, ready)
+		require.Equal(t, config.InputTimeout, wait)
+
+		require.NoError(t, ls.addInput(p2_id, 2, "build"))
+		ready, _ = ls.ready(start.Add(2*interval), playerIDs)
+		require.True(t, ready)
+
+		turn := ls.closeTurn(playerIDs)
+		require.Equal(t, map[string]interface{}{p1_id: "move", p2_id: "build"}, turn.Inputs)
+		require.Empty(t, turn.Missing)
+	})
+
+	t.Run("slow players get empty input after the timeout", func(t *testing.T) {
+		ls := newLockstep(config, interval, start)
+		ls.closeTurn(playerIDs)
+		require.NoError(t, ls.addInput(p1_id, 2, "move"))
+
+		ready, _ := ls.ready(start.Add(2*interval).Add(config.InputTimeout), playerIDs)
+		require.True(t, ready)
+		turn := ls.closeTurn(playerIDs)
+		require.Equal(t, map[string]interface{}{p1_id: "move", p2_id: nil}, turn.Inputs)
+		require.Equal(t, []string{p2_id}, turn.Missing)
+
+		// Late input is dropped and doesn't take the player's input for the current turn
+		require.ErrorIs(t, ls.addInput(p2_id, 2, "build"), errors.ErrLockstepInputLate)
+		require.NoError(t, ls.addInput(p2_id, 3, "attack"))
+		require.Equal(t, "attack", ls.inputs[3][p2_id])
+		require.NotContains(t, ls.inputs, uint64(2))
+
+		require.ErrorIs(t, ls.addInput(p1_id, 3+MAX_LOCKSTEP_TURNS_AHEAD+1, "move"), errors.ErrLockstepInputTooFarAhead)
+	})
+
+	t.Run("checksums", func(t *testing.T) {
+		ls := newLockstep(config, interval, start)
+		ls.closeTurn(playerIDs)
+		ls.closeTurn(playerIDs)
+
+		require.Nil(t, ls.addChecksum(p1_id, 1, "abc", playerIDs))
+		require.Nil(t, ls.addChecksum(p2_id, 1, "abc", playerIDs))
+		require.Empty(t, ls.checksums)
+
+		require.Nil(t, ls.addChecksum(p1_id, 2, "abc", playerIDs))
+		desync := ls.addChecksum(p2_id, 2, "def", playerIDs)
+		require.NotNil(t, desync)
+		require.Equal(t, uint64(2), desync.Turn)
+		require.Equal(t, map[string]string{p1_id: "abc", p2_id: "def"}, desync.Checksums)
+
+		// Checksums for turns that haven't been broadcast are ignored
+		require.Nil(t, ls.addChecksum(p1_id, 5, "abc", playerIDs))
+		require.Empty(t, ls.checksums)
+	})
+
+	t.Run("run lockstep game", func(t *testing.T) {
+		g := NewGame(logrus.New(), 2)
+		g.LoopMode = LOOP_MODE_LOCKSTEP
+		g.Lockstep = config
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+
+		var writesMutex sync.Mutex
+		writes := map[string][]messages.GameMessage{}
+		for _, playerID := range playerIDs {
+			playerID := playerID
+			mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+			mockPlayer.EXPECT().GetID().Return(playerID).AnyTimes()
+			mockPlayer.EXPECT().Write(gomock.Any()).DoAndReturn(func(msg messages.GameMessage) error {
+				writesMutex.Lock()
+				defer writesMutex.Unlock()
+				writes[playerID] = append(writes[playerID], msg)
+				return nil
+			}).AnyTimes()
+			g.Players[playerID] = mockPlayer
+		}
+
+		gameTick := func(
+			ctx context.Context, g *Game, in Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			complete = g.Turn().Turn == 3
+			return
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- g.lockstepLoop(gameTick, interval)
+		}()
+
+		send := func(playerID string, code int, turn uint64, data interface{}) {
+			g.lockstepMessages <- lockstepMessage{
+				playerID: playerID,
+				msg:      messages.GameMessage{Code: code, Tick: turn, Data: data},
+			}
+		}
+		send(p1_id, messages.LOCKSTEP_INPUT, 2, "move")
+		send(p2_id, messages.LOCKSTEP_INPUT, 2, "build")
+		send(p1_id, messages.LOCKSTEP_INPUT, 3, "attack")
+		require.NoError(t, <-done)
+
+		writesMutex.Lock()
+		defer writesMutex.Unlock()
+		msgs := writes[p2_id]
+		require.Len(t, msgs, 4)
+		require.Equal(t, messages.LOCKSTEP_START, msgs[0].Code)
+		require.Equal(t, messages.LockstepStart{InputDelay: 1, TurnIntervalMS: 10}, msgs[0].Data)
+
+		turns := []messages.LockstepTurn{}
+		for _, msg := range msgs[1:] {
+			require.Equal(t, messages.LOCKSTEP_TURN, msg.Code)
+			turn := msg.Data.(messages.LockstepTurn)
+			require.Equal(t, turn.Turn, msg.Tick)
+			turns = append(turns, turn)
+		}
+		require.Empty(t, turns[0].Inputs)
+		require.Equal(t, map[string]interface{}{p1_id: "move", p2_id: "build"}, turns[1].Inputs)
+		require.Equal(t, map[string]interface{}{p1_id: "attack", p2_id: nil}, turns[2].Inputs)
+		require.Equal(t, []string{p2_id}, turns[2].Missing)
+	})
+}
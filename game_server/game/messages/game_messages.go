@@ -1,13 +1,82 @@
 package game_messages
 
+import "time"
+
 const (
-	PLAYER_JOINED = 10
-	PLAYER_LEFT   = 11
+	PLAYER_JOINED       = 10
+	PLAYER_LEFT         = 11
+	PLAYER_DISCONNECTED = 12
+	PLAYER_RECONNECTED  = 13
+	PLAYER_KICKED       = 14
+
+	STATE_SNAPSHOT = 20
+	STATE_DELTA    = 21
+	RESYNC_REQUEST = 22
+	// INPUT_ACK tells a player the highest frame up to which all of their input has been received
+	INPUT_ACK = 23
+
+	SERVER_SHUTDOWN = 30
+	SYSTEM_MESSAGE  = 31
+	GAME_ENDED      = 32
+
+	// LOCKSTEP_INPUT and LOCKSTEP_CHECKSUM are sent by clients with Tick set to the turn they are for
+	LOCKSTEP_START    = 40
+	LOCKSTEP_INPUT    = 41
+	LOCKSTEP_TURN     = 42
+	LOCKSTEP_CHECKSUM = 43
+	LOCKSTEP_DESYNC   = 44
 )
 
+// GameMessage is the message sent between the clients and the server
+//
+// Tick is set by the server on outbound messages to the tick they were produced on,
+// so clients can detect gaps and send a RESYNC_REQUEST
+//
+// Frame is optionally set by clients on inbound messages to the client frame the input was produced on,
+// frames start at 1 and the server acknowledges them with INPUT_ACK
 type GameMessage struct {
-	Code int         `json:"code"`
-	Data interface{} `json:"data"`
+	Code  int         `json:"code"`
+	Tick  uint64      `json:"tick"`
+	Frame uint64      `json:"frame,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+// StateDelta is the data of a STATE_DELTA message
+type StateDelta struct {
+	SinceTick uint64      `json:"sinceTick"`
+	Delta     interface{} `json:"delta"`
+}
+
+// ServerShutdown is the data of a SERVER_SHUTDOWN message
+// Deadline is the unix time the game will be ended at if it hasn't completed
+type ServerShutdown struct {
+	Deadline int64 `json:"deadline"`
+}
+
+// Reason is the data of PLAYER_KICKED and GAME_ENDED messages sent by operators
+type Reason struct {
+	Reason string `json:"reason"`
+}
+
+// LockstepStart is the data of a LOCKSTEP_START message, sent when a lockstep game starts
+// Clients send their input for turn N while running turn N-InputDelay
+type LockstepStart struct {
+	InputDelay     int `json:"inputDelay"`
+	TurnIntervalMS int `json:"turnIntervalMS"`
+}
+
+// LockstepTurn is the data of a LOCKSTEP_TURN message, the inputs every player runs for a turn
+// Players in Missing didn't send their input in time and have a nil input
+type LockstepTurn struct {
+	Turn    uint64                 `json:"turn"`
+	Inputs  map[string]interface{} `json:"inputs"`
+	Missing []string               `json:"missing,omitempty"`
+}
+
+// LockstepDesync is the data of a LOCKSTEP_DESYNC message, sent when players report different checksums for a turn
+type LockstepDesync struct {
+	Turn      uint64            `json:"turn"`
+	Checksums map[string]string `json:"checksums"`
 }
 
 func NewPlayerJoinedMessage(playerID string) (g GameMessage) {
@@ -23,3 +92,119 @@ func NewPlayerLeftMessage(playerID string) (g GameMessage) {
 		Data: playerID,
 	}
 }
+
+func NewPlayerDisconnectedMessage(playerID string) (g GameMessage) {
+	return GameMessage{
+		Code: PLAYER_DISCONNECTED,
+		Data: playerID,
+	}
+}
+
+func NewPlayerReconnectedMessage(playerID string) (g GameMessage) {
+	return GameMessage{
+		Code: PLAYER_RECONNECTED,
+		Data: playerID,
+	}
+}
+
+func NewStateSnapshotMessage(snapshot interface{}) (g GameMessage) {
+	return GameMessage{
+		Code: STATE_SNAPSHOT,
+		Data: snapshot,
+	}
+}
+
+func NewStateDeltaMessage(sinceTick uint64, delta interface{}) (g GameMessage) {
+	return GameMessage{
+		Code: STATE_DELTA,
+		Data: StateDelta{
+			SinceTick: sinceTick,
+			Delta:     delta,
+		},
+	}
+}
+
+// ParseResyncRequest returns the last tick the client has from a RESYNC_REQUEST message
+// A missing or invalid tick returns 0, which requests a full snapshot
+func ParseResyncRequest(msg GameMessage) (sinceTick uint64) {
+	switch tick := msg.Data.(type) {
+	case float64:
+		if tick > 0 {
+			sinceTick = uint64(tick)
+		}
+	case uint64:
+		sinceTick = tick
+	case int64:
+		if tick > 0 {
+			sinceTick = uint64(tick)
+		}
+	case int:
+		if tick > 0 {
+			sinceTick = uint64(tick)
+		}
+	}
+	return
+}
+
+func NewInputAckMessage(frame uint64) (g GameMessage) {
+	return GameMessage{
+		Code: INPUT_ACK,
+		Data: frame,
+	}
+}
+
+func NewServerShutdownMessage(deadline time.Time) (g GameMessage) {
+	return GameMessage{
+		Code: SERVER_SHUTDOWN,
+		Data: ServerShutdown{
+			Deadline: deadline.Unix(),
+		},
+	}
+}
+
+func NewPlayerKickedMessage(reason string) (g GameMessage) {
+	return GameMessage{
+		Code: PLAYER_KICKED,
+		Data: Reason{Reason: reason},
+	}
+}
+
+func NewSystemMessage(text string) (g GameMessage) {
+	return GameMessage{
+		Code: SYSTEM_MESSAGE,
+		Data: text,
+	}
+}
+
+func NewGameEndedMessage(reason string) (g GameMessage) {
+	return GameMessage{
+		Code: GAME_ENDED,
+		Data: Reason{Reason: reason},
+	}
+}
+
+func NewLockstepStartMessage(inputDelay int, turnInterval time.Duration) (g GameMessage) {
+	return GameMessage{
+		Code: LOCKSTEP_START,
+		Data: LockstepStart{
+			InputDelay:     inputDelay,
+			TurnIntervalMS: int(turnInterval / time.Millisecond),
+		},
+	}
+}
+
+func NewLockstepTurnMessage(turn LockstepTurn) (g GameMessage) {
+	return GameMessage{
+		Code: LOCKSTEP_TURN,
+		Tick: turn.Turn,
+		Data: turn,
+	}
+}
+
+func NewLockstepDesyncMessage(desync LockstepDesync) (g GameMessage) {
+	return GameMessage{
+		Code: LOCKSTEP_DESYNC,
+		Tick: desync.Turn,
+		Data: desync,
+	}
+}
@@ -0,0 +1,56 @@
+package game_instance
+
+import (
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/metrics"
+)
+
+// TickBuckets are the tick duration histogram buckets in seconds, ticks are expected to take a few milliseconds
+var TickBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.016, 0.025, 0.033, 0.05, 0.1, 0.25, 0.5}
+
+// Metrics are recorded by the games on a server, labelled by game type
+type Metrics struct {
+	TickDuration *metrics.HistogramVec
+	TickOverruns *metrics.CounterVec
+	TicksSkipped *metrics.CounterVec
+}
+
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		TickDuration: r.NewHistogramVec(
+			"sgs_game_tick_duration_seconds",
+			"Time taken to run a game tick.",
+			TickBuckets,
+			"game_type",
+		),
+		TickOverruns: r.NewCounterVec(
+			"sgs_game_tick_overruns_total",
+			"Game ticks that took longer than the tick interval.",
+			"game_type",
+		),
+		TicksSkipped: r.NewCounterVec(
+			"sgs_game_ticks_skipped_total",
+			"Game ticks that were never run because the game loop fell behind.",
+			"game_type",
+		),
+	}
+}
+
+// observeTick records how long a tick took, a tick overruns if it took longer than the tick interval
+func (g *Game) observeTick(took time.Duration, interval time.Duration) {
+	if g.Metrics == nil {
+		return
+	}
+	g.Metrics.TickDuration.WithLabelValues(g.GameType).Observe(took.Seconds())
+	if took > interval {
+		g.Metrics.TickOverruns.WithLabelValues(g.GameType).Inc()
+	}
+}
+
+func (g *Game) observeSkipped(skipped uint64) {
+	if g.Metrics == nil {
+		return
+	}
+	g.Metrics.TicksSkipped.WithLabelValues(g.GameType).Add(float64(skipped))
+}
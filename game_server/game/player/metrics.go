@@ -0,0 +1,39 @@
+package game_player
+
+import (
+	"github.com/gunnermanx/simplegameserver/metrics"
+)
+
+const (
+	DIRECTION_IN  = "in"
+	DIRECTION_OUT = "out"
+)
+
+// Metrics count the game messages sent and received over player connections, labelled by direction and codec
+type Metrics struct {
+	Messages *metrics.CounterVec
+	Bytes    *metrics.CounterVec
+}
+
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		Messages: r.NewCounterVec(
+			"sgs_player_messages_total",
+			"Game messages received from and sent to player connections.",
+			"direction", "codec",
+		),
+		Bytes: r.NewCounterVec(
+			"sgs_player_message_bytes_total",
+			"Encoded size of game messages received from and sent to player connections.",
+			"direction", "codec",
+		),
+	}
+}
+
+func (m *Metrics) observe(direction string, codec string, size int) {
+	if m == nil {
+		return
+	}
+	m.Messages.WithLabelValues(direction, codec).Inc()
+	m.Bytes.WithLabelValues(direction, codec).Add(float64(size))
+}
@@ -6,10 +6,10 @@ import (
 	"net/http"
 
 	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	codec "github.com/gunnermanx/simplegameserver/game_server/game/codec"
 	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
 	"github.com/pkg/errors"
 	"nhooyr.io/websocket"
-	"nhooyr.io/websocket/wsjson"
 )
 
 // SGSGamePlayer models a player connected to a game
@@ -17,18 +17,29 @@ import (
 type SGSGamePlayer struct {
 	ID     string
 	WSConn *websocket.Conn
+	Codec  codec.Codec
+	// Metrics, if set, counts the messages sent and received over the connection
+	Metrics *Metrics
 
 	RWCtx       context.Context
 	RWCtxCancel context.CancelFunc
 }
 
-func NewSGSGamePlayer(id string, w http.ResponseWriter, r *http.Request) (p *SGSGamePlayer, err error) {
+// NewSGSGamePlayer accepts the websocket connection for a player
+//
+// The codec used for the connection is negotiated with the client using the websocket subprotocol,
+// codecs are listed in order of preference and the first one is used if the client doesn't request one
+func NewSGSGamePlayer(id string, w http.ResponseWriter, r *http.Request, codecs []codec.Codec) (p *SGSGamePlayer, err error) {
 	p = &SGSGamePlayer{
 		ID: id,
 	}
-	if p.WSConn, err = websocket.Accept(w, r, nil); err != nil {
+	if p.WSConn, err = websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: codec.Subprotocols(codecs),
+	}); err != nil {
 		err = errors.Wrap(err, "failed creating player")
+		return
 	}
+	p.Codec = codec.Negotiate(p.WSConn.Subprotocol(), codecs)
 	p.RWCtx, p.RWCtxCancel = context.WithCancel(context.Background())
 	return
 }
@@ -42,27 +53,41 @@ func (p *SGSGamePlayer) GetContext() context.Context {
 }
 
 func (p *SGSGamePlayer) Read() (gamemsg messages.GameMessage, err error) {
-	if err = wsjson.Read(p.RWCtx, p.WSConn, &gamemsg); err != nil {
+	var data []byte
+	if _, data, err = p.WSConn.Read(p.RWCtx); err != nil {
 		if errors.Is(err, context.Canceled) {
 			p.WSConn.Close(websocket.StatusInternalError, "context cancelled")
 			err = sgs_errors.ErrContextCancelled
-		} else if errors.Is(err, io.EOF) {
+		} else if errors.Is(err, io.EOF) || websocket.CloseStatus(err) != -1 {
 			p.WSConn.Close(websocket.StatusNormalClosure, "socket closed")
 			err = sgs_errors.ErrPlayerConnectionClosed
 		} else {
 			p.WSConn.Close(websocket.StatusProtocolError, "bad game message")
 			err = sgs_errors.ErrPlayerBadGameMessage
 		}
+		return
+	}
+	p.Metrics.observe(DIRECTION_IN, p.Codec.Subprotocol(), len(data))
+	if gamemsg, err = p.Codec.Decode(data); err != nil {
+		p.WSConn.Close(websocket.StatusInvalidFramePayloadData, "bad game message")
+		err = sgs_errors.ErrPlayerBadGameMessage
 	}
 	return
 }
 
 func (p *SGSGamePlayer) Write(gamemsg messages.GameMessage) (err error) {
-	if err = wsjson.Write(p.RWCtx, p.WSConn, &gamemsg); err != nil {
+	var data []byte
+	if data, err = p.Codec.Encode(gamemsg); err != nil {
+		err = errors.Wrap(err, "failed encoding game message")
+		return
+	}
+	if err = p.WSConn.Write(p.RWCtx, p.Codec.MessageType(), data); err != nil {
 		// TODO
 		p.WSConn.Close(websocket.StatusInternalError, "todo")
 		err = sgs_errors.ErrPlayerBadGameMessage
+		return
 	}
+	p.Metrics.observe(DIRECTION_OUT, p.Codec.Subprotocol(), len(data))
 	return
 }
 
@@ -0,0 +1,247 @@
+package game_replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	codec "github.com/gunnermanx/simplegameserver/game_server/game/codec"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/pkg/errors"
+)
+
+const (
+	FORMAT_VERSION = 5
+)
+
+// wireCodec encodes the player messages in a replay
+var wireCodec = codec.MsgpackCodec{}
+
+const (
+	EVENT_HEADER = "h"
+	EVENT_INIT   = "i"
+	EVENT_TICK   = "t"
+	EVENT_SENT   = "s"
+)
+
+// Event is a single entry in a replay file
+//
+// A replay file is newline delimited JSON, starting with a header event followed by an init event
+// and one tick event for every call to GameTick. Sent events, holding every message sent to players
+// on a tick, are interleaved with them and aren't needed to replay the game
+type Event struct {
+	Type      string                            `json:"e"`
+	Version   int                               `json:"v,omitempty"`
+	GameID    string                            `json:"g,omitempty"`
+	Tick      uint64                            `json:"k,omitempty"`
+	Time      int64                             `json:"ts,omitempty"`
+	PlayerIDs []string                          `json:"p,omitempty"`
+	Setup     *game.Setup                       `json:"su,omitempty"`
+	Step      *game.Step                        `json:"s,omitempty"`
+	Turn      *Turn                             `json:"l,omitempty"`
+	In        *Inputs                           `json:"in,omitempty"`
+	Out       map[string][]messages.GameMessage `json:"out,omitempty"`
+}
+
+// Inputs are the inputs of a tick as they are stored in a replay file
+type Inputs struct {
+	Events  []messages.GameMessage   `json:"events,omitempty"`
+	Players map[string][]FrameInputs `json:"players,omitempty"`
+}
+
+// FrameInputs are the messages a player produced on a single client frame
+type FrameInputs struct {
+	Frame    uint64  `json:"frame"`
+	Messages []Input `json:"messages"`
+}
+
+// Input is a player message as it is stored in a replay file
+//
+// The message is kept encoded with the msgpack codec, which unlike JSON keeps []byte and integer data intact
+type Input struct {
+	PlayerID   string    `json:"playerID"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Seq        uint64    `json:"seq"`
+	Message    []byte    `json:"msg"`
+}
+
+// Turn is a lockstep turn as it is stored in a replay file, each player's input is kept encoded like Input
+type Turn struct {
+	Turn    uint64            `json:"turn"`
+	Inputs  map[string][]byte `json:"inputs"`
+	Missing []string          `json:"missing,omitempty"`
+}
+
+// StreamRecorder writes replay events to a stream, it implements game.Recorder
+type StreamRecorder struct {
+	mutex   sync.Mutex
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewStreamRecorder creates a recorder that writes the replay for the given game to w
+func NewStreamRecorder(gameID string, w io.Writer) (r *StreamRecorder, err error) {
+	r = &StreamRecorder{
+		writer: bufio.NewWriter(w),
+	}
+	r.encoder = json.NewEncoder(r.writer)
+	err = r.write(Event{
+		Type:    EVENT_HEADER,
+		Version: FORMAT_VERSION,
+		GameID:  gameID,
+	})
+	return
+}
+
+// NewFileRecorder creates a recorder that appends the replay for the given game to the file at path
+func NewFileRecorder(gameID string, path string) (r *StreamRecorder, err error) {
+	var f *os.File
+	if f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		err = errors.Wrap(err, "failed opening replay file")
+		return
+	}
+	if r, err = NewStreamRecorder(gameID, f); err != nil {
+		f.Close()
+		return
+	}
+	r.closer = f
+	return
+}
+
+func (r *StreamRecorder) RecordInit(
+	at time.Time,
+	setup game.Setup,
+	playerIDs []string,
+	out map[string][]messages.GameMessage,
+) error {
+	return r.write(Event{
+		Type:      EVENT_INIT,
+		Time:      at.UnixNano(),
+		Setup:     &setup,
+		PlayerIDs: playerIDs,
+		Out:       out,
+	})
+}
+
+func (r *StreamRecorder) RecordTick(
+	tick uint64,
+	at time.Time,
+	step game.Step,
+	turn messages.LockstepTurn,
+	in game.Inputs,
+	out map[string][]messages.GameMessage,
+) (err error) {
+	e := Event{
+		Type: EVENT_TICK,
+		Tick: tick,
+		Time: at.UnixNano(),
+		Step: &step,
+		Out:  out,
+	}
+	if e.In, err = encodeInputs(in); err != nil {
+		return
+	}
+	if turn.Turn != 0 {
+		if e.Turn, err = encodeTurn(turn); err != nil {
+			return
+		}
+	}
+	return r.write(e)
+}
+
+func (r *StreamRecorder) RecordSent(tick uint64, at time.Time, sent map[string][]messages.GameMessage) error {
+	return r.write(Event{
+		Type: EVENT_SENT,
+		Tick: tick,
+		Time: at.UnixNano(),
+		Out:  sent,
+	})
+}
+
+// Close flushes the recorder and closes the underlying file if there is one
+func (r *StreamRecorder) Close() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err = r.writer.Flush(); err != nil {
+		err = errors.Wrap(err, "failed flushing replay")
+	}
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); closeErr != nil && err == nil {
+			err = errors.Wrap(closeErr, "failed closing replay file")
+		}
+	}
+	return
+}
+
+// write appends an event and flushes it so the replay survives a crash
+func (r *StreamRecorder) write(e Event) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err = r.encoder.Encode(e); err != nil {
+		err = errors.Wrap(err, "failed encoding replay event")
+		return
+	}
+	if err = r.writer.Flush(); err != nil {
+		err = errors.Wrap(err, "failed writing replay event")
+	}
+	return
+}
+
+// encodeInputs converts a tick's inputs to the form they are stored in, see Input
+func encodeInputs(in game.Inputs) (encoded *Inputs, err error) {
+	encoded = &Inputs{
+		Events: in.Events,
+	}
+	for playerID, frames := range in.Players {
+		if encoded.Players == nil {
+			encoded.Players = make(map[string][]FrameInputs, len(in.Players))
+		}
+		encodedFrames := make([]FrameInputs, len(frames))
+		for i, frame := range frames {
+			encodedFrames[i] = FrameInputs{
+				Frame:    frame.Frame,
+				Messages: make([]Input, len(frame.Messages)),
+			}
+			for j, input := range frame.Messages {
+				var b []byte
+				if b, err = wireCodec.Encode(input.GameMessage); err != nil {
+					err = errors.Wrapf(err, "failed encoding input from player %s", playerID)
+					return
+				}
+				encodedFrames[i].Messages[j] = Input{
+					PlayerID:   input.PlayerID,
+					ReceivedAt: input.ReceivedAt,
+					Seq:        input.Seq,
+					Message:    b,
+				}
+			}
+		}
+		encoded.Players[playerID] = encodedFrames
+	}
+	return
+}
+
+// encodeTurn converts a lockstep turn to the form it is stored in, see Turn
+func encodeTurn(turn messages.LockstepTurn) (encoded *Turn, err error) {
+	encoded = &Turn{
+		Turn:    turn.Turn,
+		Inputs:  make(map[string][]byte, len(turn.Inputs)),
+		Missing: turn.Missing,
+	}
+	for playerID, input := range turn.Inputs {
+		if encoded.Inputs[playerID], err = wireCodec.Encode(messages.GameMessage{
+			Code: messages.LOCKSTEP_INPUT,
+			Tick: turn.Turn,
+			Data: input,
+		}); err != nil {
+			err = errors.Wrapf(err, "failed encoding lockstep input from player %s", playerID)
+			return
+		}
+	}
+	return
+}
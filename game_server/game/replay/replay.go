@@ -0,0 +1,273 @@
+package game_replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Result is the outcome of replaying a recorded game
+type Result struct {
+	GameID string
+	// Ticks is the number of ticks that were replayed
+	Ticks uint64
+	// Diverged is set if the output of the replay did not match the recording
+	Diverged bool
+	// DivergedTick is the first tick where the output diverged, 0 means GameInit diverged
+	DivergedTick uint64
+	Expected     map[string][]messages.GameMessage
+	Actual       map[string][]messages.GameMessage
+}
+
+// ReplayFile replays the recording at path, see Replay
+func ReplayFile(
+	logger *logrus.Logger,
+	path string,
+	gameInit game.GameInit,
+	gameTick game.GameTick,
+	decoders map[int]game.Decoder,
+) (result *Result, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		err = errors.Wrap(err, "failed opening replay file")
+		return
+	}
+	defer f.Close()
+	return Replay(logger, f, gameInit, gameTick, decoders)
+}
+
+// Replay re-drives gameInit and gameTick headlessly with the inputs from a recording
+//
+// The outputs of every call are compared with the recorded outputs, and replaying stops
+// at the first tick where they diverge. The game is set up with its recorded type, match and teams
+// and each tick is run with its recorded Step and lockstep turn.
+// decoders should be the game type's decoders, they are applied to the recorded player messages
+// and lockstep inputs, which are stored the way the msgpack codec sends them
+func Replay(
+	logger *logrus.Logger,
+	r io.Reader,
+	gameInit game.GameInit,
+	gameTick game.GameTick,
+	decoders map[int]game.Decoder,
+) (result *Result, err error) {
+	decoder := json.NewDecoder(r)
+
+	var header Event
+	if header, err = readEvent(decoder, EVENT_HEADER); err != nil {
+		return
+	}
+	if header.Version != FORMAT_VERSION {
+		err = fmt.Errorf("unsupported replay version: %d", header.Version)
+		return
+	}
+	result = &Result{
+		GameID: header.GameID,
+	}
+
+	var init Event
+	if init, err = readEvent(decoder, EVENT_INIT); err != nil {
+		return
+	}
+
+	g := game.NewGame(logger, len(init.PlayerIDs))
+	defer g.Cancel()
+	if init.Setup != nil {
+		g.ApplySetup(*init.Setup)
+	}
+
+	var out map[string][]messages.GameMessage
+	if out, err = g.Init(gameInit, init.PlayerIDs); err != nil {
+		err = errors.Wrap(err, "gameinit failed during replay")
+		return
+	}
+	if diverged, compareErr := compare(init.Out, out, result); compareErr != nil || diverged {
+		err = compareErr
+		return
+	}
+
+	for {
+		var tick Event
+		if tick, err = readEvent(decoder, EVENT_TICK); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return
+		}
+
+		var in game.Inputs
+		if in, err = decodeInputs(tick.In, decoders); err != nil {
+			err = errors.Wrapf(err, "failed decoding inputs during replay on tick %d", tick.Tick)
+			return
+		}
+		var turn messages.LockstepTurn
+		if turn, err = decodeTurn(tick.Turn, decoders); err != nil {
+			err = errors.Wrapf(err, "failed decoding lockstep turn during replay on tick %d", tick.Tick)
+			return
+		}
+		var step game.Step
+		if tick.Step != nil {
+			step = *tick.Step
+		}
+		g.SetStep(step, turn)
+
+		var complete bool
+		if complete, out, err = g.Tick(gameTick, in); err != nil {
+			err = errors.Wrapf(err, "gametick failed during replay on tick %d", tick.Tick)
+			return
+		}
+		result.Ticks = g.CurrentTick()
+		if g.CurrentTick() != tick.Tick {
+			err = fmt.Errorf("replay out of sequence, expected tick %d but replayed tick %d", tick.Tick, g.CurrentTick())
+			return
+		}
+		if diverged, compareErr := compare(tick.Out, out, result); compareErr != nil || diverged {
+			err = compareErr
+			return
+		}
+		if complete {
+			return
+		}
+	}
+}
+
+// decodeInputs converts recorded inputs back to the inputs GameTick was called with
+func decodeInputs(recorded *Inputs, decoders map[int]game.Decoder) (in game.Inputs, err error) {
+	if recorded == nil {
+		return
+	}
+	in.Events = recorded.Events
+	for playerID, frames := range recorded.Players {
+		if in.Players == nil {
+			in.Players = make(map[string][]game.FrameInputs, len(recorded.Players))
+		}
+		decodedFrames := make([]game.FrameInputs, len(frames))
+		for i, frame := range frames {
+			decodedFrames[i] = game.FrameInputs{
+				Frame:    frame.Frame,
+				Messages: make([]game.Input, len(frame.Messages)),
+			}
+			for j, input := range frame.Messages {
+				var msg messages.GameMessage
+				if msg, err = decodeMessage(input.Message, decoders); err != nil {
+					return
+				}
+				decodedFrames[i].Messages[j] = game.Input{
+					GameMessage: msg,
+					PlayerID:    input.PlayerID,
+					ReceivedAt:  input.ReceivedAt,
+					Seq:         input.Seq,
+				}
+			}
+		}
+		in.Players[playerID] = decodedFrames
+	}
+	return
+}
+
+// decodeTurn converts a recorded lockstep turn back to the turn GameTick was run for
+func decodeTurn(recorded *Turn, decoders map[int]game.Decoder) (turn messages.LockstepTurn, err error) {
+	if recorded == nil {
+		return
+	}
+	turn = messages.LockstepTurn{
+		Turn:    recorded.Turn,
+		Inputs:  make(map[string]interface{}, len(recorded.Inputs)),
+		Missing: recorded.Missing,
+	}
+	for playerID, b := range recorded.Inputs {
+		var msg messages.GameMessage
+		if msg, err = wireCodec.Decode(b); err != nil {
+			return
+		}
+		// Players missing from the turn were given a nil input that never went through a decoder
+		if msg.Data != nil {
+			if msg.Data, err = decode(msg.Code, msg.Data, decoders); err != nil {
+				return
+			}
+		}
+		turn.Inputs[playerID] = msg.Data
+	}
+	return
+}
+
+// decodeMessage decodes a recorded player message and converts its data with the decoder for its code
+func decodeMessage(b []byte, decoders map[int]game.Decoder) (msg messages.GameMessage, err error) {
+	if msg, err = wireCodec.Decode(b); err != nil {
+		return
+	}
+	msg.Data, err = decode(msg.Code, msg.Data, decoders)
+	return
+}
+
+func decode(code int, data interface{}, decoders map[int]game.Decoder) (interface{}, error) {
+	decoder, exists := decoders[code]
+	if !exists {
+		return data, nil
+	}
+	return decoder(data)
+}
+
+// readEvent reads the next event of the given type, skipping sent events which replaying doesn't need
+func readEvent(decoder *json.Decoder, eventType string) (e Event, err error) {
+	for {
+		e = Event{}
+		if err = decoder.Decode(&e); err != nil {
+			if !errors.Is(err, io.EOF) {
+				err = errors.Wrap(err, "failed decoding replay event")
+			}
+			return
+		}
+		if e.Type != EVENT_SENT {
+			break
+		}
+	}
+	if e.Type != eventType {
+		err = fmt.Errorf("unexpected replay event %q, expected %q", e.Type, eventType)
+	}
+	return
+}
+
+// compare checks the replayed output against the recording and fills in the result if they diverge
+//
+// Both sides are normalized through JSON, since recorded messages lose their concrete types
+func compare(expected, actual map[string][]messages.GameMessage, result *Result) (diverged bool, err error) {
+	var expectedJSON, actualJSON []byte
+	if expectedJSON, err = normalize(expected); err != nil {
+		return
+	}
+	if actualJSON, err = normalize(actual); err != nil {
+		return
+	}
+	if !bytes.Equal(expectedJSON, actualJSON) {
+		diverged = true
+		result.Diverged = true
+		result.DivergedTick = result.Ticks
+		result.Expected = expected
+		result.Actual = actual
+	}
+	return
+}
+
+func normalize(out map[string][]messages.GameMessage) (b []byte, err error) {
+	if len(out) == 0 {
+		return
+	}
+	var generic interface{}
+	if b, err = json.Marshal(out); err != nil {
+		err = errors.Wrap(err, "failed encoding game output")
+		return
+	}
+	if err = json.Unmarshal(b, &generic); err != nil {
+		err = errors.Wrap(err, "failed decoding game output")
+		return
+	}
+	b, err = json.Marshal(generic)
+	return
+}
@@ -0,0 +1,244 @@
+package game_replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	p1_id := "p1_id"
+	p2_id := "p2_id"
+
+	logger := logrus.New()
+
+	// counterInit and counterTick echo the sum of all inputs back to every player
+	counterInit := func(
+		ctx context.Context, g *game.Game, playerIDs []string,
+	) (out map[string][]messages.GameMessage, err error) {
+		g.Data = 0
+		out = map[string][]messages.GameMessage{}
+		for _, playerID := range playerIDs {
+			out[playerID] = []messages.GameMessage{{Code: 100, Data: "start"}}
+		}
+		return
+	}
+	counterTick := func(
+		ctx context.Context, g *game.Game, in game.Inputs,
+	) (complete bool, out map[string][]messages.GameMessage, err error) {
+		sum := g.Data.(int)
+		for _, msg := range in.Messages() {
+			sum += int(msg.Data.(float64))
+		}
+		g.Data = sum
+		out = map[string][]messages.GameMessage{
+			p1_id: {{Code: 101, Data: sum}},
+			p2_id: {{Code: 101, Data: sum}},
+		}
+		complete = g.CurrentTick() == 3
+		return
+	}
+
+	// record plays a game through the recorder the same way Game.Run does
+	record := func(t *testing.T, gameTick game.GameTick) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		g := game.NewGame(logger, 2)
+		defer g.Cancel()
+
+		recorder, err := NewStreamRecorder(g.ID, buf)
+		require.NoError(t, err)
+		g.Recorder = recorder
+
+		_, err = g.Init(counterInit, []string{p1_id, p2_id})
+		require.NoError(t, err)
+		// Messages the game sent outside of GameTick are recorded between the ticks, replays skip them
+		require.NoError(t, recorder.RecordSent(0, time.Now(), map[string][]messages.GameMessage{p1_id: {{Code: 1}}}))
+		for _, in := range []game.Inputs{
+			{Players: map[string][]game.FrameInputs{
+				p1_id: {{Frame: 1, Messages: []game.Input{{GameMessage: messages.GameMessage{Code: 200, Frame: 1, Data: float64(1)}}}}},
+			}},
+			{},
+			{Players: map[string][]game.FrameInputs{
+				p1_id: {{Frame: 2, Messages: []game.Input{{GameMessage: messages.GameMessage{Code: 200, Frame: 2, Data: float64(2)}}}}},
+				p2_id: {{Messages: []game.Input{{GameMessage: messages.GameMessage{Code: 200, Data: float64(3)}}}}},
+			}},
+		} {
+			_, _, err = g.Tick(gameTick, in)
+			require.NoError(t, err)
+		}
+		require.NoError(t, recorder.Close())
+		return buf
+	}
+
+	t.Run("replay matches recording", func(t *testing.T) {
+		buf := record(t, counterTick)
+
+		result, err := Replay(logger, buf, counterInit, counterTick, nil)
+		require.NoError(t, err)
+		require.False(t, result.Diverged)
+		require.Equal(t, uint64(3), result.Ticks)
+	})
+
+	t.Run("replay diverges", func(t *testing.T) {
+		buf := record(t, counterTick)
+
+		// A tick function that behaves differently from the second tick onwards
+		changedTick := func(
+			ctx context.Context, g *game.Game, in game.Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			complete, out, err = counterTick(ctx, g, in)
+			if g.CurrentTick() >= 2 {
+				out[p2_id] = nil
+			}
+			return
+		}
+
+		result, err := Replay(logger, buf, counterInit, changedTick, nil)
+		require.NoError(t, err)
+		require.True(t, result.Diverged)
+		require.Equal(t, uint64(2), result.DivergedTick)
+	})
+
+	t.Run("decoders are applied to recorded inputs", func(t *testing.T) {
+		type move struct {
+			X int `json:"x"`
+		}
+		decoders := map[int]game.Decoder{200: game.JSONDecoder(move{})}
+		moveTick := func(
+			ctx context.Context, g *game.Game, in game.Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			for _, input := range in.Player(p1_id) {
+				out = map[string][]messages.GameMessage{
+					p1_id: {{Code: 101, Data: input.Data.(move).X}},
+				}
+			}
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		g := game.NewGame(logger, 1)
+		defer g.Cancel()
+		recorder, err := NewStreamRecorder(g.ID, buf)
+		require.NoError(t, err)
+		g.Recorder = recorder
+		_, err = g.Init(counterInit, []string{p1_id})
+		require.NoError(t, err)
+		_, _, err = g.Tick(moveTick, game.Inputs{Players: map[string][]game.FrameInputs{
+			p1_id: {{Messages: []game.Input{{GameMessage: messages.GameMessage{Code: 200, Data: move{X: 3}}, PlayerID: p1_id}}}},
+		}})
+		require.NoError(t, err)
+		require.NoError(t, recorder.Close())
+
+		result, err := Replay(logger, buf, counterInit, moveTick, decoders)
+		require.NoError(t, err)
+		require.False(t, result.Diverged)
+		require.Equal(t, uint64(1), result.Ticks)
+	})
+
+	t.Run("replay restores steps, lockstep turns and input data", func(t *testing.T) {
+		// positionTick advances by the step's delta, the length of p1's raw inputs and p1's lockstep input
+		positionTick := func(
+			ctx context.Context, g *game.Game, in game.Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			position := g.Data.(int)
+			position += int(g.Step().Delta / time.Millisecond)
+			for _, input := range in.Player(p1_id) {
+				position += len(input.Data.([]byte))
+			}
+			if move, ok := g.Turn().Inputs[p1_id].(int64); ok {
+				position += int(move)
+			}
+			g.Data = position
+			out = map[string][]messages.GameMessage{
+				p1_id: {{Code: 101, Data: position}},
+			}
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		g := game.NewGame(logger, 1)
+		defer g.Cancel()
+		recorder, err := NewStreamRecorder(g.ID, buf)
+		require.NoError(t, err)
+		g.Recorder = recorder
+		_, err = g.Init(counterInit, []string{p1_id})
+		require.NoError(t, err)
+		for i, delta := range []time.Duration{16 * time.Millisecond, 33 * time.Millisecond, 50 * time.Millisecond} {
+			tick := uint64(i + 1)
+			g.SetStep(
+				game.Step{Tick: tick, Delta: delta},
+				messages.LockstepTurn{Turn: tick, Inputs: map[string]interface{}{p1_id: int64(i), p2_id: nil}, Missing: []string{p2_id}},
+			)
+			_, _, err = g.Tick(positionTick, game.Inputs{Players: map[string][]game.FrameInputs{
+				p1_id: {{Frame: tick, Messages: []game.Input{{GameMessage: messages.GameMessage{Code: 200, Frame: tick, Data: []byte("abc")}, PlayerID: p1_id}}}},
+			}})
+			require.NoError(t, err)
+		}
+		require.NoError(t, recorder.Close())
+
+		result, err := Replay(logger, buf, counterInit, positionTick, nil)
+		require.NoError(t, err)
+		require.False(t, result.Diverged)
+		require.Equal(t, uint64(3), result.Ticks)
+	})
+
+	t.Run("replay restores the game's teams", func(t *testing.T) {
+		// teamInit tells each player their team, which only matchmade games have
+		teamInit := func(
+			ctx context.Context, g *game.Game, playerIDs []string,
+		) (out map[string][]messages.GameMessage, err error) {
+			g.Data = 0
+			out = map[string][]messages.GameMessage{}
+			for team, teamPlayerIDs := range g.Teams {
+				for _, playerID := range teamPlayerIDs {
+					out[playerID] = []messages.GameMessage{{Code: 100, Data: fmt.Sprintf("%s %s team %d", g.GameType, g.MatchID, team)}}
+				}
+			}
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		g := game.NewGame(logger, 2)
+		defer g.Cancel()
+		g.ApplySetup(game.Setup{GameType: "duel", MatchID: "m1", Teams: [][]string{{p1_id}, {p2_id}}})
+		recorder, err := NewStreamRecorder(g.ID, buf)
+		require.NoError(t, err)
+		g.Recorder = recorder
+		out, err := g.Init(teamInit, []string{p1_id, p2_id})
+		require.NoError(t, err)
+		require.Equal(t, "duel m1 team 1", out[p2_id][0].Data)
+		_, _, err = g.Tick(counterTick, game.Inputs{})
+		require.NoError(t, err)
+		require.NoError(t, recorder.Close())
+
+		result, err := Replay(logger, buf, teamInit, counterTick, nil)
+		require.NoError(t, err)
+		require.False(t, result.Diverged)
+		require.Equal(t, uint64(1), result.Ticks)
+	})
+
+	t.Run("recorded ticks have timestamps", func(t *testing.T) {
+		before := time.Now().UnixNano()
+		buf := record(t, counterTick)
+
+		decoder := json.NewDecoder(buf)
+		var e Event
+		require.NoError(t, decoder.Decode(&e))
+		require.Equal(t, EVENT_HEADER, e.Type)
+		for decoder.More() {
+			e = Event{}
+			require.NoError(t, decoder.Decode(&e))
+			require.GreaterOrEqual(t, e.Time, before)
+		}
+		require.Equal(t, EVENT_TICK, e.Type)
+		require.Equal(t, uint64(3), e.Tick)
+	})
+}
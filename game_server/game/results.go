@@ -0,0 +1,67 @@
+package game_instance
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+)
+
+// Reasons a game ended, reported in its result
+const (
+	END_REASON_COMPLETED         = common.GAME_END_REASON_COMPLETED
+	END_REASON_TIMED_OUT_WAITING = common.GAME_END_REASON_TIMED_OUT_WAITING
+	END_REASON_ENDED             = common.GAME_END_REASON_ENDED
+	END_REASON_ERROR             = common.GAME_END_REASON_ERROR
+)
+
+// ResultReporter can optionally be implemented by Game.Data
+//
+// When implemented, Result is called once the game loop has stopped and the winners, player scores and stats
+// it returns are included in the game's result. A non empty EndReason replaces END_REASON_COMPLETED
+// for games that ran to completion, such as "forfeit". The other fields are filled in by the server
+type ResultReporter interface {
+	Result() common.GameResult
+}
+
+// buildResult returns the result of the game given the error it ended with
+func (g *Game) buildResult(err error) (result common.GameResult) {
+	if reporter, ok := g.Data.(ResultReporter); ok && g.Started() {
+		result = reporter.Result()
+	}
+	if err != nil || result.EndReason == "" {
+		result.EndReason = EndReason(err)
+	}
+	if g.endDetail != "" {
+		result.EndDetail = g.endDetail
+	}
+	result.GameID = g.ID
+	result.GameType = g.GameType
+	result.MatchID = g.MatchID
+	if len(result.Teams) == 0 {
+		result.Teams = g.Teams
+	}
+	result.PlayerIDs = append([]string(nil), g.playerIDs...)
+	sort.Strings(result.PlayerIDs)
+	result.Ticks = g.CurrentTick()
+	result.StartedAt = g.startedAt
+	result.EndedAt = time.Now()
+	return
+}
+
+// EndReason maps the error a game ended with to one of the END_REASON constants
+func EndReason(err error) string {
+	switch {
+	case err == nil:
+		return END_REASON_COMPLETED
+	case errors.Is(err, sgs_errors.ErrGameTimedOutWaitingForPlayers):
+		return END_REASON_TIMED_OUT_WAITING
+	case errors.Is(err, context.Canceled):
+		return END_REASON_ENDED
+	default:
+		return END_REASON_ERROR
+	}
+}
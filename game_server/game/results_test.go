@@ -0,0 +1,108 @@
+package game_instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gunnermanx/simplegameserver/common"
+	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	mocks "github.com/gunnermanx/simplegameserver/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type testResultReporter struct {
+	winner string
+}
+
+func (r *testResultReporter) Result() common.GameResult {
+	return common.GameResult{
+		Winners: []string{r.winner},
+		Players: map[string]common.PlayerResult{
+			r.winner: {Score: 10, Stats: map[string]float64{"kills": 3}},
+		},
+	}
+}
+
+func TestResults(t *testing.T) {
+	p1_id := "p1_id"
+	logger := logrus.New()
+
+	t.Run("completed games report the game's result", func(t *testing.T) {
+		g := NewGame(logger, 1)
+		g.GameType = "duel"
+		g.MatchID = "match_id"
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
+		playerCtx, playerCancel := context.WithCancel(context.Background())
+		defer playerCancel()
+		mockPlayer.EXPECT().GetContext().Return(playerCtx).AnyTimes()
+		mockPlayer.EXPECT().Read().DoAndReturn(func() (messages.GameMessage, error) {
+			<-playerCtx.Done()
+			return messages.GameMessage{}, playerCtx.Err()
+		}).AnyTimes()
+
+		gameInit := func(
+			ctx context.Context, g *Game, playerIDs []string,
+		) (out map[string][]messages.GameMessage, err error) {
+			g.Data = &testResultReporter{winner: playerIDs[0]}
+			return
+		}
+		gameTick := func(
+			ctx context.Context, g *Game, in Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			complete = g.CurrentTick() == 3
+			return
+		}
+
+		go g.AddPlayer(mockPlayer)
+
+		var result common.GameResult
+		var runErr error
+		g.Run(gameInit, gameTick, 10, 5, func(r common.GameResult, err error) {
+			result, runErr = r, err
+		})
+
+		require.NoError(t, runErr)
+		require.Equal(t, g.ID, result.GameID)
+		require.Equal(t, "duel", result.GameType)
+		require.Equal(t, "match_id", result.MatchID)
+		require.Equal(t, END_REASON_COMPLETED, result.EndReason)
+		require.Equal(t, []string{p1_id}, result.PlayerIDs)
+		require.Equal(t, []string{p1_id}, result.Winners)
+		require.Equal(t, 10.0, result.Players[p1_id].Score)
+		require.Equal(t, uint64(3), result.Ticks)
+		require.False(t, result.StartedAt.IsZero())
+		require.True(t, result.EndedAt.After(result.StartedAt))
+	})
+
+	t.Run("games that fail report the end reason", func(t *testing.T) {
+		g := NewGame(logger, 1)
+		g.Data = &testResultReporter{winner: p1_id}
+		g.Cancel()
+
+		var result common.GameResult
+		var runErr error
+		g.Run(nil, nil, 10, 5, func(r common.GameResult, err error) {
+			result, runErr = r, err
+		})
+
+		require.ErrorIs(t, runErr, context.Canceled)
+		require.Equal(t, END_REASON_ENDED, result.EndReason)
+		require.Empty(t, result.Winners)
+		require.Empty(t, result.PlayerIDs)
+	})
+
+	t.Run("end reasons", func(t *testing.T) {
+		require.Equal(t, END_REASON_COMPLETED, EndReason(nil))
+		require.Equal(t, END_REASON_TIMED_OUT_WAITING, EndReason(sgs_errors.ErrGameTimedOutWaitingForPlayers))
+		require.Equal(t, END_REASON_ENDED, EndReason(fmt.Errorf("wrapped: %w", context.Canceled)))
+		require.Equal(t, END_REASON_ERROR, EndReason(fmt.Errorf("some error in gametick")))
+	})
+}
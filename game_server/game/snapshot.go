@@ -0,0 +1,123 @@
+package game_instance
+
+import (
+	"sync/atomic"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+)
+
+// StateSnapshotter can optionally be implemented by Game.Data
+//
+// When implemented, the server sends a full snapshot of the game state to players when the game starts
+// or when they join a running game, and a delta after every tick. Clients that detect a gap in tick
+// numbers can send a RESYNC_REQUEST message to receive the state again
+type StateSnapshotter interface {
+	// Snapshot returns the full game state
+	Snapshot() interface{}
+	// Delta returns the changes to the game state since the given tick
+	// ok should be false if the delta cannot be produced, in which case a full snapshot is sent instead
+	Delta(sinceTick uint64) (delta interface{}, ok bool)
+}
+
+// resyncRequest is a request from a player to receive the game state since a given tick
+// sinceTick of 0 requests a full snapshot
+// Spectator requests always receive a full snapshot through the spectator stream
+type resyncRequest struct {
+	playerID  string
+	sinceTick uint64
+	spectator bool
+}
+
+// CurrentTick returns the number of ticks the game has run
+func (g *Game) CurrentTick() uint64 {
+	return atomic.LoadUint64(&g.tick)
+}
+
+// Started returns true once the game has been initialized and its game loop is running
+func (g *Game) Started() bool {
+	return atomic.LoadInt32(&g.started) == 1
+}
+
+func (g *Game) snapshotter() (s StateSnapshotter, ok bool) {
+	s, ok = g.Data.(StateSnapshotter)
+	return
+}
+
+// requestResync queues a resync for the player to be handled on the game loop
+// Requests made before the game loop has started are dropped since the initial snapshot covers them
+func (g *Game) requestResync(playerID string, sinceTick uint64) {
+	if !g.Started() {
+		return
+	}
+	select {
+	case g.resyncRequests <- resyncRequest{playerID: playerID, sinceTick: sinceTick}:
+	case <-g.Context.Done():
+	}
+}
+
+// sendSnapshotToPlayers sends the full game state to all players in the game
+func (g *Game) sendSnapshotToPlayers() {
+	var s StateSnapshotter
+	var ok bool
+	if s, ok = g.snapshotter(); !ok {
+		return
+	}
+	g.sendMessagesToPlayers(g.broadcast(messages.NewStateSnapshotMessage(s.Snapshot())))
+}
+
+// sendDeltaToPlayers sends the changes to the game state during the last tick to all players in the game
+func (g *Game) sendDeltaToPlayers() {
+	var s StateSnapshotter
+	var ok bool
+	if s, ok = g.snapshotter(); !ok {
+		return
+	}
+	sinceTick := g.CurrentTick() - 1
+	var msg messages.GameMessage
+	if delta, ok := s.Delta(sinceTick); ok {
+		msg = messages.NewStateDeltaMessage(sinceTick, delta)
+	} else {
+		msg = messages.NewStateSnapshotMessage(s.Snapshot())
+	}
+	g.sendMessagesToPlayers(g.broadcast(msg))
+}
+
+// handleResync sends the game state a player is missing, falling back to a full snapshot
+func (g *Game) handleResync(req resyncRequest) {
+	var s StateSnapshotter
+	var ok bool
+	if s, ok = g.snapshotter(); !ok {
+		return
+	}
+
+	if req.spectator {
+		g.queueSpectatorSnapshot(req.playerID, messages.NewStateSnapshotMessage(s.Snapshot()))
+		return
+	}
+
+	var msg messages.GameMessage
+	var delta interface{}
+	if req.sinceTick == 0 {
+		msg = messages.NewStateSnapshotMessage(s.Snapshot())
+	} else if delta, ok = s.Delta(req.sinceTick); ok {
+		msg = messages.NewStateDeltaMessage(req.sinceTick, delta)
+	} else {
+		msg = messages.NewStateSnapshotMessage(s.Snapshot())
+	}
+
+	g.sendMessagesToPlayers(map[string][]messages.GameMessage{
+		req.playerID: {msg},
+	})
+}
+
+// broadcast builds an outbound message map that sends msg to every player and spectator in the game
+func (g *Game) broadcast(msg messages.GameMessage) (out map[string][]messages.GameMessage) {
+	out = make(map[string][]messages.GameMessage)
+	g.PlayersMutex.RLock()
+	for playerID := range g.Players {
+		out[playerID] = []messages.GameMessage{msg}
+	}
+	g.PlayersMutex.RUnlock()
+	out[SPECTATORS] = []messages.GameMessage{msg}
+	return
+}
@@ -0,0 +1,255 @@
+package game_instance
+
+import (
+	"sync"
+	"time"
+
+	errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	player "github.com/gunnermanx/simplegameserver/game_server/game/player"
+	"github.com/sirupsen/logrus"
+)
+
+// SPECTATORS can be used as a key in the outbound message map returned by GameInit and GameTick
+// to send messages to every spectator of the game
+const (
+	SPECTATORS = "*spectators"
+)
+
+// spectator is a connection watching the game, it does not occupy a seat in the game
+// inactive spectators are waiting for their initial snapshot before receiving the broadcast stream
+type spectator struct {
+	player player.GamePlayer
+	active bool
+}
+
+// spectatorMessage is a message in the spectator broadcast stream
+// If to is set, the message is only sent to those spectators and activates them
+type spectatorMessage struct {
+	releaseAt time.Time
+	msg       messages.GameMessage
+	to        []string
+}
+
+// spectatorStream holds messages for spectators until their delay has passed
+type spectatorStream struct {
+	mutex  sync.Mutex
+	queue  []spectatorMessage
+	notify chan struct{}
+}
+
+// AddSpectator adds a spectator to the game
+//
+// Spectators receive the broadcast stream of the game, delayed by SpectatorDelay.
+// Messages sent by spectators are read and discarded, they never reach GameTick
+func (g *Game) AddSpectator(p player.GamePlayer) (err error) {
+	s := &spectator{
+		player: p,
+	}
+	// Spectators joining a running game wait for a snapshot before receiving deltas
+	_, hasSnapshots := g.snapshotter()
+	needsSnapshot := g.Started() && hasSnapshots
+	s.active = !needsSnapshot
+
+	g.spectatorsMutex.Lock()
+	var replaced player.GamePlayer
+	if existing, exists := g.spectators[p.GetID()]; exists {
+		// The same spectator opened a new connection, replace the old one
+		replaced = existing.player
+	} else if g.MaxSpectators > 0 && len(g.spectators) >= g.MaxSpectators {
+		g.spectatorsMutex.Unlock()
+		err = errors.ErrGameSpectatorsFull
+		return
+	}
+	g.spectators[p.GetID()] = s
+	g.spectatorsMutex.Unlock()
+
+	// Closing can block on the close handshake, so it isn't done while holding the lock the broadcast needs
+	if replaced != nil {
+		replaced.CloseConnection()
+	}
+
+	go g.listenToSpectator(p)
+
+	if needsSnapshot {
+		g.requestSpectatorSnapshot(p.GetID())
+	}
+
+	g.Logger.WithField(
+		"spectatorID", p.GetID(),
+	).Info("spectator added to game")
+	return
+}
+
+// RemoveSpectator removes a spectator from the game
+func (g *Game) RemoveSpectator(p player.GamePlayer) {
+	g.spectatorsMutex.Lock()
+	if s, exists := g.spectators[p.GetID()]; !exists || s.player != p {
+		g.spectatorsMutex.Unlock()
+		return
+	}
+	delete(g.spectators, p.GetID())
+	g.spectatorsMutex.Unlock()
+
+	p.CloseConnection()
+
+	g.Logger.WithField(
+		"spectatorID", p.GetID(),
+	).Info("spectator removed from game")
+}
+
+// NumSpectators returns the number of spectators watching the game
+func (g *Game) NumSpectators() int {
+	g.spectatorsMutex.RLock()
+	defer g.spectatorsMutex.RUnlock()
+	return len(g.spectators)
+}
+
+// listenToSpectator drains messages sent by a spectator until their connection closes
+func (g *Game) listenToSpectator(p player.GamePlayer) {
+	var err error
+readLoop:
+	for {
+		select {
+		case <-p.GetContext().Done():
+			break readLoop
+		default:
+			// Spectators can't affect the game, anything they send is ignored
+			if _, err = p.Read(); err != nil {
+				g.Logger.WithFields(logrus.Fields{
+					"spectatorID": p.GetID(),
+					"error":       err.Error(),
+				}).Debug("stopped reading messages from spectator")
+				break readLoop
+			}
+		}
+	}
+	g.RemoveSpectator(p)
+}
+
+// requestSpectatorSnapshot queues a snapshot for a spectator to be taken on the game loop
+func (g *Game) requestSpectatorSnapshot(spectatorID string) {
+	select {
+	case g.resyncRequests <- resyncRequest{playerID: spectatorID, spectator: true}:
+	case <-g.Context.Done():
+	}
+}
+
+// queueSpectatorMessages adds messages to the broadcast stream for all active spectators
+func (g *Game) queueSpectatorMessages(msgs ...messages.GameMessage) {
+	if len(msgs) == 0 || g.NumSpectators() == 0 {
+		return
+	}
+	releaseAt := time.Now().Add(g.SpectatorDelay)
+	tick := g.CurrentTick()
+
+	g.spectatorStream.mutex.Lock()
+	for _, msg := range msgs {
+		msg.Tick = tick
+		g.spectatorStream.queue = append(g.spectatorStream.queue, spectatorMessage{
+			releaseAt: releaseAt,
+			msg:       msg,
+		})
+	}
+	g.spectatorStream.mutex.Unlock()
+	g.notifySpectatorStream()
+}
+
+// queueSpectatorSnapshot adds a snapshot to the broadcast stream for a spectator waiting to be activated
+func (g *Game) queueSpectatorSnapshot(spectatorID string, msg messages.GameMessage) {
+	msg.Tick = g.CurrentTick()
+
+	g.spectatorStream.mutex.Lock()
+	g.spectatorStream.queue = append(g.spectatorStream.queue, spectatorMessage{
+		releaseAt: time.Now().Add(g.SpectatorDelay),
+		msg:       msg,
+		to:        []string{spectatorID},
+	})
+	g.spectatorStream.mutex.Unlock()
+	g.notifySpectatorStream()
+}
+
+func (g *Game) notifySpectatorStream() {
+	select {
+	case g.spectatorStream.notify <- struct{}{}:
+	default:
+	}
+}
+
+// relayToSpectators writes the broadcast stream to spectators as messages are released
+//
+// Once the game ends, the remaining messages are relayed without waiting out their delay before spectators are disconnected
+func (g *Game) relayToSpectators() {
+	for {
+		g.spectatorStream.mutex.Lock()
+		var next *spectatorMessage
+		if len(g.spectatorStream.queue) > 0 {
+			next = &g.spectatorStream.queue[0]
+		}
+		g.spectatorStream.mutex.Unlock()
+
+		if next == nil {
+			if g.Context.Err() != nil {
+				break
+			}
+			select {
+			case <-g.spectatorStream.notify:
+			case <-g.Context.Done():
+			}
+			continue
+		}
+
+		// Messages are queued in release order, so nothing else can be sent before this one
+		if wait := time.Until(next.releaseAt); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-g.Context.Done():
+			}
+		}
+
+		g.spectatorStream.mutex.Lock()
+		released := g.spectatorStream.queue[0]
+		g.spectatorStream.queue = g.spectatorStream.queue[1:]
+		g.spectatorStream.mutex.Unlock()
+
+		g.writeToSpectators(released)
+	}
+
+	g.spectatorsMutex.Lock()
+	spectators := g.spectators
+	g.spectators = make(map[string]*spectator)
+	g.spectatorsMutex.Unlock()
+	for _, s := range spectators {
+		s.player.CloseConnection()
+	}
+}
+
+func (g *Game) writeToSpectators(sm spectatorMessage) {
+	var targets []player.GamePlayer
+	g.spectatorsMutex.Lock()
+	if sm.to != nil {
+		for _, id := range sm.to {
+			if s, exists := g.spectators[id]; exists {
+				s.active = true
+				targets = append(targets, s.player)
+			}
+		}
+	} else {
+		for _, s := range g.spectators {
+			if s.active {
+				targets = append(targets, s.player)
+			}
+		}
+	}
+	g.spectatorsMutex.Unlock()
+
+	for _, p := range targets {
+		if err := p.Write(sm.msg); err != nil {
+			g.Logger.WithFields(logrus.Fields{
+				"spectatorID": p.GetID(),
+				"error":       err.Error(),
+			}).Warn("failed writing message to spectator")
+			g.RemoveSpectator(p)
+		}
+	}
+}
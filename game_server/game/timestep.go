@@ -0,0 +1,214 @@
+package game_instance
+
+import (
+	"sync"
+	"time"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+)
+
+// LoopMode decides how the game loop schedules ticks
+type LoopMode int
+
+const (
+	// LOOP_MODE_TICKER runs a tick whenever the tick interval elapses,
+	// ticks that would have run while a slow tick was running are dropped and Step.Delta grows to cover them
+	LOOP_MODE_TICKER LoopMode = iota
+	// LOOP_MODE_FIXED_TIMESTEP advances the game in fixed steps of the tick interval,
+	// after a slow tick the missed ticks are run back to back, up to MaxCatchUpTicks
+	LOOP_MODE_FIXED_TIMESTEP
+	// LOOP_MODE_LOCKSTEP broadcasts every player's input for a turn once they are all in,
+	// for deterministic games simulated by the clients. See LockstepConfig
+	LOOP_MODE_LOCKSTEP
+)
+
+// Step describes the tick being run, GameTick can read it using Game.Step
+type Step struct {
+	// Tick is the tick number, starting from 1
+	Tick uint64
+	// Delta is the simulation time the tick advances the game by
+	Delta time.Duration
+	// Time is the total simulation time of the game once the tick completes
+	Time time.Duration
+	// CatchUp is true if the tick is run at least a tick interval after it was due, to catch up after a slow tick
+	CatchUp bool
+}
+
+// TickStats summarize how long a game's ticks are taking
+type TickStats struct {
+	Ticks uint64 `json:"ticks"`
+	// Overruns are ticks that took longer than the tick interval
+	Overruns uint64 `json:"overruns"`
+	// CatchUps are ticks run late to catch up after a slow tick
+	CatchUps uint64 `json:"catchUps"`
+	// Skipped are ticks that were never run because the game fell too far behind
+	Skipped uint64        `json:"skipped"`
+	Last    time.Duration `json:"lastNS"`
+	Mean    time.Duration `json:"meanNS"`
+	Max     time.Duration `json:"maxNS"`
+}
+
+// tickStats is updated on the game loop and read by other goroutines
+type tickStats struct {
+	mutex sync.Mutex
+	stats TickStats
+	total time.Duration
+}
+
+// Step returns the tick currently being run, or the last tick run if called outside of GameTick
+func (g *Game) Step() Step {
+	return g.step
+}
+
+// SetStep sets the step and lockstep turn GameTick sees on the next call to Tick,
+// replays use it to run recorded ticks the way they were originally run
+func (g *Game) SetStep(step Step, turn messages.LockstepTurn) {
+	g.step = step
+	g.turn = turn
+}
+
+// TickStats returns the game's tick timings so far
+func (g *Game) TickStats() TickStats {
+	g.tickStats.mutex.Lock()
+	defer g.tickStats.mutex.Unlock()
+	return g.tickStats.stats
+}
+
+// recordTick updates the tick stats and metrics, and logs the tick if it overran the tick interval
+func (g *Game) recordTick(step Step, took time.Duration, interval time.Duration) {
+	overran := took > interval
+
+	g.tickStats.mutex.Lock()
+	s := &g.tickStats.stats
+	s.Ticks++
+	s.Last = took
+	g.tickStats.total += took
+	s.Mean = g.tickStats.total / time.Duration(s.Ticks)
+	if took > s.Max {
+		s.Max = took
+	}
+	if overran {
+		s.Overruns++
+	}
+	if step.CatchUp {
+		s.CatchUps++
+	}
+	g.tickStats.mutex.Unlock()
+
+	if overran {
+		g.Logger.WithFields(logrus.Fields{
+			"tick":     step.Tick,
+			"took":     took.String(),
+			"interval": interval.String(),
+		}).Warn("game tick overran the tick interval")
+	}
+	g.observeTick(took, interval)
+}
+
+// recordSkipped counts ticks that were dropped because the game fell behind
+func (g *Game) recordSkipped(skipped uint64) {
+	if skipped == 0 {
+		return
+	}
+	g.tickStats.mutex.Lock()
+	g.tickStats.stats.Skipped += skipped
+	g.tickStats.mutex.Unlock()
+
+	g.Logger.WithFields(logrus.Fields{
+		"tick":    g.CurrentTick(),
+		"skipped": skipped,
+	}).Warn("game loop fell behind, skipped ticks")
+	g.observeSkipped(skipped)
+}
+
+// tickClock decides when ticks are due and which steps to run for the game's loop mode
+type tickClock struct {
+	mode       LoopMode
+	interval   time.Duration
+	maxCatchUp int
+
+	ticker *time.Ticker
+	timer  *time.Timer
+	// last is when the previous tick was run, used by LOOP_MODE_TICKER
+	last time.Time
+	// next is when the next tick is due, used by LOOP_MODE_FIXED_TIMESTEP
+	next time.Time
+
+	simTime time.Duration
+}
+
+func newTickClock(mode LoopMode, interval time.Duration, maxCatchUp int, now time.Time) (c *tickClock) {
+	c = &tickClock{
+		mode:       mode,
+		interval:   interval,
+		maxCatchUp: maxCatchUp,
+		last:       now,
+		next:       now.Add(interval),
+	}
+	if mode == LOOP_MODE_FIXED_TIMESTEP {
+		c.timer = time.NewTimer(interval)
+	} else {
+		c.ticker = time.NewTicker(interval)
+	}
+	return
+}
+
+// C fires when ticks are due
+func (c *tickClock) C() <-chan time.Time {
+	if c.timer != nil {
+		return c.timer.C
+	}
+	return c.ticker.C
+}
+
+// due returns the steps to run now, and the number of ticks that were dropped
+func (c *tickClock) due(now time.Time, lastTick uint64) (steps []Step, skipped uint64) {
+	if c.mode != LOOP_MODE_FIXED_TIMESTEP {
+		delta := now.Sub(c.last)
+		c.last = now
+		if missed := delta/c.interval - 1; missed > 0 {
+			skipped = uint64(missed)
+		}
+		c.simTime += delta
+		steps = []Step{{Tick: lastTick + 1, Delta: delta, Time: c.simTime}}
+		return
+	}
+
+	n := 1
+	if behind := now.Sub(c.next); behind > 0 {
+		n += int(behind / c.interval)
+	}
+	if catchUp := n - 1; catchUp > c.maxCatchUp {
+		skipped = uint64(catchUp - c.maxCatchUp)
+		n = 1 + c.maxCatchUp
+		c.next = c.next.Add(time.Duration(skipped) * c.interval)
+	}
+	for i := 0; i < n; i++ {
+		c.simTime += c.interval
+		dueAt := c.next.Add(time.Duration(i) * c.interval)
+		steps = append(steps, Step{
+			Tick:    lastTick + uint64(i) + 1,
+			Delta:   c.interval,
+			Time:    c.simTime,
+			CatchUp: now.Sub(dueAt) >= c.interval,
+		})
+	}
+	c.next = c.next.Add(time.Duration(n) * c.interval)
+	return
+}
+
+// rearm schedules the next tick once the due steps have run
+func (c *tickClock) rearm(now time.Time) {
+	if c.timer != nil {
+		c.timer.Reset(c.next.Sub(now))
+	}
+}
+
+func (c *tickClock) stop() {
+	if c.timer != nil {
+		c.timer.Stop()
+	} else {
+		c.ticker.Stop()
+	}
+}
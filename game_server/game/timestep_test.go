@@ -0,0 +1,105 @@
+package game_instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTickClock(t *testing.T) {
+	start := time.Now()
+	interval := 10 * time.Millisecond
+
+	t.Run("ticker mode stretches the delta over dropped ticks", func(t *testing.T) {
+		c := newTickClock(LOOP_MODE_TICKER, interval, 0, start)
+		defer c.stop()
+
+		steps, skipped := c.due(start.Add(interval), 0)
+		require.Equal(t, []Step{{Tick: 1, Delta: interval, Time: interval}}, steps)
+		require.Zero(t, skipped)
+
+		steps, skipped = c.due(start.Add(35*time.Millisecond), 1)
+		require.Equal(t, []Step{{Tick: 2, Delta: 25 * time.Millisecond, Time: 35 * time.Millisecond}}, steps)
+		require.Equal(t, uint64(1), skipped)
+	})
+
+	t.Run("fixed timestep mode catches up to the cap", func(t *testing.T) {
+		c := newTickClock(LOOP_MODE_FIXED_TIMESTEP, interval, 2, start)
+		defer c.stop()
+
+		steps, skipped := c.due(start.Add(interval), 0)
+		require.Equal(t, []Step{{Tick: 1, Delta: interval, Time: interval}}, steps)
+		require.Zero(t, skipped)
+
+		// Ticks 2 to 5 are due, one is skipped to stay within the cap
+		steps, skipped = c.due(start.Add(55*time.Millisecond), 1)
+		require.Equal(t, []Step{
+			{Tick: 2, Delta: interval, Time: 20 * time.Millisecond, CatchUp: true},
+			{Tick: 3, Delta: interval, Time: 30 * time.Millisecond, CatchUp: true},
+			{Tick: 4, Delta: interval, Time: 40 * time.Millisecond},
+		}, steps)
+		require.Equal(t, uint64(1), skipped)
+		require.Equal(t, start.Add(60*time.Millisecond), c.next)
+	})
+
+	t.Run("fixed timestep mode without catching up", func(t *testing.T) {
+		c := newTickClock(LOOP_MODE_FIXED_TIMESTEP, interval, 0, start)
+		defer c.stop()
+
+		steps, skipped := c.due(start.Add(35*time.Millisecond), 0)
+		require.Equal(t, []Step{{Tick: 1, Delta: interval, Time: interval}}, steps)
+		require.Equal(t, uint64(2), skipped)
+		require.Equal(t, start.Add(40*time.Millisecond), c.next)
+	})
+}
+
+func TestFixedTimestep(t *testing.T) {
+	logger := logrus.New()
+	interval := 10 * time.Millisecond
+
+	g := NewGame(logger, 1)
+	g.LoopMode = LOOP_MODE_FIXED_TIMESTEP
+	g.MaxCatchUpTicks = 5
+
+	gameInit := func(
+		ctx context.Context, g *Game, playerIDs []string,
+	) (out map[string][]messages.GameMessage, err error) {
+		return
+	}
+	var steps []Step
+	gameTick := func(
+		ctx context.Context, g *Game, in Inputs,
+	) (complete bool, out map[string][]messages.GameMessage, err error) {
+		step := g.Step()
+		steps = append(steps, step)
+		// The first tick overruns, the following ticks catch up
+		if step.Tick == 1 {
+			time.Sleep(3 * interval)
+		}
+		complete = step.Tick == 5
+		return
+	}
+
+	go func() {
+		g.GameMessages <- messages.NewPlayerJoinedMessage("p1_id")
+	}()
+	g.Run(gameInit, gameTick, int(interval/time.Millisecond), 5, nil)
+
+	require.Len(t, steps, 5)
+	for i, step := range steps {
+		require.Equal(t, uint64(i+1), step.Tick)
+		require.Equal(t, interval, step.Delta)
+		require.Equal(t, time.Duration(i+1)*interval, step.Time)
+	}
+	require.True(t, steps[1].CatchUp)
+
+	stats := g.TickStats()
+	require.Equal(t, uint64(5), stats.Ticks)
+	require.GreaterOrEqual(t, stats.Overruns, uint64(1))
+	require.GreaterOrEqual(t, stats.CatchUps, uint64(2))
+	require.GreaterOrEqual(t, stats.Max, 3*interval)
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	codec "github.com/gunnermanx/simplegameserver/game_server/game/codec"
+)
+
+const (
+	// DEFAULT_GAME_TYPE is used when a create game request doesn't specify a game type,
+	// it is configured through WithGameInit and WithGameTick
+	DEFAULT_GAME_TYPE = "default"
+)
+
+// GameType bundles everything the server needs to host one game mode
+type GameType struct {
+	Name string
+	Init game.GameInit
+	Tick game.GameTick
+
+	// TickIntervalMS defaults to the server config if 0
+	TickIntervalMS int
+	// LoopMode decides how ticks are scheduled, defaults to game.LOOP_MODE_TICKER
+	LoopMode game.LoopMode
+	// MaxCatchUpTicks caps the missed ticks run back to back in game.LOOP_MODE_FIXED_TIMESTEP, 0 disables catching up
+	MaxCatchUpTicks int
+	// Lockstep configures game.LOOP_MODE_LOCKSTEP
+	Lockstep game.LockstepConfig
+	// MinPlayers and MaxPlayers bound the number of players a game can be created for, 0 is unbounded
+	MinPlayers int
+	MaxPlayers int
+	// WaitForPlayersTimeoutS is used when the create request doesn't set one,
+	// defaults to DEFAULT_WAIT_FOR_PLAYERS_TIMEOUT_S if 0
+	WaitForPlayersTimeoutS int
+	// Codecs are the wire formats players can negotiate, defaults to the server's codecs if empty.
+	// Games that do their own serialization add codec.RawCodec here
+	Codecs []codec.Codec
+	// Decoders convert the data of player messages into the game's types by message code, see game.JSONDecoder
+	Decoders map[int]game.Decoder
+}
+
+// RegisterGameType adds a game mode that can be created with /game/create using its name
+func (sgs *SimpleGameServer) RegisterGameType(gt GameType) (err error) {
+	if gt.Name == "" || gt.Init == nil || gt.Tick == nil {
+		err = sgs_errors.ErrGameTypeInvalid
+		return
+	}
+	if gt.MaxPlayers > 0 && gt.MinPlayers > gt.MaxPlayers {
+		err = sgs_errors.ErrGameTypeInvalid
+		return
+	}
+
+	sgs.gameTypesMutex.Lock()
+	defer sgs.gameTypesMutex.Unlock()
+	if _, exists := sgs.gameTypes[gt.Name]; exists {
+		err = sgs_errors.ErrGameTypeAlreadyRegistered
+		return
+	}
+	sgs.gameTypes[gt.Name] = &gt
+	return
+}
+
+// getGameType returns the registered game type with the given name, or the default game type if name is empty
+func (sgs *SimpleGameServer) getGameType(name string) (gt *GameType, err error) {
+	if name == "" {
+		name = DEFAULT_GAME_TYPE
+	}
+	var exists bool
+	sgs.gameTypesMutex.RLock()
+	gt, exists = sgs.gameTypes[name]
+	sgs.gameTypesMutex.RUnlock()
+	if !exists || gt.Init == nil || gt.Tick == nil {
+		gt = nil
+		err = sgs_errors.ErrGameTypeNotFound
+	}
+	return
+}
+
+// updateDefaultGameType is used by WithGameInit and WithGameTick to configure the default game type
+func (sgs *SimpleGameServer) updateDefaultGameType(update func(gt *GameType)) {
+	sgs.gameTypesMutex.Lock()
+	defer sgs.gameTypesMutex.Unlock()
+	gt, exists := sgs.gameTypes[DEFAULT_GAME_TYPE]
+	if !exists {
+		gt = &GameType{
+			Name: DEFAULT_GAME_TYPE,
+		}
+		sgs.gameTypes[DEFAULT_GAME_TYPE] = gt
+	}
+	update(gt)
+}
+
+// numPlayers validates the requested number of players against the game type's bounds
+// A request for 0 players uses MaxPlayers
+func (gt *GameType) numPlayers(requested int) (numPlayers int, err error) {
+	numPlayers = requested
+	if numPlayers == 0 {
+		numPlayers = gt.MaxPlayers
+	}
+	if numPlayers <= 0 ||
+		(gt.MinPlayers > 0 && numPlayers < gt.MinPlayers) ||
+		(gt.MaxPlayers > 0 && numPlayers > gt.MaxPlayers) {
+		err = sgs_errors.ErrGameInvalidNumPlayers
+	}
+	return
+}
+
+// codecsForGame returns the wire formats players of the game can negotiate
+func (sgs *SimpleGameServer) codecsForGame(g *game.Game) []codec.Codec {
+	if gt, err := sgs.getGameType(g.GameType); err == nil && len(gt.Codecs) > 0 {
+		return gt.Codecs
+	}
+	return sgs.codecs
+}
@@ -2,10 +2,14 @@ package game
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/gunnermanx/simplegameserver/auth"
 	"github.com/gunnermanx/simplegameserver/common"
+	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
 	game "github.com/gunnermanx/simplegameserver/game_server/game"
 	player "github.com/gunnermanx/simplegameserver/game_server/game/player"
 	"github.com/sirupsen/logrus"
@@ -16,9 +20,13 @@ const (
 )
 
 const (
-	CONNECT_PATH     = "/connect"
-	CREATE_GAME_PATH = "/game/create"
-	JOIN_GAME_PATH   = "/game/join"
+	HEALTH_PATH        = "/health"
+	CONNECT_PATH       = "/connect"
+	CREATE_GAME_PATH   = "/game/create"
+	JOIN_GAME_PATH     = "/game/join"
+	SPECTATE_GAME_PATH = "/game/spectate"
+	JOIN_TICKET_PATH   = "/game/ticket"
+	ALLOCATE_GAME_PATH = common.ALLOCATE_GAME_PATH
 )
 
 const (
@@ -33,23 +41,59 @@ func (sgs *SimpleGameServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), REQUEST_TIMEOUT_S*time.Second)
 	defer cancel()
 
+	// Unknown paths fall back to the player policy so they aren't served unauthenticated
+	policy := auth.PlayerPolicy
+	if _, pattern := sgs.serveMux.Handler(r); pattern != "" {
+		policy = sgs.routePolicies[pattern]
+	}
+
 	var err error
-	if ctx, err = sgs.authProvider.AuthenticateRequest(ctx, r); err != nil {
-		common.WriteErrorResponse(w, http.StatusUnauthorized, err.Error())
+	if ctx, err = policy.Authorize(ctx, r, sgs.authProvider, sgs.config.AllocationSecret); err != nil {
+		sgs.metrics.observeAuthFailure(err)
+		common.WriteErrorResponse(w, auth.StatusCode(err), err.Error())
 		return
 	}
 	sgs.serveMux.ServeHTTP(w, r.WithContext(ctx))
 }
 
 // RegisterHandler is used by custom game servers to register new http handlers for the given pattern
-func (sgs *SimpleGameServer) RegisterHandler(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+//
+// The policy decides who can reach the handler: public, authenticated players, players with a role,
+// or other servers presenting the shared server secret
+func (sgs *SimpleGameServer) RegisterHandler(pattern string, policy auth.Policy, handler func(http.ResponseWriter, *http.Request)) {
+	sgs.routePolicies[pattern] = policy
 	sgs.serveMux.HandleFunc(pattern, handler)
 }
 
 func (sgs *SimpleGameServer) setupHandlers() {
-	sgs.serveMux.HandleFunc(CONNECT_PATH, sgs.connectHandler)
-	sgs.serveMux.HandleFunc(CREATE_GAME_PATH, sgs.createGameHandler)
-	sgs.serveMux.HandleFunc(JOIN_GAME_PATH, sgs.joinGameHandler)
+	sgs.RegisterHandler(HEALTH_PATH, auth.PublicPolicy, sgs.healthHandler)
+	sgs.RegisterHandler(METRICS_PATH, auth.PublicPolicy, sgs.metrics.registry.Handler)
+	sgs.RegisterHandler(CONNECT_PATH, auth.PlayerPolicy, sgs.connectHandler)
+	sgs.RegisterHandler(CREATE_GAME_PATH, auth.PlayerPolicy, sgs.createGameHandler)
+	sgs.RegisterHandler(JOIN_GAME_PATH, auth.PlayerPolicy, sgs.joinGameHandler)
+	sgs.RegisterHandler(SPECTATE_GAME_PATH, auth.PlayerPolicy, sgs.spectateGameHandler)
+	sgs.RegisterHandler(JOIN_TICKET_PATH, auth.PlayerPolicy, sgs.joinTicketHandler)
+	sgs.RegisterHandler(ALLOCATE_GAME_PATH, auth.ServerPolicy, sgs.allocateGameHandler)
+	sgs.RegisterHandler(ADMIN_GAMES_PATH, auth.AdminPolicy, sgs.adminGamesHandler)
+	sgs.RegisterHandler(ADMIN_GAME_PATH, auth.AdminPolicy, sgs.adminGameHandler)
+	sgs.RegisterHandler(ADMIN_END_GAME_PATH, auth.AdminPolicy, sgs.adminEndGameHandler)
+	sgs.RegisterHandler(ADMIN_KICK_PLAYER_PATH, auth.AdminPolicy, sgs.adminKickPlayerHandler)
+	sgs.RegisterHandler(ADMIN_BROADCAST_PATH, auth.AdminPolicy, sgs.adminBroadcastHandler)
+	sgs.RegisterHandler(ADMIN_PLAYERS_PATH, auth.AdminPolicy, sgs.adminPlayersHandler)
+}
+
+// healthHandler lets load balancers and orchestrators check the server is up
+// A draining server reports itself as unavailable so no new players are routed to it
+func (sgs *SimpleGameServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if sgs.isDraining() {
+		common.WriteResponse(w, http.StatusServiceUnavailable, common.ResponseData{
+			"status": "draining",
+		})
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{
+		"status": "ok",
+	})
 }
 
 func (sgs *SimpleGameServer) connectHandler(w http.ResponseWriter, r *http.Request) {
@@ -70,32 +114,136 @@ func (sgs *SimpleGameServer) createGameHandler(w http.ResponseWriter, r *http.Re
 	var err error
 	var g *game.Game
 
+	var playerID string
+	if playerID, err = sgs.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	var statusCode int
 	var req CreateGameRequest
 	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
 		common.WriteErrorResponse(w, statusCode, err.Error())
 		return
 	}
-	if req.NumPlayers == 0 {
-		common.WriteErrorResponse(w, http.StatusBadRequest, "numPlayers field is missing or 0")
+
+	if g, err = sgs.createGame(req.GameType, req.NumPlayers, req.WaitForPlayersTimeout, nil, "", nil); err != nil {
+		switch {
+		case errors.Is(err, sgs_errors.ErrGameTypeNotFound):
+			common.WriteErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("%s: %q", err.Error(), req.GameType))
+		case errors.Is(err, sgs_errors.ErrGameInvalidNumPlayers):
+			common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sgs_errors.ErrServerFull), errors.Is(err, sgs_errors.ErrServerDraining):
+			common.WriteErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// Invited players request their own join tickets, so the creator can't mint tickets on their behalf
+	g.Invite(append([]string{playerID}, req.PlayerIDs...)...)
+
+	response := CreateGameResponse{
+		GameID: g.ID,
+	}
+	var tickets map[string]string
+	if tickets, err = sgs.mintJoinTickets(g.ID, []string{playerID}); err != nil {
+		g.Cancel()
+		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.JoinTicket = tickets[playerID]
+	common.WriteJSONResponse(w, http.StatusCreated, response)
+}
+
+// joinTicketHandler issues a join ticket to a custom game for a player invited by its creator
+func (sgs *SimpleGameServer) joinTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	var playerID string
+	if playerID, err = sgs.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	gameID := r.URL.Query().Get("id")
+	if gameID == "" {
+		common.WriteErrorResponse(w, http.StatusBadRequest, "missing or invalid id parameter")
+		return
+	}
+
+	if sgs.joinTickets == nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, sgs_errors.ErrJoinTicketsNotConfigured.Error())
+		return
+	}
+
+	var g *game.Game
+	if g, err = sgs.getGame(gameID); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
 		return
 	}
-	if req.WaitForPlayersTimeout == 0 {
-		req.WaitForPlayersTimeout = DEFAULT_WAIT_FOR_PLAYERS_TIMEOUT_S
+	if !g.Invited(playerID) {
+		common.WriteErrorResponse(w, http.StatusForbidden, sgs_errors.ErrGamePlayerNotInvited.Error())
+		return
 	}
 
-	if g, err = sgs.createGame(req.NumPlayers, req.WaitForPlayersTimeout); err != nil {
+	var tickets map[string]string
+	if tickets, err = sgs.mintJoinTickets(g.ID, []string{playerID}); err != nil {
 		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	common.WriteJSONResponse(w, http.StatusOK, JoinTicketResponse{
+		JoinTicket: tickets[playerID],
+	})
+}
 
-	common.WriteResponse(w, http.StatusCreated, common.ResponseData{
-		"gameID": g.ID,
+// allocateGameHandler creates a game for the players of a match formed by the matchmaking server
+func (sgs *SimpleGameServer) allocateGameHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var g *game.Game
+
+	var statusCode int
+	var req common.AllocateGameRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+	if len(req.PlayerIDs) == 0 {
+		common.WriteErrorResponse(w, http.StatusBadRequest, "playerIDs field is missing or empty")
+		return
+	}
+
+	if g, err = sgs.createGame(req.GameType, 0, req.WaitForPlayersTimeout, req.PlayerIDs, req.MatchID, req.Teams); err != nil {
+		switch {
+		case errors.Is(err, sgs_errors.ErrGameTypeNotFound):
+			common.WriteErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("%s: %q", err.Error(), req.GameType))
+		case errors.Is(err, sgs_errors.ErrGameInvalidNumPlayers), errors.Is(err, sgs_errors.ErrGameInvalidTeams):
+			common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sgs_errors.ErrServerFull), errors.Is(err, sgs_errors.ErrServerDraining):
+			common.WriteErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	sgs.logger.WithFields(logrus.Fields{
+		"matchID":   req.MatchID,
+		"gameID":    g.ID,
+		"playerIDs": req.PlayerIDs,
+		"teams":     req.Teams,
+	}).Info("allocated game for match")
+
+	common.WriteJSONResponse(w, http.StatusCreated, common.AllocateGameResponse{
+		GameID:  g.ID,
+		Address: sgs.config.PublicAddress,
 	})
 }
 
 func (sgs *SimpleGameServer) joinGameHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
+	start := time.Now()
 
 	var playerID string
 	if playerID, err = sgs.authProvider.GetUIDFromRequest(r); err != nil {
@@ -107,12 +255,37 @@ func (sgs *SimpleGameServer) joinGameHandler(w http.ResponseWriter, r *http.Requ
 	if gameID == "" {
 		// TODO, may need to log warn or info?
 		//wsconn.Close(WS_STATUS_INVALID_PARAMETERS, "missing or invalid id parameter")
-		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		common.WriteErrorResponse(w, http.StatusBadRequest, "missing or invalid id parameter")
+		return
+	}
+
+	// The game is needed before accepting the connection to negotiate its codec
+	var g *game.Game
+	if g, err = sgs.getGame(gameID); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// Only players reconnecting to their seats can join while draining
+	if sgs.isDraining() && !g.HoldsSeat(playerID) {
+		common.WriteErrorResponse(w, http.StatusServiceUnavailable, sgs_errors.ErrServerDraining.Error())
+		return
+	}
+
+	var releaseTicket func()
+	if releaseTicket, err = sgs.verifyJoinTicket(r, playerID, g); err != nil {
+		sgs.logger.WithFields(logrus.Fields{
+			"playerID": playerID,
+			"gameID":   gameID,
+			"error":    err.Error(),
+		}).Warn("rejected join ticket")
+		common.WriteJoinTicketErrorResponse(w, err)
 		return
 	}
 
 	var player *player.SGSGamePlayer
-	if player, err = sgs.createPlayer(playerID, w, r); err != nil {
+	if player, err = sgs.createPlayer(playerID, g, w, r); err != nil {
+		releaseTicket()
 		sgs.logger.WithFields(logrus.Fields{
 			"playerID": playerID,
 			"gameID":   gameID,
@@ -122,11 +295,62 @@ func (sgs *SimpleGameServer) joinGameHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	if err = sgs.joinGame(gameID, player); err != nil {
+		// The ticket is kept usable so the player can retry, e.g. once a seat frees up
+		releaseTicket()
 		sgs.logger.WithFields(logrus.Fields{
 			"playerID": playerID,
 			"gameID":   gameID,
 			"error":    err.Error(),
 		}).Error("failed to join game")
 		player.CloseConnectionWithError(err)
+		return
+	}
+	sgs.metrics.joinDuration.WithLabelValues(g.GameType).Observe(time.Since(start).Seconds())
+}
+
+func (sgs *SimpleGameServer) spectateGameHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	var spectatorID string
+	if spectatorID, err = sgs.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	gameID := r.URL.Query().Get("id")
+	if gameID == "" {
+		common.WriteErrorResponse(w, http.StatusBadRequest, "missing or invalid id parameter")
+		return
+	}
+
+	var g *game.Game
+	if g, err = sgs.getGame(gameID); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if sgs.isDraining() {
+		common.WriteErrorResponse(w, http.StatusServiceUnavailable, sgs_errors.ErrServerDraining.Error())
+		return
+	}
+
+	var spectator *player.SGSGamePlayer
+	if spectator, err = sgs.createPlayer(spectatorID, g, w, r); err != nil {
+		sgs.logger.WithFields(logrus.Fields{
+			"spectatorID": spectatorID,
+			"gameID":      gameID,
+			"error":       err.Error(),
+		}).Error("failed to create spectator")
+		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err = sgs.spectateGame(gameID, spectator); err != nil {
+		sgs.logger.WithFields(logrus.Fields{
+			"spectatorID": spectatorID,
+			"gameID":      gameID,
+			"error":       err.Error(),
+		}).Error("failed to spectate game")
+		spectator.CloseConnectionWithError(err)
 	}
 }
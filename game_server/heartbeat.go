@@ -0,0 +1,111 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_HEARTBEAT_INTERVAL_S = 5
+	HEARTBEAT_TIMEOUT_S          = 5
+)
+
+// Status returns the server's current load as reported to the matchmaking server
+func (sgs *SimpleGameServer) Status() (hb common.GameServerHeartbeat) {
+	hb = common.GameServerHeartbeat{
+		ServerID: sgs.serverID,
+		URL:      sgs.config.InternalURL,
+		Address:  sgs.config.PublicAddress,
+		Region:   sgs.config.Region,
+		Capacity: sgs.config.MaxGames,
+		Draining: sgs.isDraining(),
+	}
+
+	sgs.gamesMutex.RLock()
+	defer sgs.gamesMutex.RUnlock()
+	hb.Games = len(sgs.games)
+	for _, g := range sgs.games {
+		g.PlayersMutex.RLock()
+		hb.Players += len(g.Players)
+		g.PlayersMutex.RUnlock()
+	}
+	return
+}
+
+// runHeartbeats reports the server's status to the matchmaking server until the context is cancelled
+func (sgs *SimpleGameServer) runHeartbeats(ctx context.Context) {
+	intervalS := sgs.config.HeartbeatIntervalS
+	if intervalS <= 0 {
+		intervalS = DEFAULT_HEARTBEAT_INTERVAL_S
+	}
+	ticker := time.NewTicker(time.Duration(intervalS) * time.Second)
+	defer ticker.Stop()
+
+	client := newHeartbeatClient()
+	for {
+		if err := sgs.sendHeartbeat(ctx, client); err != nil {
+			sgs.logger.WithFields(logrus.Fields{
+				"matchmakerURL": sgs.config.MatchmakerURL,
+				"error":         err.Error(),
+			}).Warn("failed sending heartbeat")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func newHeartbeatClient() *http.Client {
+	return &http.Client{
+		Timeout: HEARTBEAT_TIMEOUT_S * time.Second,
+	}
+}
+
+// sendHeartbeat posts the server's status to the matchmaking server
+func (sgs *SimpleGameServer) sendHeartbeat(ctx context.Context, client *http.Client) (err error) {
+	if err = sgs.postToMatchmaker(ctx, client, common.HEARTBEAT_PATH, sgs.Status()); err != nil {
+		err = errors.Wrap(err, "failed sending heartbeat")
+	}
+	return
+}
+
+// postToMatchmaker posts body as JSON to the path on the matchmaking server, authenticated with the shared server secret
+func (sgs *SimpleGameServer) postToMatchmaker(ctx context.Context, client *http.Client, path string, body interface{}) (err error) {
+	var encoded []byte
+	if encoded, err = json.Marshal(body); err != nil {
+		err = errors.Wrap(err, "failed encoding request")
+		return
+	}
+
+	url := strings.TrimSuffix(sgs.config.MatchmakerURL, "/") + path
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(encoded)); err != nil {
+		err = errors.Wrap(err, "failed creating request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(common.SERVER_SECRET_HEADER, sgs.config.AllocationSecret)
+
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		err = errors.Wrap(err, "failed sending request")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("matchmaking server rejected request with status %d", resp.StatusCode)
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package game
+
+import (
+	"github.com/gunnermanx/simplegameserver/auth"
+	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	player "github.com/gunnermanx/simplegameserver/game_server/game/player"
+	"github.com/gunnermanx/simplegameserver/metrics"
+)
+
+const (
+	METRICS_PATH = metrics.METRICS_PATH
+)
+
+const (
+	GAME_FAILURE_TIMED_OUT_WAITING = game.END_REASON_TIMED_OUT_WAITING
+	GAME_FAILURE_ENDED             = game.END_REASON_ENDED
+	GAME_FAILURE_ERROR             = game.END_REASON_ERROR
+)
+
+// serverMetrics are the metrics served on the game server's metrics endpoint
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	gamesCreated   *metrics.CounterVec
+	gamesCompleted *metrics.CounterVec
+	gamesFailed    *metrics.CounterVec
+	joinDuration   *metrics.HistogramVec
+	authFailures   *metrics.CounterVec
+	hookFailures   *metrics.CounterVec
+
+	game   *game.Metrics
+	player *player.Metrics
+}
+
+func newServerMetrics(sgs *SimpleGameServer) (m *serverMetrics) {
+	r := metrics.NewRegistry()
+	m = &serverMetrics{
+		registry: r,
+		gamesCreated: r.NewCounterVec(
+			"sgs_games_created_total",
+			"Games created on the server.",
+			"game_type",
+		),
+		gamesCompleted: r.NewCounterVec(
+			"sgs_games_completed_total",
+			"Games that ran to completion.",
+			"game_type",
+		),
+		gamesFailed: r.NewCounterVec(
+			"sgs_games_failed_total",
+			"Games that ended before completing, by reason.",
+			"game_type", "reason",
+		),
+		joinDuration: r.NewHistogramVec(
+			"sgs_join_duration_seconds",
+			"Time taken for a player to join a game, from the join request until the player is seated.",
+			nil,
+			"game_type",
+		),
+		authFailures: r.NewCounterVec(
+			"sgs_auth_failures_total",
+			"Requests rejected by a route's auth policy, by reason.",
+			"reason",
+		),
+		hookFailures: r.NewCounterVec(
+			"sgs_post_game_hook_failures_total",
+			"Failed attempts of post-game hooks, by hook.",
+			"hook",
+		),
+		game:   game.NewMetrics(r),
+		player: player.NewMetrics(r),
+	}
+	r.NewGaugeFunc("sgs_active_games", "Games currently on the server.", func() float64 {
+		return float64(sgs.Status().Games)
+	})
+	r.NewGaugeFunc("sgs_active_players", "Players currently seated in games on the server.", func() float64 {
+		return float64(sgs.Status().Players)
+	})
+	return
+}
+
+// observeGameCompleted records how a game ended
+func (m *serverMetrics) observeGameCompleted(gameType string, err error) {
+	if err == nil {
+		m.gamesCompleted.WithLabelValues(gameType).Inc()
+		return
+	}
+	m.gamesFailed.WithLabelValues(gameType, game.EndReason(err)).Inc()
+}
+
+// observeAuthFailure records a request rejected by its route's policy
+func (m *serverMetrics) observeAuthFailure(err error) {
+	m.authFailures.WithLabelValues(auth.FailureReason(err)).Inc()
+}
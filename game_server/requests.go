@@ -1,6 +1,19 @@
 package game
 
 type CreateGameRequest struct {
-	NumPlayers            int `json:"numPlayers"`
-	WaitForPlayersTimeout int `json:"waitForPlayersTimeout"`
+	GameType              string `json:"gameType"`
+	NumPlayers            int    `json:"numPlayers"`
+	WaitForPlayersTimeout int    `json:"waitForPlayersTimeout"`
+	// PlayerIDs are other players invited to the game, they request their own join tickets from JOIN_TICKET_PATH
+	PlayerIDs []string `json:"playerIDs"`
+}
+
+type CreateGameResponse struct {
+	GameID string `json:"gameID"`
+	// JoinTicket is the creator's join ticket, omitted if join tickets aren't configured
+	JoinTicket string `json:"joinTicket,omitempty"`
+}
+
+type JoinTicketResponse struct {
+	JoinTicket string `json:"joinTicket"`
 }
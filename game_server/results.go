@@ -0,0 +1,145 @@
+package game
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/gunnermanx/simplegameserver/datastore/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_POST_GAME_HOOK_ATTEMPTS = 5
+	DEFAULT_POST_GAME_HOOK_RETRY_MS = 500
+	// POST_GAME_HOOK_TIMEOUT_S bounds a single attempt of a post-game hook
+	POST_GAME_HOOK_TIMEOUT_S = 10
+)
+
+// Names of the built in post-game hooks
+const (
+	SAVE_GAME_RESULT_HOOK  = "save_game_result"
+	NOTIFY_MATCHMAKER_HOOK = "notify_matchmaker"
+)
+
+// PostGameHook is called with the result of every game on the server once it ends
+//
+// Hooks run concurrently once the game has been removed from the server, and a hook that returns
+// an error is retried with backoff, so hooks should be safe to call more than once for the same result.
+// Games that didn't run to completion are passed on too, check the result's EndReason
+type PostGameHook func(ctx context.Context, result common.GameResult) error
+
+type postGameHook struct {
+	name string
+	hook PostGameHook
+}
+
+// RegisterPostGameHook adds a hook that is called with the result of every game, name identifies it in logs and metrics
+//
+// Results are saved to the datastore if SaveGameResults is configured,
+// and sent to the matchmaking server if a matchmaker URL is configured
+func (sgs *SimpleGameServer) RegisterPostGameHook(name string, hook PostGameHook) {
+	sgs.postGameHooksMutex.Lock()
+	defer sgs.postGameHooksMutex.Unlock()
+	sgs.postGameHooks = append(sgs.postGameHooks, postGameHook{name: name, hook: hook})
+}
+
+// runPostGameHooks passes the result to every registered hook and waits until
+// each one has succeeded or run out of attempts
+func (sgs *SimpleGameServer) runPostGameHooks(result common.GameResult) {
+	sgs.postGameHooksMutex.RLock()
+	hooks := append([]postGameHook(nil), sgs.postGameHooks...)
+	sgs.postGameHooksMutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, h := range hooks {
+		wg.Add(1)
+		go func(h postGameHook) {
+			defer wg.Done()
+			sgs.runPostGameHook(h, result)
+		}(h)
+	}
+	wg.Wait()
+}
+
+// runPostGameHook calls the hook until it succeeds, doubling the delay between attempts
+func (sgs *SimpleGameServer) runPostGameHook(h postGameHook, result common.GameResult) (err error) {
+	attempts := sgs.config.PostGameHookAttempts
+	if attempts <= 0 {
+		attempts = DEFAULT_POST_GAME_HOOK_ATTEMPTS
+	}
+	retryMS := sgs.config.PostGameHookRetryMS
+	if retryMS <= 0 {
+		retryMS = DEFAULT_POST_GAME_HOOK_RETRY_MS
+	}
+	delay := time.Duration(retryMS) * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), POST_GAME_HOOK_TIMEOUT_S*time.Second)
+		err = h.hook(ctx, result)
+		cancel()
+		if err == nil {
+			return
+		}
+		sgs.metrics.hookFailures.WithLabelValues(h.name).Inc()
+
+		logger := sgs.logger.WithFields(logrus.Fields{
+			"hook":    h.name,
+			"gameID":  result.GameID,
+			"attempt": attempt,
+			"error":   err.Error(),
+		})
+		if attempt >= attempts {
+			logger.Error("post-game hook failed, giving up")
+			return
+		}
+		logger.Warn("post-game hook failed, retrying")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// saveGameResult is the post-game hook that stores results in the datastore
+func (sgs *SimpleGameServer) saveGameResult(ctx context.Context, result common.GameResult) (err error) {
+	stored := model.GameResult{
+		ID:        result.GameID,
+		GameType:  result.GameType,
+		MatchID:   result.MatchID,
+		ServerID:  result.ServerID,
+		EndReason: result.EndReason,
+		EndDetail: result.EndDetail,
+		PlayerIDs: result.PlayerIDs,
+		Winners:   result.Winners,
+		Teams:     result.Teams,
+		Ticks:     result.Ticks,
+		StartedAt: result.StartedAt,
+		EndedAt:   result.EndedAt,
+	}
+	if result.Players != nil {
+		stored.Players = make(map[string]model.PlayerResult)
+		for playerID, pr := range result.Players {
+			stored.Players[playerID] = model.PlayerResult{
+				Score: pr.Score,
+				Stats: pr.Stats,
+			}
+		}
+	}
+	if err = sgs.datastore.SaveGameResult(stored); err != nil {
+		err = errors.Wrap(err, "failed saving game result")
+	}
+	return
+}
+
+// notifyMatchmaker is the post-game hook that reports results to the matchmaking server
+func (sgs *SimpleGameServer) notifyMatchmaker(ctx context.Context, result common.GameResult) (err error) {
+	client := &http.Client{
+		Timeout: POST_GAME_HOOK_TIMEOUT_S * time.Second,
+	}
+	if err = sgs.postToMatchmaker(ctx, client, common.GAME_RESULT_PATH, result); err != nil {
+		err = errors.Wrap(err, "failed sending game result")
+	}
+	return
+}
@@ -7,16 +7,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gunnermanx/simplegameserver/auth"
+	"github.com/gunnermanx/simplegameserver/common"
 	"github.com/gunnermanx/simplegameserver/config"
 	"github.com/gunnermanx/simplegameserver/datastore"
 
 	sgs_errors "github.com/gunnermanx/simplegameserver/game_server/errors"
 	game "github.com/gunnermanx/simplegameserver/game_server/game"
+	codec "github.com/gunnermanx/simplegameserver/game_server/game/codec"
 	player "github.com/gunnermanx/simplegameserver/game_server/game/player"
+	replay "github.com/gunnermanx/simplegameserver/game_server/game/replay"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -40,20 +46,35 @@ const (
 //   Relay messages between the server and clients
 type SimpleGameServer struct {
 	config   *config.GameServerConfig
+	serverID string
 	serveMux *http.ServeMux
-	server   *http.Server
-	logger   *logrus.Logger
-
-	games        map[string]*game.Game
-	gamesMutex   sync.RWMutex
+	// routePolicies maps registered patterns to who can reach them
+	routePolicies map[string]auth.Policy
+	server        *http.Server
+	logger        *logrus.Logger
+
+	games      map[string]*game.Game
+	gamesMutex sync.RWMutex
+	// gamesRunning tracks running games so draining can wait for them to complete
+	gamesRunning sync.WaitGroup
+	draining     int32
 	players      map[string]player.GamePlayer
 	playersMutex sync.RWMutex
 
 	datastore    datastore.Datastore
 	authProvider auth.AuthProvider
 
-	gameInit game.GameInit
-	gameTick game.GameTick
+	gameTypes      map[string]*GameType
+	gameTypesMutex sync.RWMutex
+	codecs         []codec.Codec
+
+	// joinTickets is nil if join tickets aren't configured, any authenticated player can then join any game
+	joinTickets *common.JoinTicketVerifier
+
+	postGameHooks      []postGameHook
+	postGameHooksMutex sync.RWMutex
+
+	metrics *serverMetrics
 }
 
 func New(
@@ -64,13 +85,36 @@ func New(
 ) (s *SimpleGameServer) {
 
 	s = &SimpleGameServer{
-		config:       conf,
-		logger:       logger,
-		authProvider: ap,
-		datastore:    ds,
-		serveMux:     http.NewServeMux(),
-		games:        make(map[string]*game.Game),
-		players:      make(map[string]player.GamePlayer),
+		config:        conf,
+		logger:        logger,
+		authProvider:  ap,
+		datastore:     ds,
+		serveMux:      http.NewServeMux(),
+		routePolicies: make(map[string]auth.Policy),
+		games:         make(map[string]*game.Game),
+		players:       make(map[string]player.GamePlayer),
+		gameTypes:     make(map[string]*GameType),
+		codecs:        codec.Defaults(),
+	}
+
+	s.serverID = conf.ServerID
+	if s.serverID == "" {
+		s.serverID = uuid.New().String()
+	}
+
+	if conf.JoinTicketSecret != "" {
+		s.joinTickets = common.NewJoinTicketVerifier(conf.JoinTicketSecret)
+	} else {
+		logger.Warn("no join ticket secret configured, players can join any game without a join ticket")
+	}
+
+	s.metrics = newServerMetrics(s)
+
+	if conf.SaveGameResults {
+		s.RegisterPostGameHook(SAVE_GAME_RESULT_HOOK, s.saveGameResult)
+	}
+	if conf.MatchmakerURL != "" {
+		s.RegisterPostGameHook(NOTIFY_MATCHMAKER_HOOK, s.notifyMatchmaker)
 	}
 
 	s.setupHandlers()
@@ -91,6 +135,13 @@ func (sgs *SimpleGameServer) Start() (err error) {
 		return
 	}
 
+	// Report to the matchmaking server so it can place matches on this server
+	if sgs.config.MatchmakerURL != "" {
+		heartbeatCtx, heartbeatCancel := context.WithCancel(context.Background())
+		defer heartbeatCancel()
+		go sgs.runHeartbeats(heartbeatCtx)
+	}
+
 	// Start the http server
 	errc := make(chan error, 1)
 	go func() {
@@ -100,12 +151,14 @@ func (sgs *SimpleGameServer) Start() (err error) {
 
 	// Wait for termination or errors
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	select {
 	case err = <-errc:
 		sgs.logger.Errorf("failed to serve: %s", err.Error())
 	case sig := <-sigs:
 		sgs.logger.Errorf("terminating on sig: %v", sig)
+		// Keep serving while draining so players can reconnect to running games
+		sgs.Drain(sgs.drainTimeout())
 	}
 
 	// Gracefully shutdown with timeout of 10s
@@ -114,12 +167,24 @@ func (sgs *SimpleGameServer) Start() (err error) {
 	return sgs.server.Shutdown(ctx)
 }
 
+// WithGameInit sets the init function of the default game type
 func (sgs *SimpleGameServer) WithGameInit(init game.GameInit) {
-	sgs.gameInit = init
+	sgs.updateDefaultGameType(func(gt *GameType) {
+		gt.Init = init
+	})
 }
 
+// WithGameTick sets the tick function of the default game type
 func (sgs *SimpleGameServer) WithGameTick(tick game.GameTick) {
-	sgs.gameTick = tick
+	sgs.updateDefaultGameType(func(gt *GameType) {
+		gt.Tick = tick
+	})
+}
+
+// WithCodecs sets the wire formats players can negotiate, in order of preference
+// The first codec is used for clients that don't request one
+func (sgs *SimpleGameServer) WithCodecs(codecs ...codec.Codec) {
+	sgs.codecs = codecs
 }
 
 // connect will connect a player to the server
@@ -145,44 +210,198 @@ func (sgs *SimpleGameServer) connect(playerID string) (err error) {
 	return
 }
 
-// createGame creates and runs a game instance on the server
-func (sgs *SimpleGameServer) createGame(numPlayers int, waitForPlayersTimeout int) (g *game.Game, err error) {
+// createGame creates and runs a game instance of the given game type on the server
+//
+// numPlayers and waitForPlayersTimeout fall back to the game type's defaults if 0.
+// If allowedPlayerIDs is set, only those players can join and the game is created for all of them.
+// matchID and teams are set for games allocated by the matchmaking server and are passed on in the game's result
+func (sgs *SimpleGameServer) createGame(
+	gameTypeName string,
+	numPlayers int,
+	waitForPlayersTimeout int,
+	allowedPlayerIDs []string,
+	matchID string,
+	teams [][]string,
+) (g *game.Game, err error) {
+	var gt *GameType
+	if gt, err = sgs.getGameType(gameTypeName); err != nil {
+		return
+	}
+	if allowedPlayerIDs != nil {
+		numPlayers = len(allowedPlayerIDs)
+	}
+	if numPlayers, err = gt.numPlayers(numPlayers); err != nil {
+		return
+	}
+	if err = validateTeams(teams, allowedPlayerIDs); err != nil {
+		return
+	}
+	if waitForPlayersTimeout == 0 {
+		waitForPlayersTimeout = gt.WaitForPlayersTimeoutS
+	}
+	if waitForPlayersTimeout == 0 {
+		waitForPlayersTimeout = DEFAULT_WAIT_FOR_PLAYERS_TIMEOUT_S
+	}
+	tickIntervalMS := gt.TickIntervalMS
+	if tickIntervalMS == 0 {
+		tickIntervalMS = sgs.config.TickIntervalMS
+	}
+
+	sgs.gamesMutex.Lock()
+	defer sgs.gamesMutex.Unlock()
+	if sgs.isDraining() {
+		err = sgs_errors.ErrServerDraining
+		return
+	}
+	if sgs.config.MaxGames > 0 && len(sgs.games) >= sgs.config.MaxGames {
+		err = sgs_errors.ErrServerFull
+		return
+	}
+
 	// TODO need some form of protection here later
 	g = game.NewGame(sgs.logger, numPlayers)
-	sgs.gamesMutex.Lock()
+	g.GameType = gt.Name
+	g.MatchID = matchID
+	g.Teams = teams
+	g.Logger = g.Logger.WithField("gameType", gt.Name)
+	if allowedPlayerIDs != nil {
+		g.AllowedPlayerIDs = make(map[string]bool)
+		for _, playerID := range allowedPlayerIDs {
+			g.AllowedPlayerIDs[playerID] = true
+		}
+	}
+	g.ReconnectGracePeriod = time.Duration(sgs.config.ReconnectGracePeriodS) * time.Second
+	g.MaxSpectators = sgs.config.MaxSpectatorsPerGame
+	g.SpectatorDelay = time.Duration(sgs.config.SpectatorDelayS) * time.Second
+	g.Metrics = sgs.metrics.game
+	g.LoopMode = gt.LoopMode
+	g.MaxCatchUpTicks = gt.MaxCatchUpTicks
+	g.Lockstep = gt.Lockstep
+	g.Decoders = gt.Decoders
+
+	// Record the game if a replay directory is configured
+	var recorder *replay.StreamRecorder
+	if sgs.config.ReplayDir != "" {
+		replayPath := filepath.Join(sgs.config.ReplayDir, fmt.Sprintf("%s.replay", g.ID))
+		if recorder, err = replay.NewFileRecorder(g.ID, replayPath); err != nil {
+			g.Cancel()
+			return
+		}
+		g.Recorder = recorder
+	}
+
 	sgs.games[g.ID] = g
-	sgs.gamesMutex.Unlock()
+	sgs.gamesRunning.Add(1)
+	sgs.metrics.gamesCreated.WithLabelValues(gt.Name).Inc()
 
 	// Run the game in a separate goroutine
 	go func() {
 		var wg sync.WaitGroup
 		wg.Add(1)
 
+		var result common.GameResult
 		go func() {
 			defer wg.Done()
 			g.Run(
-				sgs.gameInit,
-				sgs.gameTick,
-				sgs.config.TickIntervalMS,
+				gt.Init,
+				gt.Tick,
+				tickIntervalMS,
 				waitForPlayersTimeout,
-				nil,
+				func(r common.GameResult, err error) {
+					result = r
+					result.ServerID = sgs.serverID
+					sgs.metrics.observeGameCompleted(gt.Name, err)
+				},
 			)
 		}()
 
 		wg.Wait()
+		if recorder != nil {
+			if err := recorder.Close(); err != nil {
+				g.Logger.WithField("error", err.Error()).Error("failed closing replay")
+			}
+		}
 		sgs.gamesMutex.Lock()
 		delete(sgs.games, g.ID)
 		sgs.gamesMutex.Unlock()
 
+		// Draining waits for the results to be handed off too
+		sgs.runPostGameHooks(result)
+		sgs.gamesRunning.Done()
 	}()
 
 	return
 }
 
-func (sgs *SimpleGameServer) createPlayer(playerID string, w http.ResponseWriter, r *http.Request) (p *player.SGSGamePlayer, err error) {
+// validateTeams checks that every player on a team is allowed in the game and is only on one team
+func validateTeams(teams [][]string, allowedPlayerIDs []string) (err error) {
+	if len(teams) == 0 {
+		return
+	}
+	allowed := make(map[string]bool)
+	for _, playerID := range allowedPlayerIDs {
+		allowed[playerID] = true
+	}
+	onTeam := make(map[string]bool)
+	for _, team := range teams {
+		for _, playerID := range team {
+			if !allowed[playerID] || onTeam[playerID] {
+				err = sgs_errors.ErrGameInvalidTeams
+				return
+			}
+			onTeam[playerID] = true
+		}
+	}
+	return
+}
+
+func (sgs *SimpleGameServer) createPlayer(
+	playerID string,
+	g *game.Game,
+	w http.ResponseWriter,
+	r *http.Request,
+) (p *player.SGSGamePlayer, err error) {
 	// TODO, check if playerID is connected to server
 	// TODO, should bootstrap some info from server player to game player
-	p, err = player.NewSGSGamePlayer(playerID, w, r)
+	if p, err = player.NewSGSGamePlayer(playerID, w, r, sgs.codecsForGame(g)); err != nil {
+		return
+	}
+	p.Metrics = sgs.metrics.player
+	return
+}
+
+// mintJoinTickets signs a join ticket to the game for each player, or returns nil if join tickets aren't configured
+func (sgs *SimpleGameServer) mintJoinTickets(gameID string, playerIDs []string) (tickets map[string]string, err error) {
+	if sgs.joinTickets == nil {
+		return
+	}
+	now := time.Now()
+	ttl := common.JoinTicketTTL(sgs.config.JoinTicketTTLS)
+	tickets = make(map[string]string)
+	for _, playerID := range playerIDs {
+		if tickets[playerID], err = common.SignJoinTicket(sgs.config.JoinTicketSecret, playerID, gameID, ttl, now); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// verifyJoinTicket checks and consumes the player's join ticket to the game
+//
+// A player reconnecting to a seat they already hold doesn't need a new ticket.
+// release gives the ticket back, it should be called if the player fails to join the game
+func (sgs *SimpleGameServer) verifyJoinTicket(r *http.Request, playerID string, g *game.Game) (release func(), err error) {
+	release = func() {}
+	if sgs.joinTickets == nil || g.HoldsSeat(playerID) {
+		return
+	}
+	var claims common.JoinTicketClaims
+	if claims, err = sgs.joinTickets.Verify(r.URL.Query().Get(common.JOIN_TICKET_PARAM), playerID, g.ID, time.Now()); err != nil {
+		return
+	}
+	release = func() {
+		sgs.joinTickets.Release(claims)
+	}
 	return
 }
 
@@ -207,17 +426,22 @@ func (sgs *SimpleGameServer) joinGame(
 	if g, err = sgs.getGame(gameID); err != nil {
 		return
 	}
-	// Check if the game is full
-	g.PlayersMutex.RLock()
-	currentNumPlayers := len(g.Players)
-	g.PlayersMutex.RUnlock()
-	if currentNumPlayers >= g.NumPlayers {
-		err = sgs_errors.ErrGameFull
-		return
-	}
+	// Add the player to the game, this also checks if the game is full
+	// or if the player is reconnecting to a reserved seat
+	err = g.AddPlayer(player)
 
-	// Add the player to the game
-	g.AddPlayer(player)
+	return
+}
 
+// spectateGame adds a spectator to an existing game on the server
+func (sgs *SimpleGameServer) spectateGame(
+	gameID string,
+	spectator player.GamePlayer,
+) (err error) {
+	var g *game.Game
+	if g, err = sgs.getGame(gameID); err != nil {
+		return
+	}
+	err = g.AddSpectator(spectator)
 	return
 }
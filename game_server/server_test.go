@@ -2,10 +2,20 @@ package game
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/gunnermanx/simplegameserver/auth"
+	"github.com/gunnermanx/simplegameserver/common"
 	"github.com/gunnermanx/simplegameserver/config"
+	"github.com/gunnermanx/simplegameserver/datastore/model"
 	game "github.com/gunnermanx/simplegameserver/game_server/game"
 	messages "github.com/gunnermanx/simplegameserver/game_server/game/messages"
 	game_player "github.com/gunnermanx/simplegameserver/game_server/game/player"
@@ -74,6 +84,7 @@ func TestServer(t *testing.T) {
 			mockAuthProvider := mocks.NewMockAuthProvider(mockCtrl)
 			mockDatastore := mocks.NewMockDatastore(mockCtrl)
 			mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+			mockPlayer.EXPECT().GetID().Return(p1_id).AnyTimes()
 
 			s := New(
 				config,
@@ -111,4 +122,356 @@ func TestServer(t *testing.T) {
 			require.ErrorIs(t, err, sgs_errors.ErrGameNotFound)
 		})
 	})
+	t.Run("create game", func(t *testing.T) {
+		gameInit := func(
+			ctx context.Context, g *game.Game, playerIDs []string,
+		) (out map[string][]messages.GameMessage, err error) {
+			return
+		}
+		gameTick := func(
+			ctx context.Context, g *game.Game, in game.Inputs,
+		) (complete bool, out map[string][]messages.GameMessage, err error) {
+			return
+		}
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s := New(
+			config,
+			logger,
+			mocks.NewMockAuthProvider(mockCtrl),
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		err := s.RegisterGameType(GameType{
+			Name:       "duel",
+			Init:       gameInit,
+			Tick:       gameTick,
+			MinPlayers: 2,
+			MaxPlayers: 2,
+		})
+		require.NoError(t, err)
+
+		t.Run("registered game type", func(t *testing.T) {
+			g, err := s.createGame("duel", 0, 1, nil, "", nil)
+			require.NoError(t, err)
+			defer g.Cancel()
+			require.Equal(t, "duel", g.GameType)
+			require.Equal(t, 2, g.NumPlayers)
+			require.Contains(t, s.games, g.ID)
+		})
+
+		t.Run("unknown game type", func(t *testing.T) {
+			_, err := s.createGame("battle_royale", 2, 1, nil, "", nil)
+			require.ErrorIs(t, err, sgs_errors.ErrGameTypeNotFound)
+		})
+
+		t.Run("default game type isn't configured", func(t *testing.T) {
+			_, err := s.createGame("", 2, 1, nil, "", nil)
+			require.ErrorIs(t, err, sgs_errors.ErrGameTypeNotFound)
+		})
+
+		t.Run("number of players out of bounds", func(t *testing.T) {
+			_, err := s.createGame("duel", 3, 1, nil, "", nil)
+			require.ErrorIs(t, err, sgs_errors.ErrGameInvalidNumPlayers)
+		})
+
+		t.Run("teams with players outside the allocation", func(t *testing.T) {
+			_, err := s.createGame("duel", 0, 1, []string{"p1_id", "p2_id"}, "", [][]string{{"p1_id"}, {"p3_id"}})
+			require.ErrorIs(t, err, sgs_errors.ErrGameInvalidTeams)
+			_, err = s.createGame("duel", 0, 1, []string{"p1_id", "p2_id"}, "", [][]string{{"p1_id"}, {"p1_id"}})
+			require.ErrorIs(t, err, sgs_errors.ErrGameInvalidTeams)
+		})
+
+		t.Run("server at capacity", func(t *testing.T) {
+			g, err := s.createGame("duel", 0, 1, nil, "", nil)
+			require.NoError(t, err)
+			defer g.Cancel()
+
+			status := s.Status()
+			require.GreaterOrEqual(t, status.Games, 1)
+			require.Equal(t, 0, status.Players)
+
+			s.config.MaxGames = 1
+			defer func() { s.config.MaxGames = 0 }()
+			_, err = s.createGame("duel", 0, 1, nil, "", nil)
+			require.ErrorIs(t, err, sgs_errors.ErrServerFull)
+		})
+
+		t.Run("metrics", func(t *testing.T) {
+			scrape := func() string {
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, METRICS_PATH, nil))
+				require.Equal(t, http.StatusOK, w.Code)
+				return w.Body.String()
+			}
+
+			g, err := s.createGame("duel", 0, 1, nil, "", nil)
+			require.NoError(t, err)
+			body := scrape()
+			require.Contains(t, body, `sgs_games_created_total{game_type="duel"}`)
+			require.Contains(t, body, "# TYPE sgs_active_games gauge")
+			require.Contains(t, body, "# TYPE sgs_game_tick_duration_seconds histogram")
+
+			g.Cancel()
+			require.Eventually(t, func() bool {
+				return strings.Contains(scrape(), `sgs_games_failed_total{game_type="duel",reason="ended"}`)
+			}, time.Second, 10*time.Millisecond)
+		})
+
+		t.Run("game type already registered", func(t *testing.T) {
+			err := s.RegisterGameType(GameType{
+				Name: "duel",
+				Init: gameInit,
+				Tick: gameTick,
+			})
+			require.ErrorIs(t, err, sgs_errors.ErrGameTypeAlreadyRegistered)
+		})
+	})
+
+	t.Run("join tickets", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		ticketConfig := *config
+		ticketConfig.JoinTicketSecret = "secret"
+		mockAuth := mocks.NewMockAuthProvider(mockCtrl)
+		s := New(
+			&ticketConfig,
+			logger,
+			mockAuth,
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		g := game.NewGame(logger, 2)
+		defer g.Cancel()
+
+		tickets, err := s.mintJoinTickets(g.ID, []string{p1_id})
+		require.NoError(t, err)
+
+		joinRequest := func(ticket string) *http.Request {
+			return httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?id=%s&ticket=%s", JOIN_GAME_PATH, g.ID, ticket), nil)
+		}
+
+		verify := func(ticket string, playerID string) error {
+			_, err := s.verifyJoinTicket(joinRequest(ticket), playerID, g)
+			return err
+		}
+		require.ErrorIs(t, verify("", p1_id), common.ErrJoinTicketMissing)
+		require.ErrorIs(t, verify(tickets[p1_id], "p2_id"), common.ErrJoinTicketMismatch)
+
+		// A ticket is given back if the player fails to join with it, here because the websocket can't be accepted
+		s.games[g.ID] = g
+		mockAuth.EXPECT().GetUIDFromRequest(gomock.Any()).Return(p1_id, nil).Times(1)
+		w := httptest.NewRecorder()
+		s.joinGameHandler(w, joinRequest(tickets[p1_id]))
+		require.NotEqual(t, http.StatusForbidden, w.Code)
+
+		require.NoError(t, verify(tickets[p1_id], p1_id))
+		require.ErrorIs(t, verify(tickets[p1_id], p1_id), common.ErrJoinTicketReplayed)
+
+		// Players holding a seat can reconnect without a new ticket
+		mockPlayer := mocks.NewMockGamePlayer(mockCtrl)
+		g.Players[p1_id] = mockPlayer
+		require.NoError(t, verify("", p1_id))
+
+		// Only invited players can request their own ticket
+		g.Invite("p2_id")
+		requestTicket := func(playerID string) *httptest.ResponseRecorder {
+			mockAuth.EXPECT().GetUIDFromRequest(gomock.Any()).Return(playerID, nil).Times(1)
+			w := httptest.NewRecorder()
+			s.joinTicketHandler(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?id=%s", JOIN_TICKET_PATH, g.ID), nil))
+			return w
+		}
+
+		w = requestTicket("p3_id")
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = requestTicket("p2_id")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response JoinTicketResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.NoError(t, verify(response.JoinTicket, "p2_id"))
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s := New(
+			config,
+			logger,
+			mocks.NewMockAuthProvider(mockCtrl),
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		require.NoError(t, s.RegisterGameType(GameType{
+			Name: "duel",
+			Init: func(ctx context.Context, g *game.Game, playerIDs []string) (map[string][]messages.GameMessage, error) {
+				return nil, nil
+			},
+			Tick: func(ctx context.Context, g *game.Game, in game.Inputs) (bool, map[string][]messages.GameMessage, error) {
+				return false, nil, nil
+			},
+			MinPlayers: 2,
+			MaxPlayers: 2,
+		}))
+
+		// Games still waiting for players are ended right away
+		g, err := s.createGame("duel", 0, 60, nil, "", nil)
+		require.NoError(t, err)
+		s.Drain(10 * time.Second)
+		require.Error(t, g.Context.Err())
+		require.Empty(t, s.games)
+
+		_, err = s.createGame("duel", 0, 60, nil, "", nil)
+		require.ErrorIs(t, err, sgs_errors.ErrServerDraining)
+		require.True(t, s.Status().Draining)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HEALTH_PATH, nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("post-game hooks", func(t *testing.T) {
+		var reportedMutex sync.Mutex
+		var reported []common.GameResult
+		matchmakerCalls := 0
+		matchmaker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, common.GAME_RESULT_PATH, r.URL.Path)
+			require.Equal(t, "secret", r.Header.Get(common.SERVER_SECRET_HEADER))
+			reportedMutex.Lock()
+			defer reportedMutex.Unlock()
+			// The first attempt fails so the hook is retried
+			if matchmakerCalls++; matchmakerCalls == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var result common.GameResult
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&result))
+			reported = append(reported, result)
+		}))
+		defer matchmaker.Close()
+
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockDatastore := mocks.NewMockDatastore(mockCtrl)
+		hooksConfig := *config
+		hooksConfig.ServerID = "server_id"
+		hooksConfig.SaveGameResults = true
+		hooksConfig.MatchmakerURL = matchmaker.URL
+		hooksConfig.AllocationSecret = "secret"
+		hooksConfig.PostGameHookRetryMS = 1
+		s := New(
+			&hooksConfig,
+			logger,
+			mocks.NewMockAuthProvider(mockCtrl),
+			mockDatastore,
+		)
+		require.NoError(t, s.RegisterGameType(GameType{
+			Name: "duel",
+			Init: func(ctx context.Context, g *game.Game, playerIDs []string) (map[string][]messages.GameMessage, error) {
+				return nil, nil
+			},
+			Tick: func(ctx context.Context, g *game.Game, in game.Inputs) (bool, map[string][]messages.GameMessage, error) {
+				return false, nil, nil
+			},
+			MinPlayers: 2,
+			MaxPlayers: 2,
+		}))
+
+		var customResults []common.GameResult
+		s.RegisterPostGameHook("custom", func(ctx context.Context, result common.GameResult) error {
+			customResults = append(customResults, result)
+			return nil
+		})
+
+		g, err := s.createGame("duel", 0, 60, []string{"p1_id", "p2_id"}, "match_id", [][]string{{"p1_id"}, {"p2_id"}})
+		require.NoError(t, err)
+
+		gomock.InOrder(
+			mockDatastore.EXPECT().SaveGameResult(gomock.Any()).Return(fmt.Errorf("datastore unavailable")),
+			mockDatastore.EXPECT().SaveGameResult(gomock.Any()).DoAndReturn(func(result model.GameResult) error {
+				require.Equal(t, g.ID, result.ID)
+				require.Equal(t, "match_id", result.MatchID)
+				require.Equal(t, "server_id", result.ServerID)
+				require.Equal(t, game.END_REASON_ENDED, result.EndReason)
+				return nil
+			}),
+		)
+
+		g.Cancel()
+		s.gamesRunning.Wait()
+
+		require.Len(t, customResults, 1)
+		require.Equal(t, g.ID, customResults[0].GameID)
+		require.Equal(t, "duel", customResults[0].GameType)
+		reportedMutex.Lock()
+		defer reportedMutex.Unlock()
+		require.Equal(t, 2, matchmakerCalls)
+		require.Len(t, reported, 1)
+		require.Equal(t, g.ID, reported[0].GameID)
+		require.Equal(t, "match_id", reported[0].MatchID)
+		require.Equal(t, [][]string{{"p1_id"}, {"p2_id"}}, reported[0].Teams)
+		require.Equal(t, game.END_REASON_ENDED, reported[0].EndReason)
+
+		w := httptest.NewRecorder()
+		s.metrics.registry.Handler(w, httptest.NewRequest(http.MethodGet, METRICS_PATH, nil))
+		require.Contains(t, w.Body.String(), `sgs_post_game_hook_failures_total{hook="save_game_result"} 1`)
+		require.Contains(t, w.Body.String(), `sgs_post_game_hook_failures_total{hook="notify_matchmaker"} 1`)
+	})
+
+	t.Run("admin api", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockAuthProvider := mocks.NewMockAuthProvider(mockCtrl)
+		s := New(
+			config,
+			logger,
+			mockAuthProvider,
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		g := game.NewGame(logger, 2)
+		defer g.Cancel()
+		s.games[g.ID] = g
+
+		mockAuthProvider.EXPECT().AuthenticateRequest(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, r *http.Request) (context.Context, error) {
+				return context.WithValue(ctx, auth.KeyUID, "admin_id"), nil
+			},
+		).AnyTimes()
+
+		t.Run("players without the admin role are forbidden", func(t *testing.T) {
+			mockAuthProvider.EXPECT().GetRolesFromRequest(gomock.Any()).Return(nil, nil).Times(1)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ADMIN_GAMES_PATH, nil))
+			require.Equal(t, http.StatusForbidden, w.Code)
+		})
+
+		mockAuthProvider.EXPECT().GetRolesFromRequest(gomock.Any()).Return([]string{auth.ROLE_ADMIN}, nil).AnyTimes()
+
+		t.Run("list games", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ADMIN_GAMES_PATH, nil))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var response AdminGamesResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Len(t, response.Games, 1)
+			require.Equal(t, g.ID, response.Games[0].ID)
+			require.Equal(t, game.GAME_STATE_WAITING, response.Games[0].State)
+		})
+
+		t.Run("unknown game", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ADMIN_GAME_PATH+"?id=unknown", nil))
+			require.Equal(t, http.StatusNotFound, w.Code)
+		})
+
+		t.Run("controls on an ended game", func(t *testing.T) {
+			g.Cancel()
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(
+				http.MethodPost,
+				ADMIN_END_GAME_PATH,
+				strings.NewReader(fmt.Sprintf(`{"gameID":%q,"reason":"maintenance"}`, g.ID)),
+			))
+			require.Equal(t, http.StatusConflict, w.Code)
+		})
+	})
 }
@@ -0,0 +1,80 @@
+package matchmaking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	ALLOCATION_TIMEOUT_S = 10
+)
+
+//go:generate mockgen -destination=../mocks/mock_allocator.go -package=mocks github.com/gunnermanx/simplegameserver/matchmaking_server Allocator
+
+// Allocator asks a game server to create a game for a match
+type Allocator interface {
+	Allocate(ctx context.Context, gameServerURL string, req common.AllocateGameRequest) (common.AllocateGameResponse, error)
+}
+
+// HTTPAllocator calls the allocation endpoint of a game server, authenticated with the shared server secret
+type HTTPAllocator struct {
+	Secret string
+	Client *http.Client
+}
+
+func NewHTTPAllocator(secret string) (a *HTTPAllocator) {
+	a = &HTTPAllocator{
+		Secret: secret,
+		Client: &http.Client{
+			Timeout: ALLOCATION_TIMEOUT_S * time.Second,
+		},
+	}
+	return
+}
+
+func (a *HTTPAllocator) Allocate(
+	ctx context.Context,
+	gameServerURL string,
+	req common.AllocateGameRequest,
+) (resp common.AllocateGameResponse, err error) {
+	var body []byte
+	if body, err = json.Marshal(req); err != nil {
+		err = errors.Wrap(err, "failed encoding allocation request")
+		return
+	}
+
+	url := strings.TrimSuffix(gameServerURL, "/") + common.ALLOCATE_GAME_PATH
+	var httpReq *http.Request
+	if httpReq, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)); err != nil {
+		err = errors.Wrap(err, "failed creating allocation request")
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(common.SERVER_SECRET_HEADER, a.Secret)
+
+	var httpResp *http.Response
+	if httpResp, err = a.Client.Do(httpReq); err != nil {
+		err = errors.Wrap(err, "failed sending allocation request")
+		return
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusCreated {
+		var errResp common.ResponseData
+		json.NewDecoder(httpResp.Body).Decode(&errResp)
+		err = fmt.Errorf("game server rejected allocation with status %d: %s", httpResp.StatusCode, errResp["error"])
+		return
+	}
+	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		err = errors.Wrap(err, "failed decoding allocation response")
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package matchmaking
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DEFAULT_GAME_SERVER_TTL_S is how long a game server is considered healthy after its last heartbeat
+	DEFAULT_GAME_SERVER_TTL_S = 15
+)
+
+var (
+	ErrGameServerInvalid     = errors.New("heartbeat must have a serverID and url")
+	ErrNoGameServerAvailable = errors.New("no healthy game server with capacity in the region")
+)
+
+// registeredGameServer is the last reported status of a game server
+type registeredGameServer struct {
+	status   common.GameServerHeartbeat
+	lastSeen time.Time
+}
+
+// full returns true if the server has no room for another game, a capacity of 0 is unlimited
+func (gs *registeredGameServer) full() bool {
+	return gs.status.Capacity > 0 && gs.status.Games >= gs.status.Capacity
+}
+
+// load returns the fraction of the server's capacity in use
+func (gs *registeredGameServer) load() float64 {
+	if gs.status.Capacity <= 0 {
+		return 0
+	}
+	return float64(gs.status.Games) / float64(gs.status.Capacity)
+}
+
+// lessLoaded orders servers by load, then by the number of games and players they host
+func (gs *registeredGameServer) lessLoaded(other *registeredGameServer) bool {
+	if gs.load() != other.load() {
+		return gs.load() < other.load()
+	}
+	if gs.status.Games != other.status.Games {
+		return gs.status.Games < other.status.Games
+	}
+	return gs.status.Players < other.status.Players
+}
+
+// gameServerTTL returns how long a game server stays registered without a heartbeat
+func (sms *SimpleMatchmakingServer) gameServerTTL() time.Duration {
+	ttlS := sms.config.GameServerTTLS
+	if ttlS <= 0 {
+		ttlS = DEFAULT_GAME_SERVER_TTL_S
+	}
+	return time.Duration(ttlS) * time.Second
+}
+
+// registerHeartbeat records the status reported by a game server, registering it if it is new
+func (sms *SimpleMatchmakingServer) registerHeartbeat(hb common.GameServerHeartbeat, now time.Time) (err error) {
+	if hb.ServerID == "" || hb.URL == "" {
+		err = ErrGameServerInvalid
+		return
+	}
+
+	sms.gameServersMutex.Lock()
+	defer sms.gameServersMutex.Unlock()
+	if _, exists := sms.gameServers[hb.ServerID]; !exists {
+		sms.logger.WithFields(logrus.Fields{
+			"serverID": hb.ServerID,
+			"url":      hb.URL,
+			"region":   hb.Region,
+			"capacity": hb.Capacity,
+		}).Info("game server registered")
+	}
+	sms.gameServers[hb.ServerID] = &registeredGameServer{
+		status:   hb,
+		lastSeen: now,
+	}
+	return
+}
+
+// chooseGameServer returns the least loaded healthy game server in the region with room for another game
+//
+// An empty region accepts a server in any region. The chosen server's game count is bumped so
+// matches allocated before its next heartbeat are spread across the fleet
+func (sms *SimpleMatchmakingServer) chooseGameServer(region string, now time.Time) (status common.GameServerHeartbeat, err error) {
+	sms.gameServersMutex.Lock()
+	defer sms.gameServersMutex.Unlock()
+
+	ttl := sms.gameServerTTL()
+	var chosen *registeredGameServer
+	for serverID, gs := range sms.gameServers {
+		if now.Sub(gs.lastSeen) > ttl {
+			sms.logger.WithField("serverID", serverID).Warn("game server expired, no heartbeat received")
+			delete(sms.gameServers, serverID)
+			continue
+		}
+		if region != "" && gs.status.Region != region {
+			continue
+		}
+		if gs.full() || gs.status.Draining {
+			continue
+		}
+		if chosen == nil || gs.lessLoaded(chosen) {
+			chosen = gs
+		}
+	}
+	if chosen == nil {
+		err = ErrNoGameServerAvailable
+		return
+	}
+
+	chosen.status.Games++
+	status = chosen.status
+	return
+}
+
+// knownRegion returns true if a healthy game server reports the region, an empty region is always known
+//
+// Regions come from clients, so only the fleet's regions are accepted to keep them out of metric labels
+func (sms *SimpleMatchmakingServer) knownRegion(region string, now time.Time) bool {
+	if region == "" {
+		return true
+	}
+	sms.gameServersMutex.Lock()
+	defer sms.gameServersMutex.Unlock()
+	ttl := sms.gameServerTTL()
+	for _, gs := range sms.gameServers {
+		if gs.status.Region == region && now.Sub(gs.lastSeen) <= ttl {
+			return true
+		}
+	}
+	return false
+}
@@ -2,41 +2,104 @@ package matchmaking
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/gunnermanx/simplegameserver/auth"
 	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/strategy"
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
 )
 
 const (
 	REQUEST_TIMEOUT_S = 5
+	// QUEUE_SESSION_WRITE_TIMEOUT_S is how long a queue status update can take to send before the session is closed
+	QUEUE_SESSION_WRITE_TIMEOUT_S = 5
 )
 
 const (
-	FIND_MATCH_PATH = "/match/find"
+	HEALTH_PATH       = "/health"
+	FIND_MATCH_PATH   = "/match/find"
+	QUEUE_PATH        = "/match/queue"
+	MATCH_STATUS_PATH = "/match/status"
+	CANCEL_MATCH_PATH = "/match/cancel"
+	HEARTBEAT_PATH    = common.HEARTBEAT_PATH
+	GAME_RESULT_PATH  = common.GAME_RESULT_PATH
+
+	PARTY_CREATE_PATH  = "/party/create"
+	PARTY_INVITE_PATH  = "/party/invite"
+	PARTY_ACCEPT_PATH  = "/party/accept"
+	PARTY_LEAVE_PATH   = "/party/leave"
+	PARTY_DISBAND_PATH = "/party/disband"
+	PARTY_STATUS_PATH  = "/party/status"
+)
+
+const (
+	QUEUE_STATUS_QUEUED     = "queued"
+	QUEUE_STATUS_ALLOCATING = "allocating"
+	QUEUE_STATUS_MATCHED    = "matched"
 )
 
 func (sms *SimpleMatchmakingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), REQUEST_TIMEOUT_S*time.Second)
 	defer cancel()
 
+	// Unknown paths fall back to the player policy so they aren't served unauthenticated
+	policy := auth.PlayerPolicy
+	if _, pattern := sms.serveMux.Handler(r); pattern != "" {
+		policy = sms.routePolicies[pattern]
+	}
+
 	var err error
-	if ctx, err = sms.authProvider.AuthenticateRequest(ctx, r); err != nil {
-		common.WriteErrorResponse(w, http.StatusUnauthorized, err.Error())
+	if ctx, err = policy.Authorize(ctx, r, sms.authProvider, sms.config.AllocationSecret); err != nil {
+		sms.metrics.observeAuthFailure(err)
+		common.WriteErrorResponse(w, auth.StatusCode(err), err.Error())
 		return
 	}
 	sms.serveMux.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// RegisterHandler is used by custom game servers to register new http handlers for the given pattern
-func (sms *SimpleMatchmakingServer) RegisterHandler(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+// RegisterHandler is used by custom matchmaking servers to register new http handlers for the given pattern
+//
+// The policy decides who can reach the handler: public, authenticated players, players with a role,
+// or other servers presenting the shared server secret
+func (sms *SimpleMatchmakingServer) RegisterHandler(pattern string, policy auth.Policy, handler func(http.ResponseWriter, *http.Request)) {
+	sms.routePolicies[pattern] = policy
 	sms.serveMux.HandleFunc(pattern, handler)
 }
 
 func (sms *SimpleMatchmakingServer) setupHandlers() {
-	sms.serveMux.HandleFunc(FIND_MATCH_PATH, sms.findMatchHandler)
+	sms.RegisterHandler(HEALTH_PATH, auth.PublicPolicy, sms.healthHandler)
+	sms.RegisterHandler(METRICS_PATH, auth.PublicPolicy, sms.metrics.registry.Handler)
+	sms.RegisterHandler(FIND_MATCH_PATH, auth.PlayerPolicy, sms.findMatchHandler)
+	sms.RegisterHandler(QUEUE_PATH, auth.PlayerPolicy, sms.queueSessionHandler)
+	sms.RegisterHandler(MATCH_STATUS_PATH, auth.PlayerPolicy, sms.matchStatusHandler)
+	sms.RegisterHandler(CANCEL_MATCH_PATH, auth.PlayerPolicy, sms.cancelMatchHandler)
+	sms.RegisterHandler(HEARTBEAT_PATH, auth.ServerPolicy, sms.heartbeatHandler)
+	sms.RegisterHandler(GAME_RESULT_PATH, auth.ServerPolicy, sms.gameResultHandler)
+	sms.RegisterHandler(PARTY_CREATE_PATH, auth.PlayerPolicy, sms.createPartyHandler)
+	sms.RegisterHandler(PARTY_INVITE_PATH, auth.PlayerPolicy, sms.inviteToPartyHandler)
+	sms.RegisterHandler(PARTY_ACCEPT_PATH, auth.PlayerPolicy, sms.acceptPartyInviteHandler)
+	sms.RegisterHandler(PARTY_LEAVE_PATH, auth.PlayerPolicy, sms.leavePartyHandler)
+	sms.RegisterHandler(PARTY_DISBAND_PATH, auth.PlayerPolicy, sms.disbandPartyHandler)
+	sms.RegisterHandler(PARTY_STATUS_PATH, auth.PlayerPolicy, sms.partyStatusHandler)
+}
+
+// healthHandler lets load balancers and orchestrators check the server is up
+func (sms *SimpleMatchmakingServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{
+		"status": "ok",
+	})
 }
 
+// findMatchHandler queues the player for matchmaking, the client polls /match/status for the result.
+// See queueSessionHandler for having the status streamed instead
+// An optional region parameter restricts the match to players and game servers in that region,
+// it must be a region reported by a registered game server.
+// Party leaders queue their whole party, every member can poll the status of the party's ticket
 func (sms *SimpleMatchmakingServer) findMatchHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var playerID string
@@ -45,16 +108,203 @@ func (sms *SimpleMatchmakingServer) findMatchHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	var player *MatchmakingPlayer
-	if player, err = sms.GetPlayer(playerID); err != nil {
+	var ticket *strategy.Ticket
+	if ticket, err = sms.queuePlayer(playerID, r.URL.Query().Get("region")); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+
+	common.WriteResponse(w, http.StatusAccepted, common.ResponseData{
+		"ticketID": ticket.ID,
+		"status":   QUEUE_STATUS_QUEUED,
+	})
+}
+
+// queueSessionHandler queues the player like findMatchHandler and streams the status of their ticket over a websocket
+//
+// A MatchStatusResponse is sent once the player is queued, after every match forming pass and whenever the
+// ticket is matched or requeued. The socket is closed after the join ticket is sent, or if the ticket leaves
+// the queue. Closing the socket takes the ticket out of the queue unless it has already been matched.
+// Players that already have a ticket follow it instead of queueing again, this is how party members follow
+// the ticket their leader queued, players follow a ticket queued with /match/find, and players reopen a
+// dropped session. Only the ticket's own sessions dequeue it, once the last of them closes.
+// Players whose join ticket wasn't delivered are sent it again
+func (sms *SimpleMatchmakingServer) queueSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var ticketID string
+	var ownsTicket bool
+	if entry, entryErr := sms.getQueueEntry(playerID); entryErr == nil {
+		ticketID, ownsTicket = entry.ticket.ID, entry.session
+	} else {
+		var ticket *strategy.Ticket
+		if ticket, err = sms.queuePlayer(playerID, r.URL.Query().Get("region")); err != nil {
+			writeQueueError(w, err)
+			return
+		}
+		sms.markSessionTicket(playerID, ticket.ID)
+		ticketID, ownsTicket = ticket.ID, true
+	}
+	if ownsTicket {
+		defer func() {
+			if err := sms.dequeueUnwatched(playerID, ticketID); err == nil {
+				sms.logger.WithFields(logrus.Fields{
+					"playerID": playerID,
+					"ticketID": ticketID,
+				}).Info("queue session closed before a match was found")
+			}
+		}()
+	}
+
+	var conn *websocket.Conn
+	if conn, err = websocket.Accept(w, r, nil); err != nil {
+		sms.logger.WithFields(logrus.Fields{
+			"playerID": playerID,
+			"error":    err.Error(),
+		}).Error("failed accepting queue session")
+		return
+	}
+	// The client doesn't send anything, reading only notices it closing the socket
+	ctx := conn.CloseRead(context.Background())
+	updates, unwatch := sms.watchQueue(playerID)
+	defer unwatch()
+
+	for {
+		var status MatchStatusResponse
+		if status, err = sms.queueStatus(playerID, time.Now()); err != nil {
+			conn.Close(websocket.StatusNormalClosure, err.Error())
+			return
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, QUEUE_SESSION_WRITE_TIMEOUT_S*time.Second)
+		err = wsjson.Write(writeCtx, conn, status)
+		cancel()
+		if err != nil {
+			conn.Close(websocket.StatusAbnormalClosure, "failed sending queue status")
+			return
+		}
+		if status.Status == QUEUE_STATUS_MATCHED {
+			// The join ticket is only dropped once it has been sent, a player whose write failed can fetch it again
+			sms.removeMatchedEntry(playerID, status.TicketID)
+			conn.Close(websocket.StatusNormalClosure, "match is ready to join")
+			return
+		}
 
+		select {
+		case <-ctx.Done():
+			return
+		case <-updates:
+		}
 	}
+}
 
-	_ = player
+// writeQueueError writes the response for an error queueing a player
+func writeQueueError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrPlayerAlreadyQueued), errors.Is(err, ErrPartyChanged):
+		common.WriteErrorResponse(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrNotPartyLeader):
+		common.WriteErrorResponse(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, strategy.ErrTicketInvalid), errors.Is(err, ErrUnknownRegion):
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	default:
+		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+	}
+}
 
-	// if sgs.connect(playerID); err != nil {
-	// 	common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
-	// 	return
-	// }
+// MatchStatusResponse is the response to /match/status and the update streamed by /match/queue
+//
+// While queued, Position is the ticket's place in the queue with the longest waiting ticket first and
+// SearchWindow is the largest rating difference the ticket currently accepts, if the strategy reports it.
+// EstimatedWaitMS is the average time recent tickets waited to be matched, 0 until a match has formed
+type MatchStatusResponse struct {
+	TicketID        string      `json:"ticketID"`
+	Status          string      `json:"status"`
+	Position        int         `json:"position,omitempty"`
+	ElapsedMS       int64       `json:"elapsedMS"`
+	EstimatedWaitMS int64       `json:"estimatedWaitMS"`
+	SearchWindow    int         `json:"searchWindow,omitempty"`
+	MatchID         string      `json:"matchID,omitempty"`
+	PlayerIDs       []string    `json:"playerIDs,omitempty"`
+	Teams           [][]string  `json:"teams,omitempty"`
+	JoinTicket      *JoinTicket `json:"joinTicket,omitempty"`
+}
+
+func (sms *SimpleMatchmakingServer) matchStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var response MatchStatusResponse
+	if response, err = sms.queueStatus(playerID, time.Now()); err != nil {
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	common.WriteJSONResponse(w, http.StatusOK, response)
+	if response.Status == QUEUE_STATUS_MATCHED {
+		sms.removeMatchedEntry(playerID, response.TicketID)
+	}
+}
+
+func (sms *SimpleMatchmakingServer) cancelMatchHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = sms.dequeue(playerID); err != nil {
+		if errors.Is(err, ErrPlayerNotQueued) {
+			common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		} else {
+			common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+// heartbeatHandler records the status reported by a game server
+func (sms *SimpleMatchmakingServer) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var statusCode int
+	var hb common.GameServerHeartbeat
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &hb); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+	if err = sms.registerHeartbeat(hb, time.Now()); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+// gameResultHandler receives the result of a game from a game server, failures are retried by the game server
+func (sms *SimpleMatchmakingServer) gameResultHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var statusCode int
+	var result common.GameResult
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &result); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+	if err = sms.recordGameResult(result); err != nil {
+		if errors.Is(err, ErrGameResultInvalid) {
+			common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		} else {
+			common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
 	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
 }
@@ -0,0 +1,80 @@
+package matchmaking
+
+import (
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/auth"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/strategy"
+	"github.com/gunnermanx/simplegameserver/metrics"
+)
+
+const (
+	METRICS_PATH = metrics.METRICS_PATH
+)
+
+const (
+	ALLOCATION_RESULT_ALLOCATED = "allocated"
+	ALLOCATION_RESULT_NO_SERVER = "no_server"
+	ALLOCATION_RESULT_FAILED    = "failed"
+)
+
+// TimeToMatchBuckets are the time to match histogram buckets in seconds
+var TimeToMatchBuckets = []float64{1, 2, 5, 10, 15, 30, 60, 120, 300, 600}
+
+// serverMetrics are the metrics served on the matchmaking server's metrics endpoint
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	timeToMatch  *metrics.HistogramVec
+	allocations  *metrics.CounterVec
+	authFailures *metrics.CounterVec
+	gameResults  *metrics.CounterVec
+}
+
+func newServerMetrics(sms *SimpleMatchmakingServer) (m *serverMetrics) {
+	r := metrics.NewRegistry()
+	m = &serverMetrics{
+		registry: r,
+		timeToMatch: r.NewHistogramVec(
+			"sgs_matchmaking_time_to_match_seconds",
+			"Time from a ticket being queued until its match is ready to join.",
+			TimeToMatchBuckets,
+			"region",
+		),
+		allocations: r.NewCounterVec(
+			"sgs_matchmaking_allocations_total",
+			"Attempts to place formed matches on a game server, by result.",
+			"result",
+		),
+		authFailures: r.NewCounterVec(
+			"sgs_matchmaking_auth_failures_total",
+			"Requests rejected by a route's auth policy, by reason.",
+			"reason",
+		),
+		gameResults: r.NewCounterVec(
+			"sgs_matchmaking_game_results_total",
+			"Game results reported by game servers, by game type and end reason.",
+			"game_type", "end_reason",
+		),
+	}
+	r.NewGaugeFunc("sgs_matchmaking_queue_length", "Tickets waiting in the matchmaking queue.", func() float64 {
+		return float64(sms.strategy.QueueLength())
+	})
+	r.NewGaugeFunc("sgs_matchmaking_game_servers", "Game servers registered with the matchmaking server.", func() float64 {
+		sms.gameServersMutex.Lock()
+		defer sms.gameServersMutex.Unlock()
+		return float64(len(sms.gameServers))
+	})
+	return
+}
+
+// observeMatched records how long each ticket in the match waited to be matched
+func (m *serverMetrics) observeMatched(match *strategy.Match) {
+	for _, ticket := range match.Tickets {
+		m.timeToMatch.WithLabelValues(ticket.Region).Observe(time.Since(ticket.EnqueuedAt).Seconds())
+	}
+}
+
+func (m *serverMetrics) observeAuthFailure(err error) {
+	m.authFailures.WithLabelValues(auth.FailureReason(err)).Inc()
+}
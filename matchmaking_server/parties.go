@@ -0,0 +1,454 @@
+package matchmaking
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_MAX_PARTY_SIZE = 4
+	// PARTY_INVITE_TTL_S is how long an invite to a party can be accepted
+	PARTY_INVITE_TTL_S = 300
+)
+
+var (
+	ErrPartyNotFound        = errors.New("party not found")
+	ErrPlayerNotInParty     = errors.New("player is not in a party")
+	ErrPlayerAlreadyInParty = errors.New("player is already in a party")
+	ErrNotPartyLeader       = errors.New("only the party leader can do this")
+	ErrPartyFull            = errors.New("party is full")
+	ErrPartyInviteNotFound  = errors.New("no pending invite to the party")
+	ErrPartyInviteInvalid   = errors.New("players can't invite themselves")
+	ErrPartyQueued          = errors.New("party is queued for matchmaking, cancel matchmaking first")
+	ErrPartyChanged         = errors.New("party changed while it was being queued, try again")
+)
+
+// Party is a group of players that queue for matchmaking together
+//
+// The leader queues the whole party on a single ticket, with the average rating of its members,
+// so the party is always matched into the same game. The party can't change while it is queued
+type Party struct {
+	ID       string `json:"partyID"`
+	LeaderID string `json:"leaderID"`
+	// MemberIDs includes the leader, in the order players joined
+	MemberIDs []string  `json:"memberIDs"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// invites maps invited players to when their invite expires
+	invites map[string]time.Time
+}
+
+// copy returns a copy of the party that is safe to use without holding partiesMutex
+func (p *Party) copy() (party Party) {
+	party = *p
+	party.MemberIDs = append([]string(nil), p.MemberIDs...)
+	party.invites = nil
+	return
+}
+
+// maxPartySize returns the largest party players can form
+func (sms *SimpleMatchmakingServer) maxPartySize() int {
+	if sms.config.MaxPartySize <= 0 {
+		return DEFAULT_MAX_PARTY_SIZE
+	}
+	return sms.config.MaxPartySize
+}
+
+// playerParty returns the party the player is in, partiesMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) playerParty(playerID string) (party *Party, err error) {
+	partyID, exists := sms.playerParties[playerID]
+	if !exists {
+		err = ErrPlayerNotInParty
+		return
+	}
+	party = sms.parties[partyID]
+	return
+}
+
+// leaderParty returns the party the player leads, partiesMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) leaderParty(playerID string) (party *Party, err error) {
+	if party, err = sms.playerParty(playerID); err != nil {
+		return
+	}
+	if party.LeaderID != playerID {
+		party = nil
+		err = ErrNotPartyLeader
+	}
+	return
+}
+
+// partyQueued returns true if the party has a ticket that hasn't been matched and allocated yet
+func (sms *SimpleMatchmakingServer) partyQueued(party *Party) bool {
+	return sms.isQueued(party.LeaderID)
+}
+
+// addInvite records that the party invited the player, partiesMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) addInvite(party *Party, playerID string, expiresAt time.Time) {
+	party.invites[playerID] = expiresAt
+	if sms.partyInvites[playerID] == nil {
+		sms.partyInvites[playerID] = make(map[string]bool)
+	}
+	sms.partyInvites[playerID][party.ID] = true
+}
+
+// removeInvite removes the party's invite to the player, partiesMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) removeInvite(party *Party, playerID string) {
+	delete(party.invites, playerID)
+	delete(sms.partyInvites[playerID], party.ID)
+	if len(sms.partyInvites[playerID]) == 0 {
+		delete(sms.partyInvites, playerID)
+	}
+}
+
+// deleteParty removes the party along with its pending invites, partiesMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) deleteParty(party *Party) {
+	for playerID := range party.invites {
+		sms.removeInvite(party, playerID)
+	}
+	delete(sms.parties, party.ID)
+}
+
+// createParty creates a party led by the player
+func (sms *SimpleMatchmakingServer) createParty(playerID string, now time.Time) (party Party, err error) {
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	if _, exists := sms.playerParties[playerID]; exists {
+		err = ErrPlayerAlreadyInParty
+		return
+	}
+	p := &Party{
+		ID:        uuid.New().String(),
+		LeaderID:  playerID,
+		MemberIDs: []string{playerID},
+		CreatedAt: now,
+		invites:   make(map[string]time.Time),
+	}
+	sms.parties[p.ID] = p
+	sms.playerParties[playerID] = p.ID
+
+	sms.logger.WithFields(logrus.Fields{
+		"partyID":  p.ID,
+		"leaderID": playerID,
+	}).Info("party created")
+	party = p.copy()
+	return
+}
+
+// inviteToParty invites a player to the party led by leaderID
+func (sms *SimpleMatchmakingServer) inviteToParty(leaderID string, playerID string, now time.Time) (err error) {
+	if leaderID == playerID {
+		err = ErrPartyInviteInvalid
+		return
+	}
+
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	var party *Party
+	if party, err = sms.leaderParty(leaderID); err != nil {
+		return
+	}
+	if len(party.MemberIDs) >= sms.maxPartySize() {
+		err = ErrPartyFull
+		return
+	}
+	for invitedID, expiresAt := range party.invites {
+		if now.After(expiresAt) {
+			sms.removeInvite(party, invitedID)
+		}
+	}
+	sms.addInvite(party, playerID, now.Add(PARTY_INVITE_TTL_S*time.Second))
+
+	sms.logger.WithFields(logrus.Fields{
+		"partyID":  party.ID,
+		"playerID": playerID,
+	}).Info("player invited to party")
+	return
+}
+
+// acceptPartyInvite adds the player to the party they were invited to
+func (sms *SimpleMatchmakingServer) acceptPartyInvite(playerID string, partyID string, now time.Time) (party Party, err error) {
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	p, exists := sms.parties[partyID]
+	if !exists {
+		err = ErrPartyNotFound
+		return
+	}
+	if expiresAt, invited := p.invites[playerID]; !invited || now.After(expiresAt) {
+		err = ErrPartyInviteNotFound
+		return
+	}
+	if _, inParty := sms.playerParties[playerID]; inParty {
+		err = ErrPlayerAlreadyInParty
+		return
+	}
+	if len(p.MemberIDs) >= sms.maxPartySize() {
+		err = ErrPartyFull
+		return
+	}
+	if sms.partyQueued(p) {
+		err = ErrPartyQueued
+		return
+	}
+	// Players that are queued on their own can't join until they leave the queue
+	if sms.isQueued(playerID) {
+		err = ErrPlayerAlreadyQueued
+		return
+	}
+
+	sms.removeInvite(p, playerID)
+	p.MemberIDs = append(p.MemberIDs, playerID)
+	sms.playerParties[playerID] = p.ID
+
+	sms.logger.WithFields(logrus.Fields{
+		"partyID":  p.ID,
+		"playerID": playerID,
+	}).Info("player joined party")
+	party = p.copy()
+	return
+}
+
+// leaveParty removes the player from their party
+//
+// If the leader leaves, the longest standing member becomes the leader. The party is disbanded when its last member leaves
+func (sms *SimpleMatchmakingServer) leaveParty(playerID string) (err error) {
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	var party *Party
+	if party, err = sms.playerParty(playerID); err != nil {
+		return
+	}
+	if sms.partyQueued(party) {
+		err = ErrPartyQueued
+		return
+	}
+
+	delete(sms.playerParties, playerID)
+	for i, memberID := range party.MemberIDs {
+		if memberID == playerID {
+			party.MemberIDs = append(party.MemberIDs[:i], party.MemberIDs[i+1:]...)
+			break
+		}
+	}
+	if len(party.MemberIDs) == 0 {
+		sms.deleteParty(party)
+	} else if party.LeaderID == playerID {
+		party.LeaderID = party.MemberIDs[0]
+	}
+
+	sms.logger.WithFields(logrus.Fields{
+		"partyID":  party.ID,
+		"playerID": playerID,
+		"leaderID": party.LeaderID,
+	}).Info("player left party")
+	return
+}
+
+// disbandParty removes every member from the party led by leaderID
+func (sms *SimpleMatchmakingServer) disbandParty(leaderID string) (err error) {
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	var party *Party
+	if party, err = sms.leaderParty(leaderID); err != nil {
+		return
+	}
+	if sms.partyQueued(party) {
+		err = ErrPartyQueued
+		return
+	}
+	for _, memberID := range party.MemberIDs {
+		delete(sms.playerParties, memberID)
+	}
+	sms.deleteParty(party)
+
+	sms.logger.WithField("partyID", party.ID).Info("party disbanded")
+	return
+}
+
+// getParty returns the player's party along with the IDs of the parties that have invited them
+func (sms *SimpleMatchmakingServer) getParty(playerID string, now time.Time) (party *Party, invites []string) {
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	if p, err := sms.playerParty(playerID); err == nil {
+		copied := p.copy()
+		party = &copied
+	}
+	for partyID := range sms.partyInvites[playerID] {
+		p := sms.parties[partyID]
+		if now.After(p.invites[playerID]) {
+			sms.removeInvite(p, playerID)
+			continue
+		}
+		invites = append(invites, partyID)
+	}
+	sort.Strings(invites)
+	return
+}
+
+// partyRating returns the rating a group of players is matched with, the average of their ratings
+func partyRating(players []*MatchmakingPlayer) int {
+	total := 0
+	for _, player := range players {
+		total += player.Rating
+	}
+	return total / len(players)
+}
+
+// PartyInviteRequest is the body of /party/invite
+type PartyInviteRequest struct {
+	PlayerID string `json:"playerID"`
+}
+
+// PartyAcceptRequest is the body of /party/accept
+type PartyAcceptRequest struct {
+	PartyID string `json:"partyID"`
+}
+
+// PartyStatusResponse is the response to /party/status, Invites are the IDs of parties the player can join
+type PartyStatusResponse struct {
+	Party   *Party   `json:"party,omitempty"`
+	Invites []string `json:"invites,omitempty"`
+}
+
+// writePartyError responds with the status code for an error from a party operation
+func writePartyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrPartyNotFound), errors.Is(err, ErrPlayerNotInParty), errors.Is(err, ErrPartyInviteNotFound):
+		common.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrNotPartyLeader):
+		common.WriteErrorResponse(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrPlayerAlreadyInParty), errors.Is(err, ErrPartyFull),
+		errors.Is(err, ErrPartyQueued), errors.Is(err, ErrPlayerAlreadyQueued):
+		common.WriteErrorResponse(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrPartyInviteInvalid):
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	default:
+		common.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// createPartyHandler creates a party led by the player
+func (sms *SimpleMatchmakingServer) createPartyHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var party Party
+	if party, err = sms.createParty(playerID, time.Now()); err != nil {
+		writePartyError(w, err)
+		return
+	}
+	common.WriteJSONResponse(w, http.StatusCreated, party)
+}
+
+// inviteToPartyHandler lets the party leader invite a player, who joins with /party/accept
+func (sms *SimpleMatchmakingServer) inviteToPartyHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var statusCode int
+	var req PartyInviteRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+	if req.PlayerID == "" {
+		common.WriteErrorResponse(w, http.StatusBadRequest, "playerID field is missing")
+		return
+	}
+
+	if err = sms.inviteToParty(playerID, req.PlayerID, time.Now()); err != nil {
+		writePartyError(w, err)
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+// acceptPartyInviteHandler adds the player to a party that invited them
+func (sms *SimpleMatchmakingServer) acceptPartyInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var statusCode int
+	var req PartyAcceptRequest
+	if statusCode, err = common.UnmarshalJSONRequestBody(w, r, &req); err != nil {
+		common.WriteErrorResponse(w, statusCode, err.Error())
+		return
+	}
+
+	var party Party
+	if party, err = sms.acceptPartyInvite(playerID, req.PartyID, time.Now()); err != nil {
+		writePartyError(w, err)
+		return
+	}
+	common.WriteJSONResponse(w, http.StatusOK, party)
+}
+
+// leavePartyHandler removes the player from their party
+func (sms *SimpleMatchmakingServer) leavePartyHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = sms.leaveParty(playerID); err != nil {
+		writePartyError(w, err)
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+// disbandPartyHandler lets the party leader remove every member from the party
+func (sms *SimpleMatchmakingServer) disbandPartyHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = sms.disbandParty(playerID); err != nil {
+		writePartyError(w, err)
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, common.ResponseData{})
+}
+
+// partyStatusHandler returns the player's party and their pending invites
+func (sms *SimpleMatchmakingServer) partyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var playerID string
+	if playerID, err = sms.authProvider.GetUIDFromRequest(r); err != nil {
+		common.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var response PartyStatusResponse
+	response.Party, response.Invites = sms.getParty(playerID, time.Now())
+	common.WriteJSONResponse(w, http.StatusOK, response)
+}
@@ -1,21 +1,42 @@
 package matchmaking
 
+import (
+	"math"
+
+	"github.com/gunnermanx/simplegameserver/datastore/model"
+	"github.com/pkg/errors"
+)
+
 type MatchmakingPlayer struct {
+	ID     string
 	Rating int
 }
 
+// GetPlayer returns the cached matchmaking data for a player, loading it from the datastore if needed
 func (sms *SimpleMatchmakingServer) GetPlayer(
 	playerID string,
 ) (player *MatchmakingPlayer, err error) {
 	var exists bool
 	sms.playersMutex.Lock()
-	if player, exists = sms.players[playerID]; exists {
-
-		// get the player from the db
+	player, exists = sms.players[playerID]
+	sms.playersMutex.Unlock()
+	if exists {
+		return
+	}
 
-		player = &MatchmakingPlayer{}
+	// get the player from the db
+	var data model.MatchmakingData
+	if data, err = sms.datastore.FindMatchmakingData(playerID); err != nil {
+		err = errors.Wrap(err, "failed loading matchmaking data")
+		return
+	}
+	player = &MatchmakingPlayer{
+		ID:     playerID,
+		Rating: int(math.Round(data.Rating)),
 	}
-	sms.playersMutex.Unlock()
 
+	sms.playersMutex.Lock()
+	sms.players[playerID] = player
+	sms.playersMutex.Unlock()
 	return
 }
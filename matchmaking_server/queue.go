@@ -0,0 +1,630 @@
+package matchmaking
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/strategy"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_MATCHMAKING_INTERVAL_MS = 1000
+	// MATCH_RESULT_TTL_S is how long a formed match is kept for clients to fetch
+	MATCH_RESULT_TTL_S = 60
+	// WAIT_ESTIMATE_WEIGHT is how much each matched ticket's wait moves the estimated wait
+	WAIT_ESTIMATE_WEIGHT = 0.1
+	// REQUEUE_BACKOFF_BASE_MS is how long a ticket is held out of the queue after its match fails to allocate,
+	// doubling with each failure up to REQUEUE_BACKOFF_MAX_MS
+	REQUEUE_BACKOFF_BASE_MS = 1000
+	REQUEUE_BACKOFF_MAX_MS  = 30000
+	// ALLOCATION_FAILURE_LOG_INTERVAL_S is how often failed allocations are logged, the rest are counted as suppressed
+	ALLOCATION_FAILURE_LOG_INTERVAL_S = 10
+)
+
+var (
+	ErrPlayerAlreadyQueued = errors.New("player is already queued")
+	ErrPlayerNotQueued     = errors.New("player is not queued")
+	ErrUnknownRegion       = errors.New("no game server reports the region")
+	ErrTicketWatched       = errors.New("ticket is followed by another queue session")
+)
+
+// queueEntry tracks a player's ticket from the moment it is queued until its join ticket is delivered
+//
+// Once matched, the entry waits for a game server to be allocated for the match before
+// it is given a join ticket
+type queueEntry struct {
+	ticket     *strategy.Ticket
+	match      *strategy.Match
+	joinTicket *JoinTicket
+	matchedAt  time.Time
+	// position is the ticket's place in the queue as of the last match forming pass, longest waiting first
+	position int
+	// session is set if the player queued the ticket through a queue session, the ticket is dequeued
+	// once the player's last session closes
+	session bool
+}
+
+// watchQueue returns a channel that is signalled whenever the player's queue entry changes and after every
+// match forming pass, it follows the player across tickets until unwatch is called
+func (sms *SimpleMatchmakingServer) watchQueue(playerID string) (updates <-chan struct{}, unwatch func()) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if sms.queueWatchers[playerID] == nil {
+		sms.queueWatchers[playerID] = make(map[chan struct{}]bool)
+	}
+	sms.queueWatchers[playerID][ch] = true
+
+	updates = ch
+	unwatch = func() {
+		sms.queueMutex.Lock()
+		defer sms.queueMutex.Unlock()
+		delete(sms.queueWatchers[playerID], ch)
+		if len(sms.queueWatchers[playerID]) == 0 {
+			delete(sms.queueWatchers, playerID)
+		}
+	}
+	return
+}
+
+// notify signals the player's watchers without blocking, a pending signal already covers the change
+// The queueMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) notify(playerID string) {
+	for ch := range sms.queueWatchers[playerID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// requeueBackoff tracks the failed allocations of a ticket until its match is allocated or it leaves the queue
+type requeueBackoff struct {
+	ticket   *strategy.Ticket
+	failures int
+	// retryAt is when the ticket goes back in the queue, zero once it is back in the queue
+	retryAt time.Time
+}
+
+// requeueDelay returns how long a ticket is held out of the queue after its match failed to allocate failures times
+func requeueDelay(failures int) time.Duration {
+	delay := REQUEUE_BACKOFF_BASE_MS * time.Millisecond
+	for i := 1; i < failures && delay < REQUEUE_BACKOFF_MAX_MS*time.Millisecond; i++ {
+		delay *= 2
+	}
+	if delay > REQUEUE_BACKOFF_MAX_MS*time.Millisecond {
+		delay = REQUEUE_BACKOFF_MAX_MS * time.Millisecond
+	}
+	return delay
+}
+
+// throttledLog limits how often a repeated message is logged
+type throttledLog struct {
+	mutex      sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+func newThrottledLog(interval time.Duration) *throttledLog {
+	return &throttledLog{
+		interval: interval,
+	}
+}
+
+// allow returns true if the message can be logged now,
+// along with the number of times it was suppressed since it was last logged
+func (l *throttledLog) allow(now time.Time) (ok bool, suppressed int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.last.IsZero() && now.Sub(l.last) < l.interval {
+		l.suppressed++
+		return
+	}
+	ok, suppressed = true, l.suppressed
+	l.last = now
+	l.suppressed = 0
+	return
+}
+
+// JoinTicket tells a matched player which game to join
+//
+// Token is the signed ticket the game server requires to join the game, it is only set
+// if a join ticket secret is configured
+type JoinTicket struct {
+	MatchID string `json:"matchID"`
+	GameID  string `json:"gameID,omitempty"`
+	Address string `json:"address,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// queuePlayer queues the player for matchmaking, or their whole party if they are a party leader
+// Party members other than the leader can't queue, and the region must be one reported by the fleet
+func (sms *SimpleMatchmakingServer) queuePlayer(playerID string, region string) (ticket *strategy.Ticket, err error) {
+	if !sms.knownRegion(region, time.Now()) {
+		err = ErrUnknownRegion
+		return
+	}
+
+	// Load the members before locking the parties, GetPlayer may have to go to the datastore
+	sms.partiesMutex.Lock()
+	memberIDs, err := sms.queueMemberIDs(playerID)
+	sms.partiesMutex.Unlock()
+	if err != nil {
+		return
+	}
+	players := make([]*MatchmakingPlayer, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		var player *MatchmakingPlayer
+		if player, err = sms.GetPlayer(memberID); err != nil {
+			return
+		}
+		players = append(players, player)
+	}
+
+	// Hold the party so it can't change until its ticket is queued
+	sms.partiesMutex.Lock()
+	defer sms.partiesMutex.Unlock()
+
+	var current []string
+	if current, err = sms.queueMemberIDs(playerID); err != nil {
+		return
+	}
+	if !sameMembers(memberIDs, current) {
+		err = ErrPartyChanged
+		return
+	}
+	return sms.enqueue(players, region)
+}
+
+// queueMemberIDs returns the players that queue on playerID's ticket, partiesMutex must be held by the caller
+// Players that aren't in a party queue on their own, party members have to be queued by their leader
+func (sms *SimpleMatchmakingServer) queueMemberIDs(playerID string) (memberIDs []string, err error) {
+	var party *Party
+	if party, err = sms.leaderParty(playerID); err == nil {
+		memberIDs = append([]string(nil), party.MemberIDs...)
+	} else if errors.Is(err, ErrPlayerNotInParty) {
+		memberIDs = []string{playerID}
+		err = nil
+	}
+	return
+}
+
+// sameMembers returns true if both lists hold the same players in the same order
+func sameMembers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// enqueue creates a single ticket for the players and adds it to the strategy's queue
+// The players are only matched with players queued for the same region, an empty region matches anywhere
+func (sms *SimpleMatchmakingServer) enqueue(players []*MatchmakingPlayer, region string) (ticket *strategy.Ticket, err error) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+
+	playerIDs := make([]string, 0, len(players))
+	for _, player := range players {
+		if entry, exists := sms.queue[player.ID]; exists && entry.joinTicket == nil {
+			err = ErrPlayerAlreadyQueued
+			return
+		}
+		playerIDs = append(playerIDs, player.ID)
+	}
+
+	ticket = &strategy.Ticket{
+		ID:         uuid.New().String(),
+		PlayerIDs:  playerIDs,
+		Rating:     partyRating(players),
+		Region:     region,
+		EnqueuedAt: time.Now(),
+	}
+	if err = sms.strategy.Enqueue(ticket); err != nil {
+		return
+	}
+	position := sms.strategy.QueueLength()
+	for _, playerID := range playerIDs {
+		sms.queue[playerID] = &queueEntry{
+			ticket:   ticket,
+			position: position,
+		}
+		sms.notify(playerID)
+	}
+
+	sms.logger.WithFields(logrus.Fields{
+		"playerIDs": playerIDs,
+		"ticketID":  ticket.ID,
+		"rating":    ticket.Rating,
+		"region":    region,
+	}).Info("ticket queued for matchmaking")
+	return
+}
+
+// dequeue removes the player's ticket from the queue if it hasn't been matched yet
+// Any player on a party's ticket can take the whole party out of the queue
+func (sms *SimpleMatchmakingServer) dequeue(playerID string) (err error) {
+	return sms.dequeueTicket(playerID, "")
+}
+
+// dequeueTicket removes the player's ticket like dequeue, if ticketID is set the ticket is only removed
+// if the player is still queued with it
+func (sms *SimpleMatchmakingServer) dequeueTicket(playerID string, ticketID string) (err error) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+	return sms.removeTicket(playerID, ticketID)
+}
+
+// dequeueUnwatched removes the player's ticket like dequeueTicket, unless a queue session is still following it
+// This lets a player reopen a dropped session before the old session notices it was closed
+func (sms *SimpleMatchmakingServer) dequeueUnwatched(playerID string, ticketID string) (err error) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+	if len(sms.queueWatchers[playerID]) > 0 {
+		err = ErrTicketWatched
+		return
+	}
+	return sms.removeTicket(playerID, ticketID)
+}
+
+// markSessionTicket records that the player queued the ticket through a queue session, see queueEntry.session
+func (sms *SimpleMatchmakingServer) markSessionTicket(playerID string, ticketID string) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+	if entry, exists := sms.queue[playerID]; exists && entry.ticket.ID == ticketID {
+		entry.session = true
+	}
+}
+
+// removeTicket removes the player's ticket as described by dequeueTicket
+// The queueMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) removeTicket(playerID string, ticketID string) (err error) {
+	entry, exists := sms.queue[playerID]
+	if !exists || entry.match != nil || (ticketID != "" && entry.ticket.ID != ticketID) {
+		err = ErrPlayerNotQueued
+		return
+	}
+	// A ticket held out of the queue after a failed allocation isn't in the strategy's queue
+	if b, backingOff := sms.backoffs[entry.ticket.ID]; !backingOff || b.retryAt.IsZero() {
+		if err = sms.strategy.Dequeue(entry.ticket.ID); err != nil {
+			return
+		}
+	}
+	delete(sms.backoffs, entry.ticket.ID)
+	for _, ticketPlayerID := range entry.ticket.PlayerIDs {
+		if _, exists := sms.queue[ticketPlayerID]; exists {
+			sms.notify(ticketPlayerID)
+			delete(sms.queue, ticketPlayerID)
+		}
+	}
+
+	sms.logger.WithFields(logrus.Fields{
+		"playerID": playerID,
+		"ticketID": entry.ticket.ID,
+	}).Info("ticket left matchmaking queue")
+	return
+}
+
+// isQueued returns true if the player has a ticket that hasn't been matched and allocated yet
+func (sms *SimpleMatchmakingServer) isQueued(playerID string) bool {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+	entry, exists := sms.queue[playerID]
+	return exists && entry.joinTicket == nil
+}
+
+// getQueueEntry returns a copy of the player's queue entry
+func (sms *SimpleMatchmakingServer) getQueueEntry(playerID string) (entry queueEntry, err error) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+
+	e, exists := sms.queue[playerID]
+	if !exists {
+		err = ErrPlayerNotQueued
+		return
+	}
+	entry = *e
+	return
+}
+
+// removeMatchedEntry removes the player's entry once its join ticket has been delivered
+// The entry is only removed if it still holds the join ticket for ticketID, the player may have queued again since
+func (sms *SimpleMatchmakingServer) removeMatchedEntry(playerID string, ticketID string) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+
+	if entry, exists := sms.queue[playerID]; exists && entry.joinTicket != nil && entry.ticket.ID == ticketID {
+		delete(sms.queue, playerID)
+	}
+}
+
+// queueStatus returns the status of the player's ticket
+// A matched entry is kept until the caller has delivered its join ticket and calls removeMatchedEntry
+func (sms *SimpleMatchmakingServer) queueStatus(playerID string, now time.Time) (status MatchStatusResponse, err error) {
+	var entry queueEntry
+	if entry, err = sms.getQueueEntry(playerID); err != nil {
+		return
+	}
+
+	status = MatchStatusResponse{
+		TicketID:  entry.ticket.ID,
+		Status:    QUEUE_STATUS_QUEUED,
+		Position:  entry.position,
+		ElapsedMS: now.Sub(entry.ticket.EnqueuedAt).Milliseconds(),
+	}
+	sms.queueMutex.Lock()
+	status.EstimatedWaitMS = sms.waitEstimate.Milliseconds()
+	sms.queueMutex.Unlock()
+
+	if entry.match != nil {
+		status.Status = QUEUE_STATUS_ALLOCATING
+		status.MatchID = entry.match.ID
+		status.PlayerIDs = entry.match.PlayerIDs()
+		status.Teams = entry.match.Teams
+	} else if reporter, ok := sms.strategy.(strategy.SearchWindowReporter); ok {
+		status.SearchWindow = reporter.SearchWindow(entry.ticket, now)
+	}
+	if entry.joinTicket != nil {
+		status.Status = QUEUE_STATUS_MATCHED
+		status.JoinTicket = entry.joinTicket
+	}
+	return
+}
+
+// runMatchmaking periodically forms matches until the context is cancelled
+func (sms *SimpleMatchmakingServer) runMatchmaking(ctx context.Context) {
+	intervalMS := sms.config.MatchmakingIntervalMS
+	if intervalMS <= 0 {
+		intervalMS = DEFAULT_MATCHMAKING_INTERVAL_MS
+	}
+	ticker := time.NewTicker(time.Duration(intervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sms.formMatches(now)
+		}
+	}
+}
+
+// formMatches runs a match forming pass and records the matches for the waiting players
+func (sms *SimpleMatchmakingServer) formMatches(now time.Time) {
+	// Hold the queue while forming matches so tickets can't be dequeued mid pass
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+
+	sms.requeueDue(now)
+	matches := sms.strategy.FormMatches(now)
+
+	for _, match := range matches {
+		for _, ticket := range match.Tickets {
+			sms.observeWait(now.Sub(ticket.EnqueuedAt))
+		}
+		for _, playerID := range match.PlayerIDs() {
+			if entry, exists := sms.queue[playerID]; exists {
+				entry.match = match
+			}
+		}
+		sms.logger.WithFields(logrus.Fields{
+			"matchID":   match.ID,
+			"playerIDs": match.PlayerIDs(),
+		}).Info("match formed")
+
+		go sms.allocateMatch(match)
+	}
+
+	// Drop join tickets that were never delivered
+	for playerID, entry := range sms.queue {
+		if entry.joinTicket != nil && now.Sub(entry.matchedAt) > MATCH_RESULT_TTL_S*time.Second {
+			delete(sms.queue, playerID)
+		}
+	}
+
+	sms.updatePositions()
+	for playerID := range sms.queue {
+		sms.notify(playerID)
+	}
+}
+
+// updatePositions numbers the tickets waiting to be matched, longest waiting first
+// The queueMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) updatePositions() {
+	var waiting []*strategy.Ticket
+	positions := make(map[string]int)
+	for _, entry := range sms.queue {
+		if _, seen := positions[entry.ticket.ID]; entry.match == nil && !seen {
+			positions[entry.ticket.ID] = 0
+			waiting = append(waiting, entry.ticket)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].EnqueuedAt.Before(waiting[j].EnqueuedAt)
+	})
+	for i, ticket := range waiting {
+		positions[ticket.ID] = i + 1
+	}
+	for _, entry := range sms.queue {
+		if entry.match == nil {
+			entry.position = positions[entry.ticket.ID]
+		} else {
+			entry.position = 0
+		}
+	}
+}
+
+// observeWait folds how long a matched ticket waited into the estimated wait
+// The queueMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) observeWait(wait time.Duration) {
+	if sms.waitEstimate == 0 {
+		sms.waitEstimate = wait
+		return
+	}
+	sms.waitEstimate += time.Duration(WAIT_ESTIMATE_WEIGHT * float64(wait-sms.waitEstimate))
+}
+
+// allocateMatch asks the least loaded game server in the match's region to create a game for the match
+// and hands each player a join ticket
+//
+// If no game server is available or the allocation fails, the match's tickets are put back in the queue
+// with their original queue time once their backoff passes, see requeueMatch
+func (sms *SimpleMatchmakingServer) allocateMatch(match *strategy.Match) {
+	joinTicket := &JoinTicket{
+		MatchID: match.ID,
+	}
+
+	if sms.allocator != nil {
+		gameServer, err := sms.chooseGameServer(match.Region(), time.Now())
+		if err != nil {
+			sms.logAllocationFailure(logrus.Fields{
+				"matchID": match.ID,
+				"region":  match.Region(),
+				"error":   err.Error(),
+			}, "failed placing match, requeueing tickets")
+			sms.metrics.allocations.WithLabelValues(ALLOCATION_RESULT_NO_SERVER).Inc()
+			sms.requeueMatch(match)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ALLOCATION_TIMEOUT_S*time.Second)
+		defer cancel()
+
+		resp, err := sms.allocator.Allocate(ctx, gameServer.URL, common.AllocateGameRequest{
+			MatchID:   match.ID,
+			GameType:  sms.config.GameType,
+			PlayerIDs: match.PlayerIDs(),
+			Teams:     match.Teams,
+		})
+		if err != nil {
+			sms.logAllocationFailure(logrus.Fields{
+				"matchID":  match.ID,
+				"serverID": gameServer.ServerID,
+				"error":    err.Error(),
+			}, "failed allocating game for match, requeueing tickets")
+			sms.metrics.allocations.WithLabelValues(ALLOCATION_RESULT_FAILED).Inc()
+			sms.requeueMatch(match)
+			return
+		}
+		joinTicket.GameID = resp.GameID
+		joinTicket.Address = resp.Address
+		if joinTicket.Address == "" {
+			joinTicket.Address = gameServer.Address
+		}
+		sms.metrics.allocations.WithLabelValues(ALLOCATION_RESULT_ALLOCATED).Inc()
+	}
+	sms.metrics.observeMatched(match)
+	sms.issueMatch(match, time.Now())
+
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+	for _, ticket := range match.Tickets {
+		delete(sms.backoffs, ticket.ID)
+	}
+	now := time.Now()
+	for _, playerID := range match.PlayerIDs() {
+		if entry, exists := sms.queue[playerID]; exists && entry.match == match {
+			playerTicket := *joinTicket
+			if sms.config.JoinTicketSecret != "" && playerTicket.GameID != "" {
+				var err error
+				if playerTicket.Token, err = common.SignJoinTicket(
+					sms.config.JoinTicketSecret,
+					playerID,
+					playerTicket.GameID,
+					common.JoinTicketTTL(sms.config.JoinTicketTTLS),
+					now,
+				); err != nil {
+					sms.logger.WithFields(logrus.Fields{
+						"matchID":  match.ID,
+						"playerID": playerID,
+						"error":    err.Error(),
+					}).Error("failed signing join ticket")
+				}
+			}
+			entry.joinTicket = &playerTicket
+			entry.matchedAt = now
+			sms.notify(playerID)
+		}
+	}
+
+	sms.logger.WithFields(logrus.Fields{
+		"matchID": match.ID,
+		"gameID":  joinTicket.GameID,
+		"address": joinTicket.Address,
+	}).Info("match is ready to join")
+}
+
+// logAllocationFailure logs a failed allocation at most once every ALLOCATION_FAILURE_LOG_INTERVAL_S,
+// failures repeat every pass while the fleet is out of capacity
+func (sms *SimpleMatchmakingServer) logAllocationFailure(fields logrus.Fields, msg string) {
+	ok, suppressed := sms.allocationFailureLog.allow(time.Now())
+	if !ok {
+		return
+	}
+	fields["suppressed"] = suppressed
+	sms.logger.WithFields(fields).Warn(msg)
+}
+
+// requeueMatch holds the tickets of a match that couldn't be allocated out of the queue until their backoff passes
+//
+// The players stay queued and can leave the queue in the meantime, requeueDue puts the tickets back
+func (sms *SimpleMatchmakingServer) requeueMatch(match *strategy.Match) {
+	sms.queueMutex.Lock()
+	defer sms.queueMutex.Unlock()
+	now := time.Now()
+	for _, ticket := range match.Tickets {
+		b, exists := sms.backoffs[ticket.ID]
+		if !exists {
+			b = &requeueBackoff{
+				ticket: ticket,
+			}
+			sms.backoffs[ticket.ID] = b
+		}
+		b.failures++
+		b.retryAt = now.Add(requeueDelay(b.failures))
+		for _, playerID := range ticket.PlayerIDs {
+			if entry, exists := sms.queue[playerID]; exists && entry.match == match {
+				entry.match = nil
+				sms.notify(playerID)
+			}
+		}
+	}
+	sms.updatePositions()
+}
+
+// requeueDue puts tickets back in the queue once their backoff has passed
+// The queueMutex must be held by the caller
+func (sms *SimpleMatchmakingServer) requeueDue(now time.Time) {
+	for ticketID, b := range sms.backoffs {
+		if b.retryAt.IsZero() || now.Before(b.retryAt) {
+			continue
+		}
+		b.retryAt = time.Time{}
+		if err := sms.strategy.Enqueue(b.ticket); err != nil {
+			sms.logger.WithFields(logrus.Fields{
+				"ticketID": ticketID,
+				"error":    err.Error(),
+			}).Error("failed requeueing ticket")
+			for _, playerID := range b.ticket.PlayerIDs {
+				if _, exists := sms.queue[playerID]; exists {
+					sms.notify(playerID)
+					delete(sms.queue, playerID)
+				}
+			}
+			delete(sms.backoffs, ticketID)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package rating
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DEFAULT_ELO_K_FACTOR       = 32
+	DEFAULT_ELO_INITIAL_RATING = 1500
+)
+
+// ELOConfig configures the ELO calculator, zero values are replaced by the defaults
+type ELOConfig struct {
+	// KFactor is the most a player's rating can change in a single match
+	KFactor float64
+	// InitialRating is the rating of new players
+	InitialRating float64
+}
+
+// ELO rates players with the ELO system
+//
+// A team is rated as the average rating of its players, and every player on a team gains
+// or loses the same amount. Against more than one other team, the change is averaged over
+// the opponents so a match is worth at most KFactor
+type ELO struct {
+	config ELOConfig
+}
+
+func NewELO(config ELOConfig) (elo *ELO) {
+	if config.KFactor <= 0 {
+		config.KFactor = DEFAULT_ELO_K_FACTOR
+	}
+	if config.InitialRating <= 0 {
+		config.InitialRating = DEFAULT_ELO_INITIAL_RATING
+	}
+	elo = &ELO{
+		config: config,
+	}
+	return
+}
+
+func (elo *ELO) Initial() Rating {
+	return Rating{Value: elo.config.InitialRating}
+}
+
+func (elo *ELO) Update(ratings map[string]Rating, teams []Team, now time.Time) (updated map[string]Rating, err error) {
+	if err = validate(ratings, teams); err != nil {
+		return
+	}
+
+	averages := make([]float64, len(teams))
+	for i, team := range teams {
+		for _, playerID := range team.PlayerIDs {
+			averages[i] += ratings[playerID].Value
+		}
+		averages[i] /= float64(len(team.PlayerIDs))
+	}
+
+	updated = make(map[string]Rating)
+	for i, team := range teams {
+		var change float64
+		for j, opponent := range teams {
+			if i == j {
+				continue
+			}
+			change += score(team.Rank, opponent.Rank) - elo.expected(averages[i], averages[j])
+		}
+		change *= elo.config.KFactor / float64(len(teams)-1)
+
+		for _, playerID := range team.PlayerIDs {
+			r := ratings[playerID]
+			r.Value += change
+			r.LastPlayedAt = now
+			updated[playerID] = r
+		}
+	}
+	return
+}
+
+// expected returns the expected score of a player rated a against a player rated b
+func (elo *ELO) expected(a, b float64) float64 {
+	return 1 / (1 + math.Pow(10, (b-a)/400))
+}
@@ -0,0 +1,76 @@
+package rating
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestELO(t *testing.T) {
+	now := time.Now()
+
+	t.Run("winner takes points from the loser", func(t *testing.T) {
+		elo := NewELO(ELOConfig{KFactor: 32})
+		updated, err := elo.Update(
+			map[string]Rating{"p1": {Value: 1500}, "p2": {Value: 1500}},
+			[]Team{{PlayerIDs: []string{"p1"}, Rank: 0}, {PlayerIDs: []string{"p2"}, Rank: 1}},
+			now,
+		)
+		require.NoError(t, err)
+		require.InDelta(t, 1516, updated["p1"].Value, 0.001)
+		require.InDelta(t, 1484, updated["p2"].Value, 0.001)
+		require.Equal(t, now, updated["p1"].LastPlayedAt)
+	})
+
+	t.Run("draws move ratings towards each other", func(t *testing.T) {
+		elo := NewELO(ELOConfig{KFactor: 32})
+		updated, err := elo.Update(
+			map[string]Rating{"p1": {Value: 1600}, "p2": {Value: 1400}},
+			[]Team{{PlayerIDs: []string{"p1"}}, {PlayerIDs: []string{"p2"}}},
+			now,
+		)
+		require.NoError(t, err)
+		require.InDelta(t, 1591.69, updated["p1"].Value, 0.01)
+		require.InDelta(t, 1408.31, updated["p2"].Value, 0.01)
+	})
+
+	t.Run("teams are rated by their average", func(t *testing.T) {
+		elo := NewELO(ELOConfig{KFactor: 32})
+		updated, err := elo.Update(
+			map[string]Rating{"p1": {Value: 1400}, "p2": {Value: 1600}, "p3": {Value: 1500}, "p4": {Value: 1500}},
+			[]Team{{PlayerIDs: []string{"p1", "p2"}, Rank: 0}, {PlayerIDs: []string{"p3", "p4"}, Rank: 1}},
+			now,
+		)
+		require.NoError(t, err)
+		require.InDelta(t, 1416, updated["p1"].Value, 0.001)
+		require.InDelta(t, 1616, updated["p2"].Value, 0.001)
+		require.InDelta(t, 1484, updated["p3"].Value, 0.001)
+		require.InDelta(t, 1484, updated["p4"].Value, 0.001)
+	})
+
+	t.Run("free for all is averaged over opponents", func(t *testing.T) {
+		elo := NewELO(ELOConfig{KFactor: 32})
+		updated, err := elo.Update(
+			map[string]Rating{"p1": {Value: 1500}, "p2": {Value: 1500}, "p3": {Value: 1500}},
+			[]Team{{PlayerIDs: []string{"p1"}, Rank: 0}, {PlayerIDs: []string{"p2"}, Rank: 1}, {PlayerIDs: []string{"p3"}, Rank: 2}},
+			now,
+		)
+		require.NoError(t, err)
+		require.InDelta(t, 1516, updated["p1"].Value, 0.001)
+		require.InDelta(t, 1500, updated["p2"].Value, 0.001)
+		require.InDelta(t, 1484, updated["p3"].Value, 0.001)
+	})
+
+	t.Run("invalid matches", func(t *testing.T) {
+		elo := NewELO(ELOConfig{})
+		require.Equal(t, Rating{Value: DEFAULT_ELO_INITIAL_RATING}, elo.Initial())
+
+		_, err := elo.Update(map[string]Rating{"p1": elo.Initial()}, []Team{{PlayerIDs: []string{"p1"}}}, now)
+		require.ErrorIs(t, err, ErrNotEnoughTeams)
+		_, err = elo.Update(map[string]Rating{"p1": elo.Initial()}, []Team{{PlayerIDs: []string{"p1"}}, {}}, now)
+		require.ErrorIs(t, err, ErrEmptyTeam)
+		_, err = elo.Update(map[string]Rating{"p1": elo.Initial()}, []Team{{PlayerIDs: []string{"p1"}}, {PlayerIDs: []string{"p2"}}}, now)
+		require.ErrorIs(t, err, ErrMissingRating)
+	})
+}
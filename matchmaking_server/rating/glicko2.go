@@ -0,0 +1,216 @@
+package rating
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DEFAULT_GLICKO2_INITIAL_RATING     = 1500
+	DEFAULT_GLICKO2_INITIAL_DEVIATION  = 350
+	DEFAULT_GLICKO2_INITIAL_VOLATILITY = 0.06
+	DEFAULT_GLICKO2_TAU                = 0.5
+	DEFAULT_GLICKO2_RATING_PERIOD      = 24 * time.Hour
+
+	// GLICKO2_SCALE and GLICKO2_CENTER convert between the Glicko scale ratings are reported on and the Glicko-2 scale
+	GLICKO2_SCALE  = 173.7178
+	GLICKO2_CENTER = 1500
+	// GLICKO2_CONVERGENCE is the tolerance of the volatility iteration
+	GLICKO2_CONVERGENCE = 0.000001
+)
+
+// Glicko2Config configures the Glicko-2 calculator, zero values are replaced by the defaults
+type Glicko2Config struct {
+	InitialRating     float64
+	InitialDeviation  float64
+	InitialVolatility float64
+	// Tau constrains how quickly volatility changes, values between 0.3 and 1.2 are reasonable
+	Tau float64
+	// RatingPeriod is how long a player has to be inactive for their deviation to grow by their volatility once
+	RatingPeriod time.Duration
+	// MaxDeviation caps how uncertain an inactive player's rating can become, defaults to InitialDeviation
+	MaxDeviation float64
+}
+
+// Glicko2 rates players with the Glicko-2 system
+//
+// Each match is rated as its own rating period. Ratings are reported on the Glicko scale, like ELO
+// ratings, and the deviation of players who haven't played grows with the time since their last match.
+// Players are rated individually against each opposing team, which is treated as a single
+// opponent with the team's average rating and deviation
+type Glicko2 struct {
+	config Glicko2Config
+}
+
+// glicko2Opponent is an opponent on the Glicko-2 scale along with the player's score against them
+type glicko2Opponent struct {
+	mu    float64
+	phi   float64
+	score float64
+}
+
+func NewGlicko2(config Glicko2Config) (g *Glicko2) {
+	if config.InitialRating <= 0 {
+		config.InitialRating = DEFAULT_GLICKO2_INITIAL_RATING
+	}
+	if config.InitialDeviation <= 0 {
+		config.InitialDeviation = DEFAULT_GLICKO2_INITIAL_DEVIATION
+	}
+	if config.InitialVolatility <= 0 {
+		config.InitialVolatility = DEFAULT_GLICKO2_INITIAL_VOLATILITY
+	}
+	if config.Tau <= 0 {
+		config.Tau = DEFAULT_GLICKO2_TAU
+	}
+	if config.RatingPeriod <= 0 {
+		config.RatingPeriod = DEFAULT_GLICKO2_RATING_PERIOD
+	}
+	if config.MaxDeviation <= 0 {
+		config.MaxDeviation = config.InitialDeviation
+	}
+	g = &Glicko2{
+		config: config,
+	}
+	return
+}
+
+func (g *Glicko2) Initial() Rating {
+	return Rating{
+		Value:      g.config.InitialRating,
+		Deviation:  g.config.InitialDeviation,
+		Volatility: g.config.InitialVolatility,
+	}
+}
+
+// Decay returns the rating with its deviation grown for the rating periods since the player last played
+func (g *Glicko2) Decay(r Rating, now time.Time) Rating {
+	if r.LastPlayedAt.IsZero() || !now.After(r.LastPlayedAt) {
+		return r
+	}
+	periods := float64(now.Sub(r.LastPlayedAt)) / float64(g.config.RatingPeriod)
+	phi := r.Deviation / GLICKO2_SCALE
+	phi = math.Sqrt(phi*phi + periods*r.Volatility*r.Volatility)
+	r.Deviation = math.Min(phi*GLICKO2_SCALE, g.config.MaxDeviation)
+	return r
+}
+
+func (g *Glicko2) Update(ratings map[string]Rating, teams []Team, now time.Time) (updated map[string]Rating, err error) {
+	if err = validate(ratings, teams); err != nil {
+		return
+	}
+
+	decayed := make(map[string]Rating)
+	for _, team := range teams {
+		for _, playerID := range team.PlayerIDs {
+			decayed[playerID] = g.Decay(ratings[playerID], now)
+		}
+	}
+
+	// Each team as a single opponent, with its average rating and root mean square deviation
+	composites := make([]glicko2Opponent, len(teams))
+	for i, team := range teams {
+		for _, playerID := range team.PlayerIDs {
+			mu, phi := toGlicko2(decayed[playerID])
+			composites[i].mu += mu
+			composites[i].phi += phi * phi
+		}
+		composites[i].mu /= float64(len(team.PlayerIDs))
+		composites[i].phi = math.Sqrt(composites[i].phi / float64(len(team.PlayerIDs)))
+	}
+
+	updated = make(map[string]Rating)
+	for i, team := range teams {
+		opponents := make([]glicko2Opponent, 0, len(teams)-1)
+		for j, opponent := range teams {
+			if i == j {
+				continue
+			}
+			composite := composites[j]
+			composite.score = score(team.Rank, opponent.Rank)
+			opponents = append(opponents, composite)
+		}
+		for _, playerID := range team.PlayerIDs {
+			r := g.rate(decayed[playerID], opponents)
+			r.LastPlayedAt = now
+			updated[playerID] = r
+		}
+	}
+	return
+}
+
+// rate runs steps 3 to 8 of the Glicko-2 algorithm for a single player against their opponents
+func (g *Glicko2) rate(r Rating, opponents []glicko2Opponent) Rating {
+	mu, phi := toGlicko2(r)
+
+	var vInv, improvement float64
+	for _, o := range opponents {
+		gPhi := glicko2G(o.phi)
+		e := glicko2E(mu, o.mu, gPhi)
+		vInv += gPhi * gPhi * e * (1 - e)
+		improvement += gPhi * (o.score - e)
+	}
+	v := 1 / vInv
+	delta := v * improvement
+
+	sigma := g.volatility(phi, r.Volatility, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * improvement
+
+	return Rating{
+		Value:      mu*GLICKO2_SCALE + GLICKO2_CENTER,
+		Deviation:  phi * GLICKO2_SCALE,
+		Volatility: sigma,
+	}
+}
+
+// volatility finds the player's new volatility with the Illinois algorithm, step 5 of the Glicko-2 algorithm
+func (g *Glicko2) volatility(phi float64, sigma float64, v float64, delta float64) float64 {
+	tau := g.config.Tau
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > GLICKO2_CONVERGENCE {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
+
+// toGlicko2 converts a rating from the Glicko scale to the Glicko-2 scale
+func toGlicko2(r Rating) (mu float64, phi float64) {
+	mu = (r.Value - GLICKO2_CENTER) / GLICKO2_SCALE
+	phi = r.Deviation / GLICKO2_SCALE
+	return
+}
+
+func glicko2G(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glicko2E(mu float64, opponentMu float64, gPhi float64) float64 {
+	return 1 / (1 + math.Exp(-gPhi*(mu-opponentMu)))
+}
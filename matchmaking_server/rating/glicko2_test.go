@@ -0,0 +1,70 @@
+package rating
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlicko2(t *testing.T) {
+	now := time.Now()
+
+	t.Run("matches the worked example from the Glicko-2 paper", func(t *testing.T) {
+		g := NewGlicko2(Glicko2Config{Tau: 0.5})
+		opponent := func(rating float64, deviation float64, score float64) glicko2Opponent {
+			mu, phi := toGlicko2(Rating{Value: rating, Deviation: deviation})
+			return glicko2Opponent{mu: mu, phi: phi, score: score}
+		}
+
+		r := g.rate(Rating{Value: 1500, Deviation: 200, Volatility: 0.06}, []glicko2Opponent{
+			opponent(1400, 30, 1),
+			opponent(1550, 100, 0),
+			opponent(1700, 300, 0),
+		})
+		require.InDelta(t, 1464.06, r.Value, 0.01)
+		require.InDelta(t, 151.52, r.Deviation, 0.01)
+		require.InDelta(t, 0.05999, r.Volatility, 0.00001)
+	})
+
+	t.Run("new players move further than established players", func(t *testing.T) {
+		g := NewGlicko2(Glicko2Config{})
+		established := Rating{Value: 1500, Deviation: 50, Volatility: 0.06, LastPlayedAt: now}
+		updated, err := g.Update(
+			map[string]Rating{"new": g.Initial(), "established": established},
+			[]Team{{PlayerIDs: []string{"new"}, Rank: 0}, {PlayerIDs: []string{"established"}, Rank: 1}},
+			now,
+		)
+		require.NoError(t, err)
+		require.Greater(t, updated["new"].Value-1500, 1500-updated["established"].Value)
+		require.Less(t, updated["new"].Deviation, float64(DEFAULT_GLICKO2_INITIAL_DEVIATION))
+		require.Equal(t, now, updated["new"].LastPlayedAt)
+	})
+
+	t.Run("draws between equal players only shrink deviation", func(t *testing.T) {
+		g := NewGlicko2(Glicko2Config{})
+		updated, err := g.Update(
+			map[string]Rating{"p1": g.Initial(), "p2": g.Initial(), "p3": g.Initial(), "p4": g.Initial()},
+			[]Team{{PlayerIDs: []string{"p1", "p2"}}, {PlayerIDs: []string{"p3", "p4"}}},
+			now,
+		)
+		require.NoError(t, err)
+		for _, r := range updated {
+			require.InDelta(t, 1500, r.Value, 0.001)
+			require.Less(t, r.Deviation, float64(DEFAULT_GLICKO2_INITIAL_DEVIATION))
+		}
+	})
+
+	t.Run("inactive players become less certain", func(t *testing.T) {
+		g := NewGlicko2(Glicko2Config{RatingPeriod: time.Hour, MaxDeviation: 300})
+		r := Rating{Value: 1600, Deviation: 50, Volatility: 0.06, LastPlayedAt: now}
+
+		require.Equal(t, r, g.Decay(r, now))
+		decayed := g.Decay(r, now.Add(10*time.Hour))
+		require.Equal(t, 1600.0, decayed.Value)
+		require.InDelta(t, 59.89, decayed.Deviation, 0.01)
+		require.Equal(t, 300.0, g.Decay(r, now.Add(10000*time.Hour)).Deviation)
+		// Players that haven't played keep their initial deviation
+		require.Equal(t, g.Initial(), g.Decay(g.Initial(), now))
+	})
+}
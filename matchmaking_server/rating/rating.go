@@ -0,0 +1,73 @@
+package rating
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotEnoughTeams = errors.New("a match needs at least two teams to be rated")
+	ErrEmptyTeam      = errors.New("teams must have at least one player")
+	ErrMissingRating  = errors.New("every player in the match must have a rating")
+)
+
+// Rating is a player's skill estimate
+//
+// ELO only uses Value. Glicko-2 also tracks how uncertain the estimate is with Deviation
+// and how erratic the player's results are with Volatility
+type Rating struct {
+	Value      float64
+	Deviation  float64
+	Volatility float64
+	// LastPlayedAt is when the rating was last updated, zero if the player hasn't played
+	LastPlayedAt time.Time
+}
+
+// Team is a group of players that placed together in a match
+// Teams with a lower Rank placed higher, teams with the same Rank drew
+type Team struct {
+	PlayerIDs []string
+	Rank      int
+}
+
+// Calculator computes new ratings from the outcome of a match
+//
+// Matches with more than two teams are rated as if every team played every other team
+type Calculator interface {
+	// Initial returns the rating of a player who hasn't played yet
+	Initial() Rating
+	// Update returns the new rating of every player in the teams, given their ratings before the match
+	Update(ratings map[string]Rating, teams []Team, now time.Time) (map[string]Rating, error)
+}
+
+// score returns the result of a team with rank a against a team with rank b: 1 for a win, 0.5 for a draw and 0 for a loss
+func score(a, b int) float64 {
+	switch {
+	case a < b:
+		return 1
+	case a == b:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+func validate(ratings map[string]Rating, teams []Team) (err error) {
+	if len(teams) < 2 {
+		err = ErrNotEnoughTeams
+		return
+	}
+	for _, team := range teams {
+		if len(team.PlayerIDs) == 0 {
+			err = ErrEmptyTeam
+			return
+		}
+		for _, playerID := range team.PlayerIDs {
+			if _, exists := ratings[playerID]; !exists {
+				err = ErrMissingRating
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,227 @@
+package matchmaking
+
+import (
+	"math"
+	"time"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/gunnermanx/simplegameserver/datastore/model"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/rating"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/strategy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ISSUED_MATCH_TTL_S is how long the result of an allocated match can be reported to be rated
+	ISSUED_MATCH_TTL_S = 24 * 60 * 60
+)
+
+// issuedMatch is a match the matchmaker allocated, only results reported for these matches are rated
+type issuedMatch struct {
+	playerIDs map[string]bool
+	issuedAt  time.Time
+	// ratedGameID is the game whose result is being or was applied to the ratings, a match is only rated once
+	ratedGameID string
+}
+
+// issueMatch records an allocated match so the result of its game can be rated
+func (sms *SimpleMatchmakingServer) issueMatch(match *strategy.Match, now time.Time) {
+	sms.issuedMatchesMutex.Lock()
+	defer sms.issuedMatchesMutex.Unlock()
+
+	for matchID, issued := range sms.issuedMatches {
+		if now.Sub(issued.issuedAt) > ISSUED_MATCH_TTL_S*time.Second {
+			delete(sms.issuedMatches, matchID)
+		}
+	}
+	playerIDs := make(map[string]bool)
+	for _, playerID := range match.PlayerIDs() {
+		playerIDs[playerID] = true
+	}
+	sms.issuedMatches[match.ID] = &issuedMatch{
+		playerIDs: playerIDs,
+		issuedAt:  now,
+	}
+}
+
+// claimMatchRating reserves the result's match to be rated with its game, ok is false if the matchmaker didn't
+// issue the match, the result has players that weren't matched or the match was already rated
+func (sms *SimpleMatchmakingServer) claimMatchRating(result common.GameResult, playerIDs []string) (ok bool) {
+	sms.issuedMatchesMutex.Lock()
+	defer sms.issuedMatchesMutex.Unlock()
+
+	issued, exists := sms.issuedMatches[result.MatchID]
+	if !exists || issued.ratedGameID != "" {
+		return
+	}
+	for _, playerID := range playerIDs {
+		if !issued.playerIDs[playerID] {
+			return
+		}
+	}
+	issued.ratedGameID = result.GameID
+	ok = true
+	return
+}
+
+// releaseMatchRating undoes claimMatchRating after the ratings failed to update, so the retried result is applied
+func (sms *SimpleMatchmakingServer) releaseMatchRating(result common.GameResult) {
+	sms.issuedMatchesMutex.Lock()
+	defer sms.issuedMatchesMutex.Unlock()
+
+	if issued, exists := sms.issuedMatches[result.MatchID]; exists && issued.ratedGameID == result.GameID {
+		issued.ratedGameID = ""
+	}
+}
+
+// WithRatingCalculator updates the ratings of the players in every completed game of a match the matchmaker allocated
+// For example rating.NewELO(rating.ELOConfig{}) or rating.NewGlicko2(rating.Glicko2Config{})
+func (sms *SimpleMatchmakingServer) WithRatingCalculator(calc rating.Calculator) {
+	sms.RegisterGameResultHandler(func(result common.GameResult) error {
+		return sms.updateRatings(calc, result)
+	})
+}
+
+// updateRatings applies the result of a completed game to its players' ratings in a single datastore transaction
+// Only games of matches the matchmaker allocated are rated, once per match. Results that were already applied,
+// games that didn't complete, custom games and games with a single team are skipped
+func (sms *SimpleMatchmakingServer) updateRatings(calc rating.Calculator, result common.GameResult) (err error) {
+	if result.EndReason != common.GAME_END_REASON_COMPLETED {
+		return
+	}
+	teams := resultTeams(result)
+	if len(teams) < 2 {
+		return
+	}
+	var playerIDs []string
+	for _, team := range teams {
+		playerIDs = append(playerIDs, team.PlayerIDs...)
+	}
+	if !sms.claimMatchRating(result, playerIDs) {
+		sms.logger.WithFields(logrus.Fields{
+			"gameID":  result.GameID,
+			"matchID": result.MatchID,
+		}).Debug("game result not rated")
+		return
+	}
+	now := result.EndedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var updated map[string]model.MatchmakingData
+	err = sms.datastore.UpdateMatchmakingData(
+		playerIDs,
+		func(data map[string]model.MatchmakingData) (saved map[string]model.MatchmakingData, err error) {
+			ratings := make(map[string]rating.Rating)
+			for _, playerID := range playerIDs {
+				ratings[playerID] = ratingFromData(calc, data[playerID])
+			}
+
+			var newRatings map[string]rating.Rating
+			if newRatings, err = calc.Update(ratings, teams, now); err != nil {
+				return
+			}
+
+			saved = make(map[string]model.MatchmakingData)
+			for _, team := range teams {
+				for _, playerID := range team.PlayerIDs {
+					d := data[playerID]
+					d.ID = playerID
+					r := newRatings[playerID]
+					d.Rating, d.Deviation, d.Volatility = r.Value, r.Deviation, r.Volatility
+					d.LastPlayedAt = r.LastPlayedAt
+					d.GamesPlayed++
+					switch {
+					case len(result.Winners) == 0:
+						d.Draws++
+					case team.Rank == 0:
+						d.Wins++
+					default:
+						d.Losses++
+					}
+					saved[playerID] = d
+				}
+			}
+			updated = saved
+			return
+		},
+	)
+	if err != nil {
+		sms.releaseMatchRating(result)
+		err = errors.Wrap(err, "failed updating ratings")
+		return
+	}
+
+	// Refresh cached players so their next tickets use the new ratings
+	sms.playersMutex.Lock()
+	defer sms.playersMutex.Unlock()
+	for playerID, d := range updated {
+		if _, exists := sms.players[playerID]; exists {
+			sms.players[playerID] = &MatchmakingPlayer{
+				ID:     playerID,
+				Rating: int(math.Round(d.Rating)),
+			}
+		}
+		sms.logger.WithFields(logrus.Fields{
+			"playerID": playerID,
+			"gameID":   result.GameID,
+			"rating":   d.Rating,
+		}).Debug("player rating updated")
+	}
+	return
+}
+
+// ratingFromData returns the player's stored rating, any field that hasn't been set yet starts at the calculator's initial rating
+func ratingFromData(calc rating.Calculator, d model.MatchmakingData) (r rating.Rating) {
+	r = calc.Initial()
+	if d.Rating != 0 {
+		r.Value = d.Rating
+	}
+	if d.Deviation != 0 {
+		r.Deviation = d.Deviation
+	}
+	if d.Volatility != 0 {
+		r.Volatility = d.Volatility
+	}
+	r.LastPlayedAt = d.LastPlayedAt
+	return
+}
+
+// resultTeams ranks the teams of a game result, teams with a winner rank first and every other team ties for second.
+// Players that aren't in one of the result's teams play on their own, and a game without winners is a draw
+func resultTeams(result common.GameResult) (teams []rating.Team) {
+	winners := make(map[string]bool)
+	for _, playerID := range result.Winners {
+		winners[playerID] = true
+	}
+	rank := func(playerIDs []string) int {
+		for _, playerID := range playerIDs {
+			if winners[playerID] {
+				return 0
+			}
+		}
+		if len(winners) == 0 {
+			return 0
+		}
+		return 1
+	}
+
+	inTeam := make(map[string]bool)
+	for _, playerIDs := range result.Teams {
+		if len(playerIDs) == 0 {
+			continue
+		}
+		teams = append(teams, rating.Team{PlayerIDs: playerIDs, Rank: rank(playerIDs)})
+		for _, playerID := range playerIDs {
+			inTeam[playerID] = true
+		}
+	}
+	for _, playerID := range result.PlayerIDs {
+		if !inTeam[playerID] {
+			teams = append(teams, rating.Team{PlayerIDs: []string{playerID}, Rank: rank([]string{playerID})})
+		}
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package matchmaking
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrGameResultInvalid = errors.New("game result must have a gameID and gameType")
+)
+
+// GameResultHandler is called with the result of every game reported by a game server
+//
+// If a handler returns an error the game server is told to send the result again,
+// so handlers should be safe to call more than once for the same game
+type GameResultHandler func(result common.GameResult) error
+
+// gameResultHandlers holds the registered handlers, in the order they run
+type gameResultHandlers struct {
+	handlers []GameResultHandler
+	mutex    sync.RWMutex
+}
+
+// RegisterGameResultHandler adds a handler that is called with the result of every game reported by a game server
+func (sms *SimpleMatchmakingServer) RegisterGameResultHandler(handler GameResultHandler) {
+	sms.resultHandlers.mutex.Lock()
+	defer sms.resultHandlers.mutex.Unlock()
+	sms.resultHandlers.handlers = append(sms.resultHandlers.handlers, handler)
+}
+
+// recordGameResult passes a result reported by a game server to the registered handlers,
+// stopping at the first handler that fails
+func (sms *SimpleMatchmakingServer) recordGameResult(result common.GameResult) (err error) {
+	if result.GameID == "" || result.GameType == "" {
+		err = ErrGameResultInvalid
+		return
+	}
+
+	sms.logger.WithFields(logrus.Fields{
+		"gameID":    result.GameID,
+		"matchID":   result.MatchID,
+		"serverID":  result.ServerID,
+		"endReason": result.EndReason,
+		"winners":   result.Winners,
+	}).Info("game result reported")
+	sms.metrics.gameResults.WithLabelValues(result.GameType, result.EndReason).Inc()
+
+	sms.resultHandlers.mutex.RLock()
+	defer sms.resultHandlers.mutex.RUnlock()
+	for _, handler := range sms.resultHandlers.handlers {
+		if err = handler(result); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gunnermanx/simplegameserver/auth"
@@ -25,18 +26,46 @@ const (
 type SimpleMatchmakingServer struct {
 	config   *config.MatchmakingServerConfig
 	serveMux *http.ServeMux
-	server   *http.Server
-	logger   *logrus.Logger
+	// routePolicies maps registered patterns to who can reach them
+	routePolicies map[string]auth.Policy
+	server        *http.Server
+	logger        *logrus.Logger
 
 	datastore    datastore.Datastore
 	authProvider auth.AuthProvider
 
-	strategy strategy.Strategy
+	strategy  strategy.Strategy
+	allocator Allocator
 
-	players            map[string]*MatchmakingPlayer
-	playersMutex       sync.Mutex
-	queuedPlayers      map[int][]*MatchmakingPlayer
-	queuedPlayersMutex sync.Mutex
+	players      map[string]*MatchmakingPlayer
+	playersMutex sync.Mutex
+	queue        map[string]*queueEntry
+	queueMutex   sync.Mutex
+	// queueWatchers are the channels of open queue sessions by player ID, guarded by queueMutex
+	queueWatchers map[string]map[chan struct{}]bool
+	// waitEstimate is a moving average of how long matched tickets waited, guarded by queueMutex
+	waitEstimate time.Duration
+	// backoffs are the tickets of matches that failed to allocate by ticket ID, guarded by queueMutex
+	backoffs map[string]*requeueBackoff
+	// allocationFailureLog limits how often failed allocations are logged
+	allocationFailureLog *throttledLog
+	// parties is guarded by partiesMutex along with playerParties, which maps players to their party,
+	// and partyInvites, which maps invited players to the IDs of the parties that invited them.
+	// partiesMutex is always locked before queueMutex
+	parties       map[string]*Party
+	playerParties map[string]string
+	partyInvites  map[string]map[string]bool
+	partiesMutex  sync.Mutex
+
+	gameServers      map[string]*registeredGameServer
+	gameServersMutex sync.Mutex
+
+	resultHandlers gameResultHandlers
+	// issuedMatches are the allocated matches by ID, results are only rated for these matches
+	issuedMatches      map[string]*issuedMatch
+	issuedMatchesMutex sync.Mutex
+
+	metrics *serverMetrics
 }
 
 func New(
@@ -48,14 +77,28 @@ func New(
 ) (s *SimpleMatchmakingServer) {
 
 	s = &SimpleMatchmakingServer{
-		config:       conf,
-		logger:       logger,
-		authProvider: ap,
-		datastore:    ds,
-		strategy:     strat,
-		serveMux:     http.NewServeMux(),
+		config:        conf,
+		logger:        logger,
+		authProvider:  ap,
+		datastore:     ds,
+		strategy:      strat,
+		serveMux:      http.NewServeMux(),
+		routePolicies: make(map[string]auth.Policy),
+		players:       make(map[string]*MatchmakingPlayer),
+		queue:         make(map[string]*queueEntry),
+		queueWatchers: make(map[string]map[chan struct{}]bool),
+		backoffs:      make(map[string]*requeueBackoff),
+		parties:       make(map[string]*Party),
+		playerParties: make(map[string]string),
+		partyInvites:  make(map[string]map[string]bool),
+		gameServers:   make(map[string]*registeredGameServer),
+		issuedMatches: make(map[string]*issuedMatch),
+		allocator:     NewHTTPAllocator(conf.AllocationSecret),
 	}
 
+	s.allocationFailureLog = newThrottledLog(ALLOCATION_FAILURE_LOG_INTERVAL_S * time.Second)
+	s.metrics = newServerMetrics(s)
+
 	s.setupHandlers()
 	s.server = &http.Server{
 		Handler: s,
@@ -64,6 +107,18 @@ func New(
 	return
 }
 
+// WithAllocator replaces the allocator used to create games for formed matches
+// If nil, matched players are given join tickets without a game
+func (sms *SimpleMatchmakingServer) WithAllocator(a Allocator) {
+	sms.allocator = a
+}
+
+// WithSessionIssuer serves guest account creation and token refresh on /auth/guest and /auth/refresh
+func (sms *SimpleMatchmakingServer) WithSessionIssuer(si *auth.SessionIssuer) {
+	sms.RegisterHandler(auth.GUEST_PATH, auth.PublicPolicy, si.GuestHandler)
+	sms.RegisterHandler(auth.REFRESH_PATH, auth.PublicPolicy, si.RefreshHandler)
+}
+
 // Start the matchmaking server
 func (sms *SimpleMatchmakingServer) Start() (err error) {
 	var listener net.Listener
@@ -74,6 +129,11 @@ func (sms *SimpleMatchmakingServer) Start() (err error) {
 		return
 	}
 
+	// Start forming matches
+	matchmakingCtx, matchmakingCancel := context.WithCancel(context.Background())
+	defer matchmakingCancel()
+	go sms.runMatchmaking(matchmakingCtx)
+
 	// Start the http server
 	errc := make(chan error, 1)
 	go func() {
@@ -83,7 +143,7 @@ func (sms *SimpleMatchmakingServer) Start() (err error) {
 
 	// Wait for termination or errors
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	select {
 	case err = <-errc:
 		sms.logger.Errorf("failed to serve: %s", err.Error())
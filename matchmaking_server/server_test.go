@@ -0,0 +1,915 @@
+package matchmaking
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gunnermanx/simplegameserver/auth"
+	"github.com/gunnermanx/simplegameserver/common"
+	"github.com/gunnermanx/simplegameserver/config"
+	"github.com/gunnermanx/simplegameserver/datastore/model"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/rating"
+	"github.com/gunnermanx/simplegameserver/matchmaking_server/strategy"
+	mocks "github.com/gunnermanx/simplegameserver/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+func TestServer(t *testing.T) {
+	p1 := &MatchmakingPlayer{ID: "p1_id", Rating: 1000}
+	p2 := &MatchmakingPlayer{ID: "p2_id", Rating: 1010}
+
+	logger := logrus.New()
+
+	config := &config.MatchmakingServerConfig{
+		GameType:         "duel",
+		JoinTicketSecret: "secret",
+	}
+
+	newServer := func(mockCtrl *gomock.Controller) (*SimpleMatchmakingServer, *mocks.MockAllocator) {
+		mockAllocator := mocks.NewMockAllocator(mockCtrl)
+		s := New(
+			config,
+			logger,
+			strategy.NewELOStrategy(strategy.ELOConfig{MatchSize: 2}),
+			mocks.NewMockAuthProvider(mockCtrl),
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		s.WithAllocator(mockAllocator)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{
+			ServerID: "gs1",
+			URL:      "http://gameserver",
+			Address:  "ws://gameserver",
+			Capacity: 10,
+		}, time.Now()))
+		return s, mockAllocator
+	}
+
+	t.Run("queue and cancel", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _ := newServer(mockCtrl)
+
+		_, err := s.enqueue([]*MatchmakingPlayer{p1}, "")
+		require.NoError(t, err)
+		_, err = s.enqueue([]*MatchmakingPlayer{p1}, "")
+		require.ErrorIs(t, err, ErrPlayerAlreadyQueued)
+
+		require.NoError(t, s.dequeue(p1.ID))
+		require.ErrorIs(t, s.dequeue(p1.ID), ErrPlayerNotQueued)
+	})
+
+	t.Run("matched players get join tickets", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, mockAllocator := newServer(mockCtrl)
+
+		mockAllocator.EXPECT().Allocate(gomock.Any(), "http://gameserver", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, url string, req common.AllocateGameRequest) (common.AllocateGameResponse, error) {
+				require.Equal(t, "duel", req.GameType)
+				require.ElementsMatch(t, []string{p1.ID, p2.ID}, req.PlayerIDs)
+				return common.AllocateGameResponse{GameID: "game1_id", Address: "ws://gameserver"}, nil
+			},
+		).Times(1)
+
+		_, err := s.enqueue([]*MatchmakingPlayer{p1}, "")
+		require.NoError(t, err)
+		_, err = s.enqueue([]*MatchmakingPlayer{p2}, "")
+		require.NoError(t, err)
+
+		s.formMatches(time.Now())
+
+		require.Eventually(t, func() bool {
+			entry, err := s.getQueueEntry(p1.ID)
+			return err == nil && entry.joinTicket != nil
+		}, time.Second, 10*time.Millisecond)
+
+		entry, err := s.getQueueEntry(p2.ID)
+		require.NoError(t, err)
+		require.Equal(t, "game1_id", entry.joinTicket.GameID)
+		require.Equal(t, "ws://gameserver", entry.joinTicket.Address)
+		_, err = common.ParseJoinTicket("secret", entry.joinTicket.Token, p2.ID, "game1_id", time.Now())
+		require.NoError(t, err)
+
+		// Delivered join tickets are removed from the queue
+		s.removeMatchedEntry(p2.ID, "other_ticket_id")
+		_, err = s.getQueueEntry(p2.ID)
+		require.NoError(t, err)
+		s.removeMatchedEntry(p2.ID, entry.ticket.ID)
+		_, err = s.getQueueEntry(p2.ID)
+		require.ErrorIs(t, err, ErrPlayerNotQueued)
+	})
+
+	t.Run("failed allocation requeues tickets", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, mockAllocator := newServer(mockCtrl)
+
+		allocated := make(chan struct{})
+		mockAllocator.EXPECT().Allocate(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, url string, req common.AllocateGameRequest) (common.AllocateGameResponse, error) {
+				defer close(allocated)
+				return common.AllocateGameResponse{}, fmt.Errorf("no capacity")
+			},
+		).Times(1)
+
+		_, err := s.enqueue([]*MatchmakingPlayer{p1}, "")
+		require.NoError(t, err)
+		_, err = s.enqueue([]*MatchmakingPlayer{p2}, "")
+		require.NoError(t, err)
+
+		s.formMatches(time.Now())
+		<-allocated
+
+		// The tickets are held out of the queue until their backoff passes
+		require.Eventually(t, func() bool {
+			entry, err := s.getQueueEntry(p1.ID)
+			return err == nil && entry.match == nil
+		}, time.Second, 10*time.Millisecond)
+		entry, err := s.getQueueEntry(p1.ID)
+		require.NoError(t, err)
+		require.Nil(t, entry.joinTicket)
+		require.Equal(t, 0, s.strategy.QueueLength())
+
+		s.queueMutex.Lock()
+		s.requeueDue(time.Now())
+		require.Equal(t, 0, s.strategy.QueueLength())
+		s.requeueDue(time.Now().Add(requeueDelay(1)))
+		require.Equal(t, 2, s.strategy.QueueLength())
+		s.queueMutex.Unlock()
+	})
+
+	t.Run("requeue backoff doubles up to a limit", func(t *testing.T) {
+		require.Equal(t, REQUEUE_BACKOFF_BASE_MS*time.Millisecond, requeueDelay(1))
+		require.Equal(t, 2*REQUEUE_BACKOFF_BASE_MS*time.Millisecond, requeueDelay(2))
+		require.Equal(t, REQUEUE_BACKOFF_MAX_MS*time.Millisecond, requeueDelay(100))
+	})
+
+	t.Run("players can leave the queue while their ticket backs off", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _ := newServer(mockCtrl)
+
+		_, err := s.enqueue([]*MatchmakingPlayer{p1}, "eu")
+		require.NoError(t, err)
+		_, err = s.enqueue([]*MatchmakingPlayer{p2}, "eu")
+		require.NoError(t, err)
+
+		s.formMatches(time.Now())
+		require.Eventually(t, func() bool {
+			entry, err := s.getQueueEntry(p1.ID)
+			return err == nil && entry.match == nil
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, s.dequeue(p1.ID))
+		s.queueMutex.Lock()
+		defer s.queueMutex.Unlock()
+		require.NotContains(t, s.queue, p1.ID)
+		require.Len(t, s.backoffs, 1)
+	})
+
+	t.Run("no game server in region requeues tickets", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _ := newServer(mockCtrl)
+
+		_, err := s.enqueue([]*MatchmakingPlayer{p1}, "eu")
+		require.NoError(t, err)
+		_, err = s.enqueue([]*MatchmakingPlayer{p2}, "eu")
+		require.NoError(t, err)
+
+		s.formMatches(time.Now())
+
+		require.Eventually(t, func() bool {
+			entry, err := s.getQueueEntry(p1.ID)
+			return err == nil && entry.match == nil
+		}, time.Second, 10*time.Millisecond)
+
+		// The next pass before the backoff passes doesn't try to place the match again
+		s.formMatches(time.Now())
+		entry, err := s.getQueueEntry(p2.ID)
+		require.NoError(t, err)
+		require.Nil(t, entry.match)
+		require.Equal(t, 0, s.strategy.QueueLength())
+	})
+}
+
+func TestQueueSession(t *testing.T) {
+	logger := logrus.New()
+
+	newServer := func(mockCtrl *gomock.Controller) (*SimpleMatchmakingServer, *mocks.MockAllocator, *httptest.Server) {
+		mockAuthProvider := mocks.NewMockAuthProvider(mockCtrl)
+		mockAuthProvider.EXPECT().AuthenticateRequest(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, r *http.Request) (context.Context, error) {
+				return ctx, nil
+			},
+		).AnyTimes()
+		mockAuthProvider.EXPECT().GetUIDFromRequest(gomock.Any()).DoAndReturn(func(r *http.Request) (string, error) {
+			return r.Header.Get("Player"), nil
+		}).AnyTimes()
+		mockAllocator := mocks.NewMockAllocator(mockCtrl)
+
+		s := New(
+			&config.MatchmakingServerConfig{GameType: "duel"},
+			logger,
+			strategy.NewELOStrategy(strategy.ELOConfig{MatchSize: 2, InitialWindow: 50}),
+			mockAuthProvider,
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		s.WithAllocator(mockAllocator)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{
+			ServerID: "gs1",
+			URL:      "http://gameserver",
+			Address:  "ws://gameserver",
+			Capacity: 10,
+		}, time.Now()))
+		s.players["p1_id"] = &MatchmakingPlayer{ID: "p1_id", Rating: 1000}
+		s.players["p2_id"] = &MatchmakingPlayer{ID: "p2_id", Rating: 1010}
+		return s, mockAllocator, httptest.NewServer(s)
+	}
+	dial := func(t *testing.T, server *httptest.Server, playerID string) *websocket.Conn {
+		conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+QUEUE_PATH, &websocket.DialOptions{
+			HTTPHeader: http.Header{"Player": []string{playerID}},
+		})
+		require.NoError(t, err)
+		return conn
+	}
+	read := func(t *testing.T, conn *websocket.Conn) (status MatchStatusResponse) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, wsjson.Read(ctx, conn, &status))
+		return
+	}
+
+	t.Run("status is streamed until the match is ready", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, mockAllocator, server := newServer(mockCtrl)
+		defer server.Close()
+
+		mockAllocator.EXPECT().Allocate(gomock.Any(), "http://gameserver", gomock.Any()).Return(
+			common.AllocateGameResponse{GameID: "game1_id", Address: "ws://gameserver"}, nil,
+		).Times(1)
+
+		p1Conn := dial(t, server, "p1_id")
+		status := read(t, p1Conn)
+		require.Equal(t, QUEUE_STATUS_QUEUED, status.Status)
+		require.Equal(t, 1, status.Position)
+		require.Equal(t, 50, status.SearchWindow)
+		require.Zero(t, status.EstimatedWaitMS)
+
+		p2Conn := dial(t, server, "p2_id")
+		status = read(t, p2Conn)
+		require.Equal(t, 2, status.Position)
+
+		s.formMatches(time.Now().Add(time.Second))
+		for _, conn := range []*websocket.Conn{p1Conn, p2Conn} {
+			for status = read(t, conn); status.Status != QUEUE_STATUS_MATCHED; status = read(t, conn) {
+			}
+			require.ElementsMatch(t, []string{"p1_id", "p2_id"}, status.PlayerIDs)
+			require.Equal(t, "game1_id", status.JoinTicket.GameID)
+			require.Equal(t, "ws://gameserver", status.JoinTicket.Address)
+			require.Greater(t, status.EstimatedWaitMS, int64(0))
+
+			// The session ends once the join ticket is sent
+			_, _, err := conn.Read(context.Background())
+			require.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+		}
+		_, err := s.getQueueEntry("p1_id")
+		require.ErrorIs(t, err, ErrPlayerNotQueued)
+	})
+
+	t.Run("closing the session dequeues the player", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _, server := newServer(mockCtrl)
+		defer server.Close()
+
+		conn := dial(t, server, "p1_id")
+		require.Equal(t, QUEUE_STATUS_QUEUED, read(t, conn).Status)
+		require.Equal(t, 1, s.strategy.QueueLength())
+
+		conn.Close(websocket.StatusNormalClosure, "")
+		require.Eventually(t, func() bool {
+			return s.strategy.QueueLength() == 0 && !s.isQueued("p1_id")
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("sessions end when the ticket is cancelled", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _, server := newServer(mockCtrl)
+		defer server.Close()
+
+		conn := dial(t, server, "p1_id")
+		read(t, conn)
+		require.NoError(t, s.dequeue("p1_id"))
+		_, _, err := conn.Read(context.Background())
+		require.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+		require.Eventually(t, func() bool {
+			s.queueMutex.Lock()
+			defer s.queueMutex.Unlock()
+			return len(s.queueWatchers) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("reopened sessions follow the same ticket", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _, server := newServer(mockCtrl)
+		defer server.Close()
+
+		first := dial(t, server, "p1_id")
+		ticketID := read(t, first).TicketID
+		// The player reconnects before the first session noticed it dropped
+		second := dial(t, server, "p1_id")
+		require.Equal(t, ticketID, read(t, second).TicketID)
+		require.Equal(t, 1, s.strategy.QueueLength())
+
+		first.Close(websocket.StatusNormalClosure, "")
+		require.Never(t, func() bool {
+			return !s.isQueued("p1_id")
+		}, 100*time.Millisecond, 10*time.Millisecond)
+
+		second.Close(websocket.StatusNormalClosure, "")
+		require.Eventually(t, func() bool {
+			return s.strategy.QueueLength() == 0 && !s.isQueued("p1_id")
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("sessions follow tickets queued without a session", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _, server := newServer(mockCtrl)
+		defer server.Close()
+
+		ticket, err := s.queuePlayer("p1_id", "")
+		require.NoError(t, err)
+		conn := dial(t, server, "p1_id")
+		require.Equal(t, ticket.ID, read(t, conn).TicketID)
+
+		// The ticket wasn't queued by the session, so closing it leaves the ticket queued
+		conn.Close(websocket.StatusNormalClosure, "")
+		require.Eventually(t, func() bool {
+			s.queueMutex.Lock()
+			defer s.queueMutex.Unlock()
+			return len(s.queueWatchers) == 0
+		}, time.Second, 10*time.Millisecond)
+		require.True(t, s.isQueued("p1_id"))
+	})
+
+	t.Run("undelivered join tickets are sent again", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _, server := newServer(mockCtrl)
+		defer server.Close()
+
+		// The session that matched the player failed to send the join ticket
+		ticket, err := s.queuePlayer("p1_id", "")
+		require.NoError(t, err)
+		s.queueMutex.Lock()
+		s.queue["p1_id"].joinTicket = &JoinTicket{GameID: "game1_id"}
+		s.queueMutex.Unlock()
+
+		conn := dial(t, server, "p1_id")
+		status := read(t, conn)
+		require.Equal(t, QUEUE_STATUS_MATCHED, status.Status)
+		require.Equal(t, ticket.ID, status.TicketID)
+		require.Equal(t, "game1_id", status.JoinTicket.GameID)
+		_, _, err = conn.Read(context.Background())
+		require.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+		_, err = s.getQueueEntry("p1_id")
+		require.ErrorIs(t, err, ErrPlayerNotQueued)
+	})
+
+	t.Run("watchers follow the player across tickets", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		s, _, server := newServer(mockCtrl)
+		defer server.Close()
+
+		updates, unwatch := s.watchQueue("p1_id")
+		for i := 0; i < 2; i++ {
+			_, err := s.queuePlayer("p1_id", "")
+			require.NoError(t, err)
+			<-updates
+			require.NoError(t, s.dequeue("p1_id"))
+			<-updates
+		}
+		unwatch()
+		require.Empty(t, s.queueWatchers)
+	})
+}
+
+func TestRoutePolicies(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockAuthProvider := mocks.NewMockAuthProvider(mockCtrl)
+	s := New(
+		&config.MatchmakingServerConfig{AllocationSecret: "secret"},
+		logrus.New(),
+		strategy.NewELOStrategy(strategy.ELOConfig{}),
+		mockAuthProvider,
+		mocks.NewMockDatastore(mockCtrl),
+	)
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	s.RegisterHandler("/admin/", auth.AdminPolicy, ok)
+
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	authenticated := func(ctx context.Context, r *http.Request) (context.Context, error) {
+		return context.WithValue(ctx, auth.KeyUID, "p1_id"), nil
+	}
+
+	t.Run("public routes aren't authenticated", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(httptest.NewRequest(http.MethodGet, HEALTH_PATH, nil)))
+	})
+
+	t.Run("player routes are authenticated", func(t *testing.T) {
+		mockAuthProvider.EXPECT().AuthenticateRequest(gomock.Any(), gomock.Any()).Return(nil, auth.ErrUnauthorized).Times(1)
+		require.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodPost, FIND_MATCH_PATH, nil)))
+
+		// Unknown paths aren't served unauthenticated
+		mockAuthProvider.EXPECT().AuthenticateRequest(gomock.Any(), gomock.Any()).Return(nil, auth.ErrUnauthorized).Times(1)
+		require.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodGet, "/unknown", nil)))
+	})
+
+	t.Run("role routes require a role", func(t *testing.T) {
+		mockAuthProvider.EXPECT().AuthenticateRequest(gomock.Any(), gomock.Any()).DoAndReturn(authenticated).Times(2)
+		mockAuthProvider.EXPECT().GetRolesFromRequest(gomock.Any()).Return(nil, nil).Times(1)
+		require.Equal(t, http.StatusForbidden, serve(httptest.NewRequest(http.MethodGet, "/admin/games", nil)))
+
+		mockAuthProvider.EXPECT().GetRolesFromRequest(gomock.Any()).Return([]string{auth.ROLE_ADMIN}, nil).Times(1)
+		require.Equal(t, http.StatusOK, serve(httptest.NewRequest(http.MethodGet, "/admin/games", nil)))
+	})
+
+	t.Run("metrics are public and count auth failures", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, METRICS_PATH, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "sgs_matchmaking_queue_length 0\n")
+		require.Contains(t, w.Body.String(), `sgs_matchmaking_auth_failures_total{reason="unauthorized"} 2`)
+		require.Contains(t, w.Body.String(), `sgs_matchmaking_auth_failures_total{reason="forbidden"} 1`)
+	})
+
+	t.Run("server routes require the server secret", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodPost, HEARTBEAT_PATH, nil)))
+
+		r := httptest.NewRequest(http.MethodPost, HEARTBEAT_PATH, strings.NewReader(`{"serverID":"gs1","url":"http://gs1"}`))
+		r.Header.Set(common.SERVER_SECRET_HEADER, "secret")
+		require.Equal(t, http.StatusOK, serve(r))
+	})
+}
+
+func TestFleet(t *testing.T) {
+	logger := logrus.New()
+	now := time.Now()
+
+	newServer := func(t *testing.T) *SimpleMatchmakingServer {
+		mockCtrl := gomock.NewController(t)
+		return New(
+			&config.MatchmakingServerConfig{GameServerTTLS: 10},
+			logger,
+			strategy.NewELOStrategy(strategy.ELOConfig{}),
+			mocks.NewMockAuthProvider(mockCtrl),
+			mocks.NewMockDatastore(mockCtrl),
+		)
+	}
+
+	t.Run("invalid heartbeat", func(t *testing.T) {
+		s := newServer(t)
+		require.ErrorIs(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "gs1"}, now), ErrGameServerInvalid)
+	})
+
+	t.Run("least loaded server in region", func(t *testing.T) {
+		s := newServer(t)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "us1", URL: "http://us1", Region: "us", Capacity: 10, Games: 1}, now))
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "eu1", URL: "http://eu1", Region: "eu", Capacity: 10, Games: 5}, now))
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "eu2", URL: "http://eu2", Region: "eu", Capacity: 4, Games: 1}, now))
+
+		gs, err := s.chooseGameServer("eu", now)
+		require.NoError(t, err)
+		require.Equal(t, "eu2", gs.ServerID)
+
+		// eu2 is counted as hosting the allocated game until its next heartbeat
+		gs, err = s.chooseGameServer("eu", now)
+		require.NoError(t, err)
+		require.Equal(t, "eu2", gs.ServerID)
+		gs, err = s.chooseGameServer("eu", now)
+		require.NoError(t, err)
+		require.Equal(t, "eu1", gs.ServerID)
+
+		gs, err = s.chooseGameServer("", now)
+		require.NoError(t, err)
+		require.Equal(t, "us1", gs.ServerID)
+
+		_, err = s.chooseGameServer("ap", now)
+		require.ErrorIs(t, err, ErrNoGameServerAvailable)
+	})
+
+	t.Run("known regions", func(t *testing.T) {
+		s := newServer(t)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "eu1", URL: "http://eu1", Region: "eu"}, now))
+
+		require.True(t, s.knownRegion("", now))
+		require.True(t, s.knownRegion("eu", now))
+		require.False(t, s.knownRegion("ap", now))
+		require.False(t, s.knownRegion("eu", now.Add(s.gameServerTTL()+time.Second)))
+
+		_, err := s.queuePlayer("p1", "ap")
+		require.ErrorIs(t, err, ErrUnknownRegion)
+	})
+
+	t.Run("full and draining servers are skipped", func(t *testing.T) {
+		s := newServer(t)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "gs1", URL: "http://gs1", Capacity: 1, Games: 1}, now))
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "gs2", URL: "http://gs2", Draining: true}, now))
+
+		_, err := s.chooseGameServer("", now)
+		require.ErrorIs(t, err, ErrNoGameServerAvailable)
+	})
+
+	t.Run("servers expire without heartbeats", func(t *testing.T) {
+		s := newServer(t)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{ServerID: "gs1", URL: "http://gs1"}, now))
+
+		_, err := s.chooseGameServer("", now.Add(5*time.Second))
+		require.NoError(t, err)
+
+		_, err = s.chooseGameServer("", now.Add(11*time.Second))
+		require.ErrorIs(t, err, ErrNoGameServerAvailable)
+		require.Empty(t, s.gameServers)
+	})
+}
+
+func TestGameResults(t *testing.T) {
+	logger := logrus.New()
+
+	newServer := func(t *testing.T) *SimpleMatchmakingServer {
+		mockCtrl := gomock.NewController(t)
+		return New(
+			&config.MatchmakingServerConfig{AllocationSecret: "secret"},
+			logger,
+			strategy.NewELOStrategy(strategy.ELOConfig{}),
+			mocks.NewMockAuthProvider(mockCtrl),
+			mocks.NewMockDatastore(mockCtrl),
+		)
+	}
+	post := func(s *SimpleMatchmakingServer, body string) int {
+		r := httptest.NewRequest(http.MethodPost, GAME_RESULT_PATH, strings.NewReader(body))
+		r.Header.Set(common.SERVER_SECRET_HEADER, "secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	t.Run("results are passed to the registered handlers", func(t *testing.T) {
+		s := newServer(t)
+		var received []common.GameResult
+		s.RegisterGameResultHandler(func(result common.GameResult) error {
+			received = append(received, result)
+			return nil
+		})
+
+		require.Equal(t, http.StatusOK, post(s, `{"gameID":"g1","gameType":"duel","endReason":"completed","playerIDs":["p1","p2"],"winners":["p1"]}`))
+		require.Len(t, received, 1)
+		require.Equal(t, "g1", received[0].GameID)
+		require.Equal(t, []string{"p1"}, received[0].Winners)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, METRICS_PATH, nil))
+		require.Contains(t, w.Body.String(), `sgs_matchmaking_game_results_total{game_type="duel",end_reason="completed"} 1`)
+	})
+
+	t.Run("invalid results are rejected", func(t *testing.T) {
+		s := newServer(t)
+		require.Equal(t, http.StatusBadRequest, post(s, `{"gameType":"duel"}`))
+	})
+
+	t.Run("completed games update ratings", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockDatastore := mocks.NewMockDatastore(mockCtrl)
+		s := New(
+			&config.MatchmakingServerConfig{AllocationSecret: "secret"},
+			logger,
+			strategy.NewELOStrategy(strategy.ELOConfig{}),
+			mocks.NewMockAuthProvider(mockCtrl),
+			mockDatastore,
+		)
+		s.WithRatingCalculator(rating.NewELO(rating.ELOConfig{KFactor: 32}))
+		s.players["p1"] = &MatchmakingPlayer{ID: "p1", Rating: 1500}
+
+		stored := map[string]model.MatchmakingData{
+			"p1": {ID: "p1", Rating: 1500, GamesPlayed: 3},
+		}
+		result := `{"gameID":"g1","matchID":"m1","gameType":"duel","endReason":"completed","playerIDs":["p1","p2","p3","p4"],` +
+			`"teams":[["p1","p2"],["p3","p4"]],"winners":["p1","p2"]}`
+
+		// Only matches the matchmaker allocated are rated, custom games and unknown matches aren't
+		require.Equal(t, http.StatusOK, post(s, `{"gameID":"g0","gameType":"duel","endReason":"completed","playerIDs":["p1","p2"],"winners":["p1"]}`))
+		require.Equal(t, http.StatusOK, post(s, result))
+		s.issueMatch(&strategy.Match{
+			ID: "m1",
+			Tickets: []*strategy.Ticket{
+				{PlayerIDs: []string{"p1", "p2"}},
+				{PlayerIDs: []string{"p3", "p4"}},
+			},
+		}, time.Now())
+		// The result's players must have been matched
+		require.Equal(t, http.StatusOK, post(s, `{"gameID":"g1","matchID":"m1","gameType":"duel","endReason":"completed",`+
+			`"playerIDs":["p1","p5"],"winners":["p5"]}`))
+
+		// A failed update is applied when the game server reports the result again
+		mockDatastore.EXPECT().UpdateMatchmakingData(gomock.Any(), gomock.Any()).Return(fmt.Errorf("datastore unavailable")).Times(1)
+		require.Equal(t, http.StatusInternalServerError, post(s, result))
+
+		mockDatastore.EXPECT().UpdateMatchmakingData(gomock.Any(), gomock.Any()).DoAndReturn(func(
+			playerIDs []string,
+			update func(data map[string]model.MatchmakingData) (map[string]model.MatchmakingData, error),
+		) error {
+			require.ElementsMatch(t, []string{"p1", "p2", "p3", "p4"}, playerIDs)
+			data := make(map[string]model.MatchmakingData)
+			for _, playerID := range playerIDs {
+				data[playerID] = stored[playerID]
+			}
+			saved, err := update(data)
+			for playerID, d := range saved {
+				stored[playerID] = d
+			}
+			return err
+		}).Times(1)
+
+		require.Equal(t, http.StatusOK, post(s, result))
+		require.InDelta(t, 1516, stored["p1"].Rating, 0.001)
+		require.InDelta(t, 1516, stored["p2"].Rating, 0.001)
+		require.InDelta(t, 1484, stored["p3"].Rating, 0.001)
+		require.Equal(t, 4, stored["p1"].GamesPlayed)
+		require.Equal(t, 1, stored["p1"].Wins)
+		require.Equal(t, 1, stored["p4"].Losses)
+		require.Equal(t, 1516, s.players["p1"].Rating)
+
+		// A result reported again isn't applied twice, and neither is another game for the same match
+		require.Equal(t, http.StatusOK, post(s, result))
+		require.Equal(t, http.StatusOK, post(s, strings.Replace(result, `"g1"`, `"g3"`, 1)))
+		require.InDelta(t, 1516, stored["p1"].Rating, 0.001)
+		require.Equal(t, 4, stored["p1"].GamesPlayed)
+
+		// Games that didn't complete aren't rated
+		require.Equal(t, http.StatusOK, post(s, `{"gameID":"g2","gameType":"duel","endReason":"ended","playerIDs":["p1","p2"]}`))
+	})
+
+	t.Run("teams are ranked by their winners", func(t *testing.T) {
+		require.Equal(t, []rating.Team{
+			{PlayerIDs: []string{"p1", "p2"}, Rank: 1},
+			{PlayerIDs: []string{"p3"}, Rank: 0},
+			{PlayerIDs: []string{"p4"}, Rank: 1},
+		}, resultTeams(common.GameResult{
+			PlayerIDs: []string{"p1", "p2", "p3", "p4"},
+			Teams:     [][]string{{"p1", "p2"}},
+			Winners:   []string{"p3"},
+		}))
+		// Without winners every team draws
+		require.Equal(t, []rating.Team{
+			{PlayerIDs: []string{"p1"}, Rank: 0},
+			{PlayerIDs: []string{"p2"}, Rank: 0},
+		}, resultTeams(common.GameResult{PlayerIDs: []string{"p1", "p2"}}))
+	})
+
+	t.Run("failed handlers ask the game server to retry", func(t *testing.T) {
+		s := newServer(t)
+		s.RegisterGameResultHandler(func(result common.GameResult) error {
+			return fmt.Errorf("datastore unavailable")
+		})
+		require.Equal(t, http.StatusInternalServerError, post(s, `{"gameID":"g1","gameType":"duel"}`))
+	})
+}
+
+func TestParties(t *testing.T) {
+	logger := logrus.New()
+	now := time.Now()
+
+	newServer := func(t *testing.T) *SimpleMatchmakingServer {
+		mockCtrl := gomock.NewController(t)
+		s := New(
+			&config.MatchmakingServerConfig{MaxPartySize: 3},
+			logger,
+			strategy.NewELOStrategy(strategy.ELOConfig{MatchSize: 4}),
+			mocks.NewMockAuthProvider(mockCtrl),
+			mocks.NewMockDatastore(mockCtrl),
+		)
+		for i, playerID := range []string{"p1", "p2", "p3", "p4"} {
+			s.players[playerID] = &MatchmakingPlayer{ID: playerID, Rating: 1000 + i*100}
+		}
+		return s
+	}
+	// newParty creates a party led by the first player with the others as members
+	newParty := func(t *testing.T, s *SimpleMatchmakingServer, playerIDs ...string) Party {
+		party, err := s.createParty(playerIDs[0], now)
+		require.NoError(t, err)
+		for _, playerID := range playerIDs[1:] {
+			require.NoError(t, s.inviteToParty(playerIDs[0], playerID, now))
+			party, err = s.acceptPartyInvite(playerID, party.ID, now)
+			require.NoError(t, err)
+		}
+		return party
+	}
+
+	t.Run("invite and accept", func(t *testing.T) {
+		s := newServer(t)
+		party, err := s.createParty("p1", now)
+		require.NoError(t, err)
+		_, err = s.createParty("p1", now)
+		require.ErrorIs(t, err, ErrPlayerAlreadyInParty)
+
+		require.ErrorIs(t, s.inviteToParty("p1", "p1", now), ErrPartyInviteInvalid)
+		require.ErrorIs(t, s.inviteToParty("p2", "p3", now), ErrPlayerNotInParty)
+		require.NoError(t, s.inviteToParty("p1", "p2", now))
+
+		_, err = s.acceptPartyInvite("p3", party.ID, now)
+		require.ErrorIs(t, err, ErrPartyInviteNotFound)
+		_, invites := s.getParty("p2", now)
+		require.Equal(t, []string{party.ID}, invites)
+		// Invites expire
+		_, err = s.acceptPartyInvite("p2", party.ID, now.Add((PARTY_INVITE_TTL_S+1)*time.Second))
+		require.ErrorIs(t, err, ErrPartyInviteNotFound)
+
+		party, err = s.acceptPartyInvite("p2", party.ID, now)
+		require.NoError(t, err)
+		require.Equal(t, "p1", party.LeaderID)
+		require.Equal(t, []string{"p1", "p2"}, party.MemberIDs)
+		require.ErrorIs(t, s.inviteToParty("p2", "p3", now), ErrNotPartyLeader)
+
+		// Parties are capped at MaxPartySize
+		require.NoError(t, s.inviteToParty("p1", "p3", now))
+		require.NoError(t, s.inviteToParty("p1", "p4", now))
+		_, err = s.acceptPartyInvite("p3", party.ID, now)
+		require.NoError(t, err)
+		_, err = s.acceptPartyInvite("p4", party.ID, now)
+		require.ErrorIs(t, err, ErrPartyFull)
+
+		// Pending invites are dropped with the party
+		require.NoError(t, s.disbandParty("p1"))
+		_, invites = s.getParty("p4", now)
+		require.Empty(t, invites)
+		require.Empty(t, s.partyInvites)
+	})
+
+	t.Run("expired invites are not listed", func(t *testing.T) {
+		s := newServer(t)
+		party := newParty(t, s, "p1")
+		other := newParty(t, s, "p2")
+		require.NoError(t, s.inviteToParty("p1", "p3", now))
+		require.NoError(t, s.inviteToParty("p2", "p3", now.Add(time.Minute)))
+
+		_, invites := s.getParty("p3", now.Add((PARTY_INVITE_TTL_S+1)*time.Second))
+		require.Equal(t, []string{other.ID}, invites)
+		require.Equal(t, map[string]bool{other.ID: true}, s.partyInvites["p3"])
+		_, err := s.acceptPartyInvite("p3", party.ID, now)
+		require.ErrorIs(t, err, ErrPartyInviteNotFound)
+	})
+
+	t.Run("parties queue as a single ticket", func(t *testing.T) {
+		s := newServer(t)
+		newParty(t, s, "p1", "p2")
+
+		_, err := s.queuePlayer("p2", "")
+		require.ErrorIs(t, err, ErrNotPartyLeader)
+
+		ticket, err := s.queuePlayer("p1", "")
+		require.NoError(t, err)
+		require.Equal(t, []string{"p1", "p2"}, ticket.PlayerIDs)
+		require.Equal(t, 1050, ticket.Rating)
+		require.Equal(t, 1, s.strategy.QueueLength())
+
+		// Every member sees the party's ticket
+		entry, err := s.getQueueEntry("p2")
+		require.NoError(t, err)
+		require.Equal(t, ticket.ID, entry.ticket.ID)
+
+		// The party can't change while it is queued
+		require.ErrorIs(t, s.leaveParty("p2"), ErrPartyQueued)
+		require.ErrorIs(t, s.disbandParty("p1"), ErrPartyQueued)
+		require.NoError(t, s.inviteToParty("p1", "p3", now))
+		party, _ := s.getParty("p1", now)
+		_, err = s.acceptPartyInvite("p3", party.ID, now)
+		require.ErrorIs(t, err, ErrPartyQueued)
+
+		// Any member can take the party out of the queue
+		require.NoError(t, s.dequeue("p2"))
+		require.Equal(t, 0, s.strategy.QueueLength())
+		require.ErrorIs(t, s.dequeue("p1"), ErrPlayerNotQueued)
+	})
+
+	t.Run("parties that change while loading are not queued", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		mockDatastore := mocks.NewMockDatastore(mockCtrl)
+		s := newServer(t)
+		s.datastore = mockDatastore
+		newParty(t, s, "p1", "p2")
+		require.NoError(t, s.inviteToParty("p1", "p5", now))
+		party, err := s.acceptPartyInvite("p5", s.playerParties["p1"], now)
+		require.NoError(t, err)
+
+		// The party can change while the new member's data is loaded, which doesn't hold the party lock
+		mockDatastore.EXPECT().FindMatchmakingData("p5").DoAndReturn(
+			func(playerID string) (model.MatchmakingData, error) {
+				require.NoError(t, s.leaveParty("p2"))
+				return model.MatchmakingData{Rating: 1500}, nil
+			},
+		).Times(1)
+		_, err = s.queuePlayer("p1", "")
+		require.ErrorIs(t, err, ErrPartyChanged)
+		require.Equal(t, 0, s.strategy.QueueLength())
+
+		ticket, err := s.queuePlayer("p1", "")
+		require.NoError(t, err)
+		require.Equal(t, []string{"p1", "p5"}, ticket.PlayerIDs)
+		current, _ := s.getParty("p1", now)
+		require.Equal(t, party.ID, current.ID)
+	})
+
+	t.Run("parties are matched together", func(t *testing.T) {
+		s := newServer(t)
+		s.WithAllocator(nil)
+		newParty(t, s, "p1", "p2")
+		newParty(t, s, "p3", "p4")
+
+		_, err := s.queuePlayer("p1", "")
+		require.NoError(t, err)
+		_, err = s.queuePlayer("p3", "")
+		require.NoError(t, err)
+
+		s.formMatches(now.Add(time.Minute))
+		require.Eventually(t, func() bool {
+			entry, err := s.getQueueEntry("p4")
+			return err == nil && entry.joinTicket != nil
+		}, time.Second, 10*time.Millisecond)
+		entry, err := s.getQueueEntry("p1")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"p1", "p2", "p3", "p4"}, entry.match.PlayerIDs())
+	})
+
+	t.Run("parties are kept on one team", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		s := newServer(t)
+		s.strategy = strategy.NewELOStrategy(strategy.ELOConfig{TeamCount: 2, TeamSize: 2})
+		mockAllocator := mocks.NewMockAllocator(mockCtrl)
+		s.WithAllocator(mockAllocator)
+		require.NoError(t, s.registerHeartbeat(common.GameServerHeartbeat{
+			ServerID: "gs1",
+			URL:      "http://gameserver",
+			Capacity: 10,
+		}, now))
+		newParty(t, s, "p1", "p4")
+
+		mockAllocator.EXPECT().Allocate(gomock.Any(), "http://gameserver", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, url string, req common.AllocateGameRequest) (common.AllocateGameResponse, error) {
+				// The party's 1000 and 1300 balance the 1100 and 1200 singles
+				require.ElementsMatch(t, [][]string{{"p1", "p4"}, {"p3", "p2"}}, req.Teams)
+				return common.AllocateGameResponse{GameID: "game1_id"}, nil
+			},
+		).Times(1)
+
+		for _, playerID := range []string{"p1", "p2", "p3"} {
+			_, err := s.queuePlayer(playerID, "")
+			require.NoError(t, err)
+		}
+		s.formMatches(now.Add(time.Minute))
+		require.Eventually(t, func() bool {
+			entry, err := s.getQueueEntry("p2")
+			return err == nil && entry.joinTicket != nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("leaders hand over the party when they leave", func(t *testing.T) {
+		s := newServer(t)
+		party := newParty(t, s, "p1", "p2", "p3")
+
+		require.NoError(t, s.leaveParty("p1"))
+		current, _ := s.getParty("p2", now)
+		require.Equal(t, party.ID, current.ID)
+		require.Equal(t, "p2", current.LeaderID)
+		require.Equal(t, []string{"p2", "p3"}, current.MemberIDs)
+		current, _ = s.getParty("p1", now)
+		require.Nil(t, current)
+
+		require.NoError(t, s.disbandParty("p2"))
+		current, _ = s.getParty("p3", now)
+		require.Nil(t, current)
+		require.Empty(t, s.parties)
+		require.ErrorIs(t, s.leaveParty("p3"), ErrPlayerNotInParty)
+	})
+}
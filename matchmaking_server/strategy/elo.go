@@ -1,9 +1,424 @@
 package strategy
 
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_MATCH_SIZE          = 2
+	DEFAULT_BUCKET_SIZE         = 100
+	DEFAULT_INITIAL_WINDOW      = 50
+	DEFAULT_WINDOW_GROWTH_PER_S = 10
+	DEFAULT_MAX_WINDOW          = 500
+
+	// TEAM_ASSIGNMENT_SEARCH_LIMIT caps how many partial team assignments are tried for a single match
+	TEAM_ASSIGNMENT_SEARCH_LIMIT = 100000
+	// FILL_REJECT_LIMIT caps how many fills can be rejected for an anchor in a single pass before it gives up
+	// until the next pass. Rejected fills aren't remembered, so without a cap the search grows combinatorially
+	FILL_REJECT_LIMIT = 64
+)
+
+// ELOConfig configures the ELO strategy, zero values are replaced by the defaults
+type ELOConfig struct {
+	// MatchSize is the number of players in a match
+	MatchSize int
+	// BucketSize is the width of the rating buckets tickets are grouped in
+	BucketSize int
+	// InitialWindow is the largest rating difference accepted when a ticket is first queued
+	InitialWindow int
+	// WindowGrowthPerS widens the window for every second a ticket has waited
+	WindowGrowthPerS int
+	// MaxWindow caps the window
+	MaxWindow int
+	// TeamCount and TeamSize split every match into TeamCount teams of TeamSize players, and replace MatchSize.
+	// Matches don't have teams if either is 0
+	TeamCount int
+	TeamSize  int
+}
+
+// ELO matches tickets with similar ratings
+//
+// Tickets are grouped into rating buckets. Each ticket has a search window that starts at InitialWindow
+// and widens the longer it waits. Two tickets can be matched if they are in the same region and their
+// rating difference is within both of their windows. The longest waiting tickets are matched first
+//
+// With teams, a match is only formed if its tickets can be split into full teams without splitting
+// a ticket, and the tickets are assigned to the teams that keep the teams' average ratings closest
 type ELO struct {
+	config ELOConfig
+
+	mutex   sync.Mutex
+	tickets map[string]*Ticket
+	buckets map[int][]*Ticket
 }
 
-func NewELOStrategy() (elo *ELO) {
-	elo = &ELO{}
+func NewELOStrategy(config ELOConfig) (elo *ELO) {
+	if config.TeamCount > 0 && config.TeamSize > 0 {
+		config.MatchSize = config.TeamCount * config.TeamSize
+	} else {
+		config.TeamCount, config.TeamSize = 0, 0
+	}
+	if config.MatchSize <= 0 {
+		config.MatchSize = DEFAULT_MATCH_SIZE
+	}
+	if config.BucketSize <= 0 {
+		config.BucketSize = DEFAULT_BUCKET_SIZE
+	}
+	if config.InitialWindow <= 0 {
+		config.InitialWindow = DEFAULT_INITIAL_WINDOW
+	}
+	if config.WindowGrowthPerS <= 0 {
+		config.WindowGrowthPerS = DEFAULT_WINDOW_GROWTH_PER_S
+	}
+	if config.MaxWindow <= 0 {
+		config.MaxWindow = DEFAULT_MAX_WINDOW
+	}
+	elo = &ELO{
+		config:  config,
+		tickets: make(map[string]*Ticket),
+		buckets: make(map[int][]*Ticket),
+	}
 	return
 }
+
+func (elo *ELO) Enqueue(t *Ticket) (err error) {
+	if t.ID == "" || t.Size() == 0 || t.Size() > elo.maxTicketSize() {
+		err = ErrTicketInvalid
+		return
+	}
+
+	elo.mutex.Lock()
+	defer elo.mutex.Unlock()
+	if _, exists := elo.tickets[t.ID]; exists {
+		err = ErrTicketAlreadyQueued
+		return
+	}
+	elo.tickets[t.ID] = t
+	bucket := elo.bucket(t.Rating)
+	elo.buckets[bucket] = append(elo.buckets[bucket], t)
+	return
+}
+
+func (elo *ELO) Dequeue(ticketID string) (err error) {
+	elo.mutex.Lock()
+	defer elo.mutex.Unlock()
+	t, exists := elo.tickets[ticketID]
+	if !exists {
+		err = ErrTicketNotFound
+		return
+	}
+	elo.remove(t)
+	return
+}
+
+func (elo *ELO) QueueLength() int {
+	elo.mutex.Lock()
+	defer elo.mutex.Unlock()
+	return len(elo.tickets)
+}
+
+// SearchWindow returns the largest rating difference the ticket accepts after waiting until now
+func (elo *ELO) SearchWindow(t *Ticket, now time.Time) int {
+	waited := int(now.Sub(t.EnqueuedAt).Seconds())
+	if waited < 0 {
+		waited = 0
+	}
+	window := elo.config.InitialWindow + waited*elo.config.WindowGrowthPerS
+	if window > elo.config.MaxWindow {
+		window = elo.config.MaxWindow
+	}
+	return window
+}
+
+func (elo *ELO) FormMatches(now time.Time) (matches []*Match) {
+	elo.mutex.Lock()
+	defer elo.mutex.Unlock()
+
+	// The longest waiting tickets get the first pick
+	queued := make([]*Ticket, 0, len(elo.tickets))
+	for _, t := range elo.tickets {
+		queued = append(queued, t)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].EnqueuedAt.Before(queued[j].EnqueuedAt)
+	})
+
+	for _, anchor := range queued {
+		// Skip tickets matched earlier in this pass
+		if _, stillQueued := elo.tickets[anchor.ID]; !stillQueued {
+			continue
+		}
+		tickets, teams := elo.findMatch(anchor, now)
+		if tickets == nil {
+			continue
+		}
+		for _, t := range tickets {
+			elo.remove(t)
+		}
+		match := &Match{
+			ID:      uuid.New().String(),
+			Tickets: tickets,
+		}
+		for _, team := range teams {
+			var playerIDs []string
+			for _, t := range team {
+				playerIDs = append(playerIDs, t.PlayerIDs...)
+			}
+			match.Teams = append(match.Teams, playerIDs)
+		}
+		matches = append(matches, match)
+	}
+	return
+}
+
+// findMatch returns the anchor and the closest rated tickets that fill a match, or nil if there aren't enough.
+// If the strategy has teams, the tickets' team assignment is returned too
+func (elo *ELO) findMatch(anchor *Ticket, now time.Time) (tickets []*Ticket, teams [][]*Ticket) {
+	window := elo.SearchWindow(anchor, now)
+
+	var candidates []*Ticket
+	for bucket := elo.bucket(anchor.Rating - window); bucket <= elo.bucket(anchor.Rating+window); bucket++ {
+		for _, t := range elo.buckets[bucket] {
+			if t == anchor || t.Region != anchor.Region {
+				continue
+			}
+			diff := ratingDiff(anchor, t)
+			if diff <= window && diff <= elo.SearchWindow(t, now) {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		di, dj := ratingDiff(anchor, candidates[i]), ratingDiff(anchor, candidates[j])
+		if di != dj {
+			return di < dj
+		}
+		return candidates[i].EnqueuedAt.Before(candidates[j].EnqueuedAt)
+	})
+
+	// Every pair of tickets in the match must be within both of their windows, not just within the anchor's
+	windows := make(map[*Ticket]int, len(candidates))
+	for _, t := range candidates {
+		windows[t] = elo.SearchWindow(t, now)
+	}
+	fits := func(picked []*Ticket, next *Ticket) bool {
+		for _, t := range picked {
+			diff := ratingDiff(t, next)
+			if diff > windows[t] || diff > windows[next] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var accept func(rest []*Ticket) bool
+	if elo.config.TeamCount > 0 {
+		accept = func(rest []*Ticket) bool {
+			teams = elo.assignTeams(append([]*Ticket{anchor}, rest...))
+			return teams != nil
+		}
+	}
+	var rest []*Ticket
+	if rest = fill(candidates, elo.config.MatchSize-anchor.Size(), fits, accept); rest == nil {
+		return
+	}
+	tickets = append([]*Ticket{anchor}, rest...)
+	return
+}
+
+// fill returns the candidates, preferring earlier ones, whose sizes add up to exactly size, or nil if none do.
+// If fits is set, a candidate is only picked if it fits with the candidates picked before it.
+// If accept is set, it can reject a fill and the search carries on, until FILL_REJECT_LIMIT fills were rejected
+//
+// Picking greedily can leave a gap that only a smaller ticket fits, for example a party of two can't fill
+// the last seat, so earlier picks are backtracked. Sizes that can't be filled from a candidate onwards
+// are remembered so each is only searched once
+func fill(
+	candidates []*Ticket,
+	size int,
+	fits func(picked []*Ticket, next *Ticket) bool,
+	accept func([]*Ticket) bool,
+) []*Ticket {
+	type state struct {
+		from int
+		size int
+	}
+	unfillable := make(map[state]bool)
+	picked := make([]*Ticket, 0, len(candidates))
+	rejected := 0
+	misfits := 0
+
+	var search func(from int, size int) bool
+	search = func(from int, size int) bool {
+		if rejected >= FILL_REJECT_LIMIT {
+			return false
+		}
+		if size == 0 {
+			if accept == nil || accept(picked) {
+				return true
+			}
+			rejected++
+			return false
+		}
+		if unfillable[state{from, size}] {
+			return false
+		}
+		before := rejected + misfits
+		for i := from; i < len(candidates); i++ {
+			if candidates[i].Size() > size {
+				continue
+			}
+			if fits != nil && !fits(picked, candidates[i]) {
+				misfits++
+				continue
+			}
+			picked = append(picked, candidates[i])
+			if search(i+1, size-candidates[i].Size()) {
+				return true
+			}
+			picked = picked[:len(picked)-1]
+		}
+		// Rejected fills and misfits depend on the earlier picks too, so only sizes with no fill at all are remembered
+		if rejected+misfits == before {
+			unfillable[state{from, size}] = true
+		}
+		return false
+	}
+	if !search(0, size) {
+		return nil
+	}
+	return picked
+}
+
+// assignTeams splits the tickets into TeamCount teams of TeamSize players with the closest average ratings,
+// or returns nil if the tickets can't be split into full teams
+//
+// Assignments are searched largest tickets first, placing each ticket on the lowest rated team with room
+// before trying the others, so the first assignment found is already close. The search stops at a perfect
+// balance or after TEAM_ASSIGNMENT_SEARCH_LIMIT steps and returns the most balanced assignment found
+func (elo *ELO) assignTeams(tickets []*Ticket) (teams [][]*Ticket) {
+	count, size := elo.config.TeamCount, elo.config.TeamSize
+
+	sorted := append([]*Ticket(nil), tickets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Size() != sorted[j].Size() {
+			return sorted[i].Size() > sorted[j].Size()
+		}
+		return sorted[i].Rating > sorted[j].Rating
+	})
+
+	current := make([][]*Ticket, count)
+	seats := make([]int, count)
+	// Every full team has the same number of players, so comparing rating totals compares averages
+	totals := make([]int, count)
+	best := -1
+	steps := 0
+
+	var search func(next int)
+	search = func(next int) {
+		if best == 0 || steps >= TEAM_ASSIGNMENT_SEARCH_LIMIT {
+			return
+		}
+		steps++
+		if next == len(sorted) {
+			if spread := teamSpread(totals); best < 0 || spread < best {
+				best = spread
+				teams = make([][]*Ticket, count)
+				for i := range current {
+					teams[i] = append([]*Ticket(nil), current[i]...)
+				}
+			}
+			return
+		}
+
+		t := sorted[next]
+		order := make([]int, count)
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return totals[order[i]] < totals[order[j]]
+		})
+		triedEmpty := false
+		for _, team := range order {
+			if seats[team]+t.Size() > size {
+				continue
+			}
+			// Empty teams are interchangeable, trying more than one only repeats the search
+			if seats[team] == 0 {
+				if triedEmpty {
+					continue
+				}
+				triedEmpty = true
+			}
+			current[team] = append(current[team], t)
+			seats[team] += t.Size()
+			totals[team] += t.Rating * t.Size()
+			search(next + 1)
+			current[team] = current[team][:len(current[team])-1]
+			seats[team] -= t.Size()
+			totals[team] -= t.Rating * t.Size()
+		}
+	}
+	search(0)
+	return
+}
+
+// maxTicketSize is the most players a ticket can have, a ticket has to fit in a single team
+func (elo *ELO) maxTicketSize() int {
+	if elo.config.TeamCount > 0 {
+		return elo.config.TeamSize
+	}
+	return elo.config.MatchSize
+}
+
+// bucket returns the rating bucket for a rating, rounding down for negative ratings
+func (elo *ELO) bucket(rating int) int {
+	if rating < 0 {
+		return (rating - elo.config.BucketSize + 1) / elo.config.BucketSize
+	}
+	return rating / elo.config.BucketSize
+}
+
+// remove takes a ticket out of the queue, the mutex must be held by the caller
+func (elo *ELO) remove(t *Ticket) {
+	delete(elo.tickets, t.ID)
+	bucket := elo.bucket(t.Rating)
+	tickets := elo.buckets[bucket]
+	for i, queued := range tickets {
+		if queued == t {
+			tickets = append(tickets[:i], tickets[i+1:]...)
+			break
+		}
+	}
+	if len(tickets) == 0 {
+		delete(elo.buckets, bucket)
+	} else {
+		elo.buckets[bucket] = tickets
+	}
+}
+
+// teamSpread is the difference between the highest and lowest team rating totals
+func teamSpread(totals []int) int {
+	min, max := totals[0], totals[0]
+	for _, total := range totals[1:] {
+		if total < min {
+			min = total
+		}
+		if total > max {
+			max = total
+		}
+	}
+	return max - min
+}
+
+func ratingDiff(a, b *Ticket) int {
+	if a.Rating > b.Rating {
+		return a.Rating - b.Rating
+	}
+	return b.Rating - a.Rating
+}
@@ -0,0 +1,222 @@
+package strategy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestELO(t *testing.T) {
+	now := time.Now()
+
+	newTicket := func(id string, rating int, waited time.Duration) *Ticket {
+		return &Ticket{
+			ID:         id,
+			PlayerIDs:  []string{fmt.Sprintf("%s_player", id)},
+			Rating:     rating,
+			EnqueuedAt: now.Add(-waited),
+		}
+	}
+
+	t.Run("enqueue and dequeue", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{})
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 0)))
+		require.ErrorIs(t, elo.Enqueue(newTicket("t1", 1000, 0)), ErrTicketAlreadyQueued)
+		require.ErrorIs(t, elo.Enqueue(&Ticket{ID: "t2"}), ErrTicketInvalid)
+		require.Equal(t, 1, elo.QueueLength())
+
+		require.NoError(t, elo.Dequeue("t1"))
+		require.ErrorIs(t, elo.Dequeue("t1"), ErrTicketNotFound)
+		require.Equal(t, 0, elo.QueueLength())
+		require.Empty(t, elo.buckets)
+	})
+
+	t.Run("closest ratings are matched", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{MatchSize: 2, InitialWindow: 100})
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 3*time.Second)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 1080, 2*time.Second)))
+		require.NoError(t, elo.Enqueue(newTicket("t3", 1020, time.Second)))
+
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 1)
+		require.ElementsMatch(t, []string{"t1_player", "t3_player"}, matches[0].PlayerIDs())
+		require.Equal(t, 1, elo.QueueLength())
+	})
+
+	t.Run("tickets are matched within their region", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{MatchSize: 2})
+
+		t1, t2, t3 := newTicket("t1", 1000, 0), newTicket("t2", 1000, 0), newTicket("t3", 1040, 0)
+		t1.Region, t2.Region, t3.Region = "eu", "us", "eu"
+		require.NoError(t, elo.Enqueue(t1))
+		require.NoError(t, elo.Enqueue(t2))
+		require.NoError(t, elo.Enqueue(t3))
+
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 1)
+		require.ElementsMatch(t, []string{"t1_player", "t3_player"}, matches[0].PlayerIDs())
+		require.Equal(t, "eu", matches[0].Region())
+	})
+
+	t.Run("search window widens while waiting", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{
+			MatchSize:        2,
+			InitialWindow:    50,
+			WindowGrowthPerS: 10,
+			MaxWindow:        200,
+		})
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 0)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 1150, 0)))
+
+		// A 150 rating difference needs both tickets to have waited 10 seconds
+		require.Empty(t, elo.FormMatches(now))
+		require.Empty(t, elo.FormMatches(now.Add(9*time.Second)))
+		require.Len(t, elo.FormMatches(now.Add(10*time.Second)), 1)
+	})
+
+	t.Run("search window is capped", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{
+			MatchSize:        2,
+			InitialWindow:    50,
+			WindowGrowthPerS: 10,
+			MaxWindow:        200,
+		})
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 0)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 1300, 0)))
+		require.Equal(t, 200, elo.SearchWindow(newTicket("t3", 0, time.Hour), now))
+		require.Empty(t, elo.FormMatches(now.Add(time.Hour)))
+	})
+
+	t.Run("matches of N players", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{MatchSize: 4, InitialWindow: 100, BucketSize: 50})
+
+		for i := 0; i < 9; i++ {
+			require.NoError(t, elo.Enqueue(newTicket(fmt.Sprintf("t%d", i), -40+i*10, time.Duration(i)*time.Second)))
+		}
+
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 2)
+		for _, m := range matches {
+			require.Len(t, m.PlayerIDs(), 4)
+		}
+		require.Equal(t, 1, elo.QueueLength())
+	})
+
+	t.Run("every pair of tickets is within their windows", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{MatchSize: 3, InitialWindow: 100})
+
+		// t2 and t3 are both within t1's window, but 180 apart from each other
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 2*time.Second)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 910, time.Second)))
+		require.NoError(t, elo.Enqueue(newTicket("t3", 1090, 0)))
+		require.Empty(t, elo.FormMatches(now))
+
+		require.NoError(t, elo.Enqueue(newTicket("t4", 1050, 0)))
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 1)
+		require.ElementsMatch(t, []string{"t1_player", "t3_player", "t4_player"}, matches[0].PlayerIDs())
+		require.Equal(t, 1, elo.QueueLength())
+	})
+
+	t.Run("parties fill the seats left in a match", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{MatchSize: 4, InitialWindow: 100})
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 3*time.Second)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 1005, 2*time.Second)))
+		require.NoError(t, elo.Enqueue(newTicket("t3", 1010, time.Second)))
+		party := newTicket("party", 1020, 0)
+		party.PlayerIDs = []string{"party_p1", "party_p2"}
+		require.NoError(t, elo.Enqueue(party))
+
+		// Taking the two closest singles would leave a single seat the party can't fill
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 1)
+		require.ElementsMatch(t, []string{"t1_player", "t2_player", "party_p1", "party_p2"}, matches[0].PlayerIDs())
+		require.Equal(t, 1, elo.QueueLength())
+
+		tooBig := newTicket("too_big", 1000, 0)
+		tooBig.PlayerIDs = []string{"p1", "p2", "p3", "p4", "p5"}
+		require.ErrorIs(t, elo.Enqueue(tooBig), ErrTicketInvalid)
+	})
+
+	t.Run("teams are balanced", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{TeamCount: 2, TeamSize: 2, InitialWindow: 100})
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1000, 0)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 1010, 0)))
+		require.NoError(t, elo.Enqueue(newTicket("t3", 1020, 0)))
+		require.NoError(t, elo.Enqueue(newTicket("t4", 1030, 0)))
+
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 1)
+		require.Len(t, matches[0].Teams, 2)
+		require.ElementsMatch(t, [][]string{
+			{"t4_player", "t1_player"},
+			{"t3_player", "t2_player"},
+		}, matches[0].Teams)
+	})
+
+	t.Run("parties are kept on one team", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{TeamCount: 2, TeamSize: 3, InitialWindow: 100})
+
+		for i, rating := range []int{1000, 1010, 1020} {
+			party := newTicket(fmt.Sprintf("party%d", i), rating, time.Duration(3-i)*time.Second)
+			party.PlayerIDs = []string{fmt.Sprintf("party%d_p1", i), fmt.Sprintf("party%d_p2", i)}
+			require.NoError(t, elo.Enqueue(party))
+		}
+		// Three parties of two fill six seats but can't be split into two teams of three
+		require.Empty(t, elo.FormMatches(now))
+
+		require.NoError(t, elo.Enqueue(newTicket("t1", 1005, 0)))
+		require.NoError(t, elo.Enqueue(newTicket("t2", 1015, 0)))
+		matches := elo.FormMatches(now)
+		require.Len(t, matches, 1)
+		require.Len(t, matches[0].PlayerIDs(), 6)
+		for _, team := range matches[0].Teams {
+			require.Len(t, team, 3)
+		}
+		require.ElementsMatch(t, [][]string{
+			{"party0_p1", "party0_p2", "t2_player"},
+			{"party1_p1", "party1_p2", "t1_player"},
+		}, matches[0].Teams)
+		require.Equal(t, 1, elo.QueueLength())
+
+		tooBig := newTicket("too_big", 1000, 0)
+		tooBig.PlayerIDs = []string{"p1", "p2", "p3", "p4"}
+		require.ErrorIs(t, elo.Enqueue(tooBig), ErrTicketInvalid)
+	})
+
+	t.Run("team search is capped for queues that can't be balanced", func(t *testing.T) {
+		elo := NewELOStrategy(ELOConfig{TeamCount: 2, TeamSize: 3, InitialWindow: 500})
+
+		// Parties of two fill six seats in many ways, but never two teams of three
+		for i := 0; i < 200; i++ {
+			party := newTicket(fmt.Sprintf("party%d", i), 1000+i, time.Duration(i)*time.Millisecond)
+			party.PlayerIDs = []string{fmt.Sprintf("party%d_p1", i), fmt.Sprintf("party%d_p2", i)}
+			require.NoError(t, elo.Enqueue(party))
+		}
+
+		anchor := elo.tickets["party0"]
+		var candidates []*Ticket
+		for _, t := range elo.tickets {
+			if t != anchor {
+				candidates = append(candidates, t)
+			}
+		}
+		accepts := 0
+		require.Nil(t, fill(candidates, 4, nil, func(rest []*Ticket) bool {
+			accepts++
+			return elo.assignTeams(append([]*Ticket{anchor}, rest...)) != nil
+		}))
+		require.Equal(t, FILL_REJECT_LIMIT, accepts)
+
+		require.Empty(t, elo.FormMatches(now))
+		require.Equal(t, 200, elo.QueueLength())
+	})
+}
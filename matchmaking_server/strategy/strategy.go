@@ -1,4 +1,73 @@
 package strategy
 
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTicketAlreadyQueued = errors.New("ticket is already queued")
+	ErrTicketNotFound      = errors.New("ticket is not queued")
+	ErrTicketInvalid       = errors.New("ticket must have an ID and at least one player")
+)
+
+// Ticket is a request to be matched, it is queued and matched as a single unit
+//
+// Tickets are only matched with tickets in the same Region
+type Ticket struct {
+	ID         string
+	PlayerIDs  []string
+	Rating     int
+	Region     string
+	EnqueuedAt time.Time
+}
+
+// Size is the number of players on the ticket
+func (t *Ticket) Size() int {
+	return len(t.PlayerIDs)
+}
+
+// Match is a group of tickets that should play a game together
+type Match struct {
+	ID      string
+	Tickets []*Ticket
+	// Teams holds the player IDs on each side of the match, nil if the game mode doesn't have teams.
+	// A ticket's players are always on the same team
+	Teams [][]string
+}
+
+// Region returns the region the match's game should be hosted in
+func (m *Match) Region() string {
+	if len(m.Tickets) == 0 {
+		return ""
+	}
+	return m.Tickets[0].Region
+}
+
+// PlayerIDs returns the IDs of every player in the match
+func (m *Match) PlayerIDs() (playerIDs []string) {
+	for _, t := range m.Tickets {
+		playerIDs = append(playerIDs, t.PlayerIDs...)
+	}
+	return
+}
+
+// SearchWindowReporter can optionally be implemented by a Strategy to report how far a ticket's search
+// reaches, it is sent to players following their ticket
+type SearchWindowReporter interface {
+	// SearchWindow returns the largest rating difference the ticket accepts after waiting until now
+	SearchWindow(t *Ticket, now time.Time) int
+}
+
+// Strategy decides which queued tickets are matched together
+//
+// The matchmaking server enqueues and dequeues tickets as clients request them,
+// and periodically calls FormMatches. Implementations must be safe for concurrent use
 type Strategy interface {
+	Enqueue(t *Ticket) error
+	Dequeue(ticketID string) error
+	// FormMatches runs a match forming pass, tickets in the returned matches are removed from the queue
+	FormMatches(now time.Time) []*Match
+	// QueueLength returns the number of queued tickets
+	QueueLength() int
 }
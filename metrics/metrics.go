@@ -0,0 +1,383 @@
+// Package metrics is a minimal metrics registry that serves the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	METRICS_PATH = "/metrics"
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// DefaultBuckets are histogram buckets in seconds, suited to request and tick latencies
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector writes the samples of a metric family
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics served on a metrics endpoint
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+func NewRegistry() (r *Registry) {
+	r = &Registry{
+		names: make(map[string]bool),
+	}
+	return
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.names[c.name()] {
+		panic(fmt.Sprintf("metrics: %s is already registered", c.name()))
+	}
+	r.names[c.name()] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes every registered metric in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mutex.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry's metrics
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	r.WriteText(w)
+}
+
+// family is the name, help and labels shared by the metrics of a vector
+type family struct {
+	metricName string
+	help       string
+	metricType string
+	labels     []string
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.metricType)
+}
+
+// vec holds one child metric per combination of label values
+type vec struct {
+	family
+	mutex    sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(name string, help string, metricType string, labels []string) vec {
+	return vec{
+		family:   family{metricName: name, help: help, metricType: metricType, labels: labels},
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+	}
+}
+
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mutex.RLock()
+	c, exists := v.children[key]
+	v.mutex.RUnlock()
+	if exists {
+		return c
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if c, exists = v.children[key]; !exists {
+		c = create()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// each calls fn for every child, sorted by label values so the output is stable
+func (v *vec) each(fn func(values []string, child interface{})) {
+	v.mutex.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mutex.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mutex.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mutex.RUnlock()
+		fn(values, child)
+	}
+}
+
+// value is a float64 that can be updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (v *value) set(val float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(val))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add increases the counter, negative values are ignored
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.add(delta)
+	}
+}
+
+type CounterVec struct {
+	vec
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) (cv *CounterVec) {
+	cv = &CounterVec{vec: newVec(name, help, "counter", labels)}
+	r.register(cv)
+	return
+}
+
+// WithLabelValues returns the counter for the label values, given in the order the labels were declared
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	return cv.child(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (cv *CounterVec) write(w *bufio.Writer) {
+	cv.writeHeader(w)
+	cv.each(func(values []string, child interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.metricName, formatLabels(cv.labels, values, "", ""), formatValue(child.(*Counter).get()))
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Set(val float64) {
+	g.set(val)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) (gv *GaugeVec) {
+	gv = &GaugeVec{vec: newVec(name, help, "gauge", labels)}
+	r.register(gv)
+	return
+}
+
+func (gv *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return gv.child(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (gv *GaugeVec) write(w *bufio.Writer) {
+	gv.writeHeader(w)
+	gv.each(func(values []string, child interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", gv.metricName, formatLabels(gv.labels, values, "", ""), formatValue(child.(*Gauge).get()))
+	})
+}
+
+// gaugeFunc is a gauge whose value is read when the metrics are collected
+type gaugeFunc struct {
+	family
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge that calls fn for its value on every collection
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(&gaugeFunc{
+		family: family{metricName: name, help: help, metricType: "gauge"},
+		fn:     fn,
+	})
+}
+
+func (gf *gaugeFunc) write(w *bufio.Writer) {
+	gf.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", gf.metricName, formatValue(gf.fn()))
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     value
+}
+
+func (h *Histogram) Observe(val float64) {
+	for i, upper := range h.buckets {
+		if val <= upper {
+			atomic.AddUint64(&h.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(val)
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram, buckets are upper bounds and default to DefaultBuckets if nil
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) (hv *HistogramVec) {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	hv = &HistogramVec{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+	}
+	r.register(hv)
+	return
+}
+
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return hv.child(values, func() interface{} {
+		return &Histogram{
+			buckets: hv.buckets,
+			counts:  make([]uint64, len(hv.buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (hv *HistogramVec) write(w *bufio.Writer) {
+	hv.writeHeader(w)
+	hv.each(func(values []string, child interface{}) {
+		h := child.(*Histogram)
+		var cumulative uint64
+		for i, upper := range hv.buckets {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.metricName, formatLabels(hv.labels, values, "le", formatValue(upper)), cumulative)
+		}
+		count := atomic.LoadUint64(&h.count)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.metricName, formatLabels(hv.labels, values, "le", "+Inf"), count)
+		labels := formatLabels(hv.labels, values, "", "")
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.metricName, labels, formatValue(h.sum.get()))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.metricName, labels, count)
+	})
+}
+
+// formatLabels formats label pairs as {a="1",b="2"}, with an optional extra label appended
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=\"%s\"", name, escapeLabelValue(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=\"%s\"", extraName, escapeLabelValue(extraValue))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatValue(val float64) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "+Inf"
+	case math.IsInf(val, -1):
+		return "-Inf"
+	case math.IsNaN(val):
+		return "NaN"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("counters and gauges", func(t *testing.T) {
+		r := NewRegistry()
+		games := r.NewCounterVec("games_total", "Games created.", "game_type")
+		games.WithLabelValues("duel").Inc()
+		games.WithLabelValues("duel").Add(2)
+		games.WithLabelValues("arena").Inc()
+		games.WithLabelValues("arena").Add(-1)
+
+		players := r.NewGaugeVec("players", "Players online.")
+		players.WithLabelValues().Set(5)
+		players.WithLabelValues().Dec()
+
+		r.NewGaugeFunc("queue_length", "Queued tickets.", func() float64 { return 7 })
+
+		var buf bytes.Buffer
+		require.NoError(t, r.WriteText(&buf))
+		require.Equal(t, strings.Join([]string{
+			"# HELP games_total Games created.",
+			"# TYPE games_total counter",
+			`games_total{game_type="arena"} 1`,
+			`games_total{game_type="duel"} 3`,
+			"# HELP players Players online.",
+			"# TYPE players gauge",
+			"players 4",
+			"# HELP queue_length Queued tickets.",
+			"# TYPE queue_length gauge",
+			"queue_length 7",
+			"",
+		}, "\n"), buf.String())
+	})
+
+	t.Run("histograms", func(t *testing.T) {
+		r := NewRegistry()
+		h := r.NewHistogramVec("tick_seconds", "Tick duration.", []float64{0.1, 0.01}, "game_type")
+		h.WithLabelValues("duel").Observe(0.005)
+		h.WithLabelValues("duel").Observe(0.05)
+		h.WithLabelValues("duel").Observe(1)
+
+		var buf bytes.Buffer
+		require.NoError(t, r.WriteText(&buf))
+		require.Equal(t, strings.Join([]string{
+			"# HELP tick_seconds Tick duration.",
+			"# TYPE tick_seconds histogram",
+			`tick_seconds_bucket{game_type="duel",le="0.01"} 1`,
+			`tick_seconds_bucket{game_type="duel",le="0.1"} 2`,
+			`tick_seconds_bucket{game_type="duel",le="+Inf"} 3`,
+			`tick_seconds_sum{game_type="duel"} 1.055`,
+			`tick_seconds_count{game_type="duel"} 3`,
+			"",
+		}, "\n"), buf.String())
+	})
+
+	t.Run("label values are escaped", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounterVec("errors_total", "Errors.\nBy reason.", "reason").WithLabelValues("bad \"input\"\n\\").Inc()
+
+		var buf bytes.Buffer
+		require.NoError(t, r.WriteText(&buf))
+		require.Contains(t, buf.String(), `# HELP errors_total Errors.\nBy reason.`)
+		require.Contains(t, buf.String(), `errors_total{reason="bad \"input\"\n\\"} 1`)
+	})
+
+	t.Run("invalid use panics", func(t *testing.T) {
+		r := NewRegistry()
+		cv := r.NewCounterVec("requests_total", "Requests.", "path")
+		require.Panics(t, func() { r.NewCounterVec("requests_total", "Requests.") })
+		require.Panics(t, func() { cv.WithLabelValues("/a", "/b") })
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounterVec("requests_total", "Requests.").WithLabelValues().Inc()
+
+		w := httptest.NewRecorder()
+		r.Handler(w, httptest.NewRequest(http.MethodGet, METRICS_PATH, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, CONTENT_TYPE, w.Header().Get("Content-Type"))
+		require.Contains(t, w.Body.String(), "requests_total 1\n")
+	})
+}
@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/gunnermanx/simplegameserver/matchmaking_server (interfaces: Allocator)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	common "github.com/gunnermanx/simplegameserver/common"
+)
+
+// MockAllocator is a mock of Allocator interface.
+type MockAllocator struct {
+	ctrl     *gomock.Controller
+	recorder *MockAllocatorMockRecorder
+}
+
+// MockAllocatorMockRecorder is the mock recorder for MockAllocator.
+type MockAllocatorMockRecorder struct {
+	mock *MockAllocator
+}
+
+// NewMockAllocator creates a new mock instance.
+func NewMockAllocator(ctrl *gomock.Controller) *MockAllocator {
+	mock := &MockAllocator{ctrl: ctrl}
+	mock.recorder = &MockAllocatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAllocator) EXPECT() *MockAllocatorMockRecorder {
+	return m.recorder
+}
+
+// Allocate mocks base method.
+func (m *MockAllocator) Allocate(arg0 context.Context, arg1 string, arg2 common.AllocateGameRequest) (common.AllocateGameResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Allocate", arg0, arg1, arg2)
+	ret0, _ := ret[0].(common.AllocateGameResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Allocate indicates an expected call of Allocate.
+func (mr *MockAllocatorMockRecorder) Allocate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Allocate", reflect.TypeOf((*MockAllocator)(nil).Allocate), arg0, arg1, arg2)
+}
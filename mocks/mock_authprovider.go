@@ -50,6 +50,21 @@ func (mr *MockAuthProviderMockRecorder) AuthenticateRequest(arg0, arg1 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthenticateRequest", reflect.TypeOf((*MockAuthProvider)(nil).AuthenticateRequest), arg0, arg1)
 }
 
+// GetRolesFromRequest mocks base method.
+func (m *MockAuthProvider) GetRolesFromRequest(arg0 *http.Request) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRolesFromRequest", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRolesFromRequest indicates an expected call of GetRolesFromRequest.
+func (mr *MockAuthProviderMockRecorder) GetRolesFromRequest(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRolesFromRequest", reflect.TypeOf((*MockAuthProvider)(nil).GetRolesFromRequest), arg0)
+}
+
 // GetUIDFromRequest mocks base method.
 func (m *MockAuthProvider) GetUIDFromRequest(arg0 *http.Request) (string, error) {
 	m.ctrl.T.Helper()
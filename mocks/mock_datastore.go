@@ -34,6 +34,20 @@ func (m *MockDatastore) EXPECT() *MockDatastoreMockRecorder {
 	return m.recorder
 }
 
+// CreateUser mocks base method.
+func (m *MockDatastore) CreateUser(arg0 model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUser", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUser indicates an expected call of CreateUser.
+func (mr *MockDatastoreMockRecorder) CreateUser(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockDatastore)(nil).CreateUser), arg0)
+}
+
 // FindMatchmakingData mocks base method.
 func (m *MockDatastore) FindMatchmakingData(arg0 string) (model.MatchmakingData, error) {
 	m.ctrl.T.Helper()
@@ -63,3 +77,74 @@ func (mr *MockDatastoreMockRecorder) FindUser(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindUser", reflect.TypeOf((*MockDatastore)(nil).FindUser), arg0)
 }
+
+// RevokeRefreshTokenFamily mocks base method.
+func (m *MockDatastore) RevokeRefreshTokenFamily(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshTokenFamily", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshTokenFamily indicates an expected call of RevokeRefreshTokenFamily.
+func (mr *MockDatastoreMockRecorder) RevokeRefreshTokenFamily(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshTokenFamily", reflect.TypeOf((*MockDatastore)(nil).RevokeRefreshTokenFamily), arg0)
+}
+
+// SaveGameResult mocks base method.
+func (m *MockDatastore) SaveGameResult(arg0 model.GameResult) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveGameResult", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveGameResult indicates an expected call of SaveGameResult.
+func (mr *MockDatastoreMockRecorder) SaveGameResult(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveGameResult", reflect.TypeOf((*MockDatastore)(nil).SaveGameResult), arg0)
+}
+
+// SaveRefreshToken mocks base method.
+func (m *MockDatastore) SaveRefreshToken(arg0 model.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRefreshToken", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRefreshToken indicates an expected call of SaveRefreshToken.
+func (mr *MockDatastoreMockRecorder) SaveRefreshToken(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRefreshToken", reflect.TypeOf((*MockDatastore)(nil).SaveRefreshToken), arg0)
+}
+
+// UpdateMatchmakingData mocks base method.
+func (m *MockDatastore) UpdateMatchmakingData(arg0 []string, arg1 func(map[string]model.MatchmakingData) (map[string]model.MatchmakingData, error)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMatchmakingData", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMatchmakingData indicates an expected call of UpdateMatchmakingData.
+func (mr *MockDatastoreMockRecorder) UpdateMatchmakingData(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMatchmakingData", reflect.TypeOf((*MockDatastore)(nil).UpdateMatchmakingData), arg0, arg1)
+}
+
+// UseRefreshToken mocks base method.
+func (m *MockDatastore) UseRefreshToken(arg0 string) (model.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRefreshToken", arg0)
+	ret0, _ := ret[0].(model.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRefreshToken indicates an expected call of UseRefreshToken.
+func (mr *MockDatastoreMockRecorder) UseRefreshToken(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRefreshToken", reflect.TypeOf((*MockDatastore)(nil).UseRefreshToken), arg0)
+}